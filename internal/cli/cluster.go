@@ -0,0 +1,290 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	edgeVPNClient "github.com/mudler/edgevpn/api/client"
+	"github.com/mudler/edgevpn/api/client/service"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// clusterSecretFlags open the cluster credentials sealed in the ledger.
+var clusterSecretFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "network-token",
+		Usage:   "Network token of the cluster, required with --cluster-secret",
+		EnvVars: []string{"NETWORK_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "cluster-secret",
+		EnvVars: []string{"CLUSTER_SECRET"},
+	},
+}
+
+// clusterKeyring returns the keyring opening the sealed credentials. Its
+// keys are derived from the network token too, which is then required.
+func clusterKeyring(c *cli.Context) (*ledger.Keyring, error) {
+	if c.String("network-token") == "" {
+		return nil, errors.New("--network-token is required to open the credentials sealed with --cluster-secret")
+	}
+	return ledger.NewKeyring(c.String("network-token"), c.String("cluster-secret"))
+}
+
+// networkLedger returns the ledger of the network, opening the sealed
+// credentials if a cluster secret is given.
+func networkLedger(c *cli.Context) (ledger.Ledger, error) {
+	cc := service.NewClient(
+		c.String("network-id"),
+		edgeVPNClient.NewClient(edgeVPNClient.WithHost(c.String("api"))))
+	l := ledger.NewEdgeVPN(cc, c.String("network-id"))
+	if c.String("cluster-secret") == "" {
+		return l, nil
+	}
+	keys, err := clusterKeyring(c)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.NewSealed(l, keys), nil
+}
+
+// authenticatedLedger returns the ledger of the network for the values only
+// the holders of the cluster secret may write, like the given ones. The
+// cluster secret is then required.
+func authenticatedLedger(c *cli.Context, what string) (ledger.Ledger, error) {
+	if c.String("cluster-secret") == "" {
+		return nil, fmt.Errorf("--cluster-secret is required, %s are sealed with it", what)
+	}
+	return networkLedger(c)
+}
+
+// releaseChecksums returns the checksums pinned in an upgrade request, read
+// from the given location or else from the upstream release.
+func releaseChecksums(version, location string) ([]byte, error) {
+	if location != "" {
+		r, err := installer.Fetch(installer.Options{}, location)
+		if err != nil {
+			return nil, fmt.Errorf("fetching the checksums: %w", err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	distro, err := installer.DistroOf(version)
+	if err != nil {
+		return nil, err
+	}
+	return installer.ReleaseChecksums(distro, version, installer.Options{})
+}
+
+var outputFlag = &cli.StringFlag{
+	Name:  "output",
+	Usage: "Output format: table, json or yaml",
+	Value: "table",
+}
+
+// yesNo renders booleans in tables, with a dash for unknown values.
+func yesNo(b *bool) string {
+	switch {
+	case b == nil:
+		return "-"
+	case *b:
+		return "yes"
+	}
+	return "no"
+}
+
+func printClusterStatus(s role.ClusterStatus, output string) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "yaml":
+		return yaml.NewEncoder(os.Stdout).Encode(s)
+	case "table":
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	if s.Leader != "" {
+		fmt.Printf("Leader: %s (term %d)\n\n", s.Leader, s.Term)
+	} else {
+		fmt.Print("Leader: none\n\n")
+	}
+	fmt.Printf("%-36s  %-20s  %-18s  %-15s  %-6s  %-11s  %-12s  %-25s  %-5s  %-18s\n",
+		"Node", "Hostname", "Role", "IP", "Active", "Advertizing", "Bootstrapped", "Distro", "Ready", "Phase")
+	for _, n := range s.Nodes {
+		uuid := n.UUID
+		if n.Leader {
+			uuid += " *"
+		}
+		distro := n.Distro
+		if n.Version != "" {
+			distro += " " + n.Version
+		}
+		phase := "-"
+		if n.Progress != nil {
+			phase = n.Progress.Phase
+		}
+		fmt.Printf("%-36s  %-20s  %-18s  %-15s  %-6s  %-11s  %-12s  %-25s  %-5s  %-18s\n",
+			uuid, n.Hostname, n.Role, n.IP, yesNo(&n.Active), yesNo(&n.Advertizing), yesNo(&n.Bootstrapped), distro, yesNo(n.Ready), phase)
+	}
+
+	// Tell why nodes are stuck below the table, the messages don't fit in it
+	for _, n := range s.Nodes {
+		switch {
+		case n.Progress == nil:
+		case n.Progress.Error != "":
+			fmt.Printf("\n%s failed at %s (%s): %s", n.UUID, n.Progress.Phase, n.Progress.UpdatedAt.Format(time.RFC3339), n.Progress.Error)
+		case n.Progress.Waiting != "":
+			fmt.Printf("\n%s waiting at %s since %s: %s", n.UUID, n.Progress.Phase, n.Progress.UpdatedAt.Format(time.RFC3339), n.Progress.Waiting)
+		}
+	}
+	fmt.Println()
+	if s.KubernetesError != "" {
+		fmt.Printf("\nKubernetes node status unavailable: %s\n", s.KubernetesError)
+	}
+	return nil
+}
+
+func printUpgradeStatus(s role.UpgradeStatus, output string) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "yaml":
+		return yaml.NewEncoder(os.Stdout).Encode(s)
+	case "table":
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	switch {
+	case s.Plan != nil:
+		fmt.Printf("Upgrade to %s: %s (updated %s)\n", s.Plan.Version, s.Plan.Phase, s.Plan.UpdatedAt.Format(time.RFC3339))
+		if s.Request != nil && s.Request.RequestedAt.After(s.Plan.RequestedAt) {
+			fmt.Printf("Upgrade to %s requested, waiting for the leader\n", s.Request.Version)
+		}
+	case s.Request != nil:
+		fmt.Printf("Upgrade to %s requested, waiting for the leader\n", s.Request.Version)
+	default:
+		fmt.Println("No upgrade requested")
+	}
+	fmt.Println()
+
+	fmt.Printf("%-36s  %-20s  %-18s  %-25s  %-12s\n", "Node", "Hostname", "Role", "Version", "Upgrade")
+	for _, n := range s.Nodes {
+		uuid := n.UUID
+		if s.Plan != nil && s.Plan.Node == n.UUID {
+			uuid += " *"
+		}
+		upgrade := "-"
+		if n.Upgrade != nil {
+			upgrade = n.Upgrade.Phase
+		}
+		fmt.Printf("%-36s  %-20s  %-18s  %-25s  %-12s\n", uuid, n.Hostname, n.Role, n.Version, upgrade)
+	}
+
+	if s.Plan == nil {
+		return nil
+	}
+	switch {
+	case s.Plan.Error != "":
+		fmt.Printf("\nThe upgrade stopped: %s\n", s.Plan.Error)
+	case s.Plan.Waiting != "":
+		fmt.Printf("\nWaiting for %s\n", s.Plan.Waiting)
+	}
+	return nil
+}
+
+var ClusterCMD = cli.Command{
+	Name:  "cluster",
+	Usage: "Inspect the cluster of a network",
+	Subcommands: []*cli.Command{
+		{
+			Flags:     append(append([]cli.Flag{outputFlag}, clusterSecretFlags...), networkAPI...),
+			Name:      "status",
+			Usage:     "Show the health of the cluster",
+			UsageText: "kairos cluster status [--output table|json|yaml]",
+			Description: `
+		Shows the nodes of the network (only for automated deployments): the role assigned to them, whether they are active or only advertizing, whether they finished bootstrapping, the distribution they run and whether their Kubernetes node is Ready.
+
+		Nodes publish the progress of their bootstrap, the step they are at, what they are waiting for and the last error, which are shown too.
+
+		The Kubernetes node status is read with the kubeconfig published by the master, and the kubectl of the local distribution.
+
+		Example:
+
+		$ kairos cluster status --output json
+		`,
+			Action: func(c *cli.Context) error {
+				l, err := networkLedger(c)
+				if err != nil {
+					return err
+				}
+				s := role.GetClusterStatus(l, func() (*role.Kubectl, error) { return role.LedgerKubectl(l) })
+				return printClusterStatus(s, c.String("output"))
+			},
+		},
+		{
+			Flags: append(append([]cli.Flag{
+				outputFlag,
+				&cli.StringFlag{
+					Name:  "version",
+					Usage: "Version of the distribution to roll out, e.g. v1.30.2+k3s1",
+				},
+				&cli.StringFlag{
+					Name:  "source",
+					Usage: "Mirror of the release artifacts, as an http(s) or file:// URL",
+				},
+				&cli.StringFlag{
+					Name:  "checksums",
+					Usage: "sha256sum list of the release artifacts, as an http(s) or file:// URL. Defaults to the lists of the upstream release",
+				},
+			}, clusterSecretFlags...), networkAPI...),
+			Name:      "upgrade",
+			Usage:     "Upgrade the Kubernetes distribution of the cluster",
+			UsageText: "kairos cluster upgrade --cluster-secret secret [--version v1.30.2+k3s1] [--output table|json|yaml]",
+			Description: `
+		Rolls out a version of k3s or k0s to the nodes of the network (only for automated deployments), or shows the progress of the rollout when no version is given.
+
+		The leader upgrades the nodes one at a time, the masters first and then the workers. Each node is cordoned and drained, installs the new version and restarts its service. The next node has its turn once the Kubernetes node is Ready and runs the new version, and is uncordoned. The rollout stops at the first node failing to upgrade, or not Ready with the new version within 30 minutes, requesting the upgrade again retries it.
+
+		The requests and the rollout are sealed with the cluster secret, nodes refuse the ones which are not. The checksums of the upstream release are pinned in the request, so that the artifacts fetched from a mirror are verified against them. Pass --checksums when the upstream releases can't be reached.
+
+		Example:
+
+		$ kairos cluster upgrade --cluster-secret secret --version v1.30.2+k3s1
+		$ kairos cluster upgrade --cluster-secret secret --version v1.30.2+k3s1 --source https://mirror.local/k3s --checksums file:///tmp/sha256sum-amd64.txt
+		$ kairos cluster upgrade
+		`,
+			Action: func(c *cli.Context) error {
+				l, err := authenticatedLedger(c, "the upgrades")
+				if err != nil {
+					return err
+				}
+				if v := c.String("version"); v != "" {
+					sums, err := releaseChecksums(v, c.String("checksums"))
+					if err != nil {
+						return err
+					}
+					if err := role.RequestUpgrade(l, v, c.String("source"), string(sums)); err != nil {
+						return err
+					}
+					fmt.Printf("Upgrade to %s requested\n", v)
+					return nil
+				}
+				return printUpgradeStatus(role.GetUpgradeStatus(l), c.String("output"))
+			},
+		},
+	},
+}
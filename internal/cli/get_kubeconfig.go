@@ -2,9 +2,11 @@ package cli
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	edgeVPNClient "github.com/mudler/edgevpn/api/client"
 	"github.com/mudler/edgevpn/api/client/service"
 	"github.com/urfave/cli/v2"
@@ -22,13 +24,34 @@ var GetKubeConfigCMD = cli.Command{
 		For example:
 		
 		$ kairos get-kubeconfig --network-id kairos
+
+		If the cluster credentials are sealed with a cluster secret, the network token and the cluster secret are needed to open them:
+
+		$ kairos get-kubeconfig --network-token <TOKEN> --cluster-secret <SECRET>
 		`,
-	Flags: networkAPI,
+	Flags: append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
 	Action: func(c *cli.Context) error {
+		var keys *ledger.Keyring
+		if c.String("cluster-secret") != "" {
+			var err error
+			if keys, err = clusterKeyring(c); err != nil {
+				return err
+			}
+		}
+
 		cc := service.NewClient(
 			c.String("network-id"),
 			edgeVPNClient.NewClient(edgeVPNClient.WithHost(c.String("api"))))
 		str, _ := cc.Get("kubeconfig", "master")
+		if ledger.IsSealed(str) {
+			if keys == nil {
+				return errors.New("the kubeconfig is sealed, a cluster secret is required")
+			}
+			var err error
+			if str, err = keys.Open("kubeconfig", "master", str); err != nil {
+				return err
+			}
+		}
 		b, _ := base64.RawURLEncoding.DecodeString(str)
 		masterIP, _ := cc.Get("master", "ip")
 		fmt.Println(strings.ReplaceAll(string(b), "127.0.0.1", masterIP))
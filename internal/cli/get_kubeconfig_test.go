@@ -0,0 +1,17 @@
+package cli_test
+
+import (
+	. "github.com/kairos-io/provider-kairos/v2/internal/cli"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+var _ = Describe("get-kubeconfig", func() {
+	It("requires the network token with a cluster secret", func() {
+		GinkgoT().Setenv("NETWORK_TOKEN", "")
+		app := &cli.App{Commands: []*cli.Command{&GetKubeConfigCMD}}
+		err := app.Run([]string{"kairos", "get-kubeconfig", "--cluster-secret", "secret", "--api", "127.0.0.1:1"})
+		Expect(err).To(MatchError(ContainSubstring("--network-token is required")))
+	})
+})
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/urfave/cli/v2"
+)
+
+var NodeCMD = cli.Command{
+	Name:  "node",
+	Usage: "Manage the nodes of a network",
+	Subcommands: []*cli.Command{
+		{
+			Flags:     append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
+			Name:      "remove",
+			Usage:     "Remove a node from the cluster",
+			UsageText: "kairos node remove <UUID>",
+			Description: `
+		Removes a node which left the network for good (only for automated deployments).
+
+		The leader cordons and drains the node, deletes it from Kubernetes, removes its etcd member if it is part of the control plane and prunes its entries from the network. The node won't be assigned a role again, until it is restored with "kairos node restore". The removal requests are sealed with the cluster secret, which is required along with the network token.
+
+		Example:
+
+		$ kairos node remove --network-token <TOKEN> --cluster-secret <SECRET> <UUID>
+		`,
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 1 {
+					return errors.New("a node UUID is required")
+				}
+				l, err := authenticatedLedger(c, "the removal requests")
+				if err != nil {
+					return err
+				}
+				return role.RequestRemoval(l, c.Args().Get(0))
+			},
+		},
+		{
+			Flags:     append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
+			Name:      "restore",
+			Usage:     "Let a removed node join the cluster again",
+			UsageText: "kairos node restore <UUID>",
+			Description: `
+		Lets a node removed with "kairos node remove" be assigned a role again, or cancels its removal if it didn't happen yet. With p2p.admission, the node has to be approved again.
+
+		Example:
+
+		$ kairos node restore --network-token <TOKEN> --cluster-secret <SECRET> <UUID>
+		`,
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 1 {
+					return errors.New("a node UUID is required")
+				}
+				l, err := authenticatedLedger(c, "the removal requests")
+				if err != nil {
+					return err
+				}
+				return role.RestoreNode(l, c.Args().Get(0))
+			},
+		},
+		{
+			Flags:     append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
+			Name:      "pending",
+			Usage:     "List the nodes waiting for an approval",
+			UsageText: "kairos node pending",
+			Description: `
+		Lists the nodes waiting to be approved before they get a role, when p2p.admission is enabled. The admission decisions are sealed with the cluster secret, which is required along with the network token.
+		`,
+			Action: func(c *cli.Context) error {
+				l, err := authenticatedLedger(c, "the admission decisions")
+				if err != nil {
+					return err
+				}
+				pending, err := role.PendingNodes(l)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-47s  %-30s  %-15s\n", "Node", "Hostname", "IP")
+				fmt.Printf("%s  %s  %s\n",
+					"-----------------------------------------------",
+					"------------------------------",
+					"---------------")
+				for _, u := range pending {
+					facts, _ := role.GetFacts(l, u)
+					ip, _ := l.Get("ip", u)
+					fmt.Printf("%-47s  %-30s  %-15s\n", u, facts.Hostname, ip)
+				}
+				return nil
+			},
+		},
+		{
+			Flags:     append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
+			Name:      "approve",
+			Usage:     "Approve a node to get a role",
+			UsageText: "kairos node approve <UUID>",
+			Description: `
+		Approves a node waiting in the pending list, the leader assigns it a role on the next round.
+
+		Example:
+
+		$ kairos node approve --network-token <TOKEN> --cluster-secret <SECRET> <UUID>
+		`,
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 1 {
+					return errors.New("a node UUID is required")
+				}
+				l, err := authenticatedLedger(c, "the admission decisions")
+				if err != nil {
+					return err
+				}
+				return role.ApproveNode(l, c.Args().Get(0))
+			},
+		},
+		{
+			Flags:     append(append([]cli.Flag{}, clusterSecretFlags...), networkAPI...),
+			Name:      "reject",
+			Usage:     "Reject a node",
+			UsageText: "kairos node reject <UUID>",
+			Description: `
+		Rejects a node, which won't be assigned a role. A node which already joined the cluster has to be removed with "kairos node remove".
+
+		Example:
+
+		$ kairos node reject --network-token <TOKEN> --cluster-secret <SECRET> <UUID>
+		`,
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 1 {
+					return errors.New("a node UUID is required")
+				}
+				l, err := authenticatedLedger(c, "the admission decisions")
+				if err != nil {
+					return err
+				}
+				return role.RejectNode(l, c.Args().Get(0))
+			},
+		},
+	},
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/provider"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	p2p "github.com/kairos-io/provider-kairos/v2/internal/role/p2p"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// planDetector pretends the binary of the chosen distribution is installed.
+type planDetector string
+
+func (d planDetector) bin(distro string) string {
+	if string(d) != distro {
+		return ""
+	}
+	return filepath.Join("/usr/bin", distro)
+}
+
+func (d planDetector) K3sBin() string  { return d.bin(p2p.K3sDistroName) }
+func (d planDetector) K0sBin() string  { return d.bin(p2p.K0sDistroName) }
+func (d planDetector) RKE2Bin() string { return d.bin(p2p.RKE2DistroName) }
+
+// ClusterPlan is what a node would do with a config.
+type ClusterPlan struct {
+	// Outcome tells how the role of the node is decided
+	Outcome string         `json:"outcome" yaml:"outcome"`
+	Plans   []p2p.NodePlan `json:"plans" yaml:"plans"`
+}
+
+// planConfig renders the plan of a node for each role it could get.
+func planConfig(c *providerConfig.Config, opts p2p.PlanOptions, apiAddress string) (ClusterPlan, error) {
+	if !c.IsP2PConfigured() && !c.IsKubernetesConfigured() {
+		return ClusterPlan{}, fmt.Errorf("no p2p or kubernetes configured")
+	}
+
+	standalone := p2p.IsStandalone(c)
+	// The edgevpn env is written before any role is bootstrapped. Standalone
+	// nodes only run edgevpn for the VPN, the others at least for the API.
+	vpn := func() (*p2p.MemoryHost, error) {
+		h := p2p.NewMemoryHost(opts.IP)
+		switch {
+		case c.P2P != nil && c.P2P.VPNNeedsCreation():
+			return h, p2p.WriteEnv(h, provider.EdgeVPNEnvFile, provider.VPNEnv(apiAddress, c))
+		case !standalone:
+			return h, p2p.WriteEnv(h, provider.EdgeVPNEnvFile, provider.APIEnv(apiAddress, c))
+		}
+		return h, nil
+	}
+
+	if standalone {
+		h, err := vpn()
+		if err != nil {
+			return ClusterPlan{}, err
+		}
+		opts.Host = h
+		plan, err := p2p.PlanStandalone(c, opts)
+		return ClusterPlan{Outcome: "deployed once, without coordinating with other nodes", Plans: []p2p.NodePlan{plan}}, err
+	}
+	if c.P2P.NetworkToken == "" {
+		return ClusterPlan{}, fmt.Errorf("no network token provided, or kubernetes distribution (k3s, k0s, rke2) block configured")
+	}
+
+	roles, outcome := p2p.PlanRoles(c, opts)
+	plan := ClusterPlan{Outcome: outcome}
+	for _, r := range roles {
+		h, err := vpn()
+		if err != nil {
+			return plan, err
+		}
+		opts.Host = h
+		p, err := p2p.Plan(c, r, opts)
+		if err != nil {
+			return plan, err
+		}
+		plan.Plans = append(plan.Plans, p)
+	}
+	return plan, nil
+}
+
+func printPlan(p ClusterPlan, output string) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case "yaml":
+		return yaml.NewEncoder(os.Stdout).Encode(p)
+	case "table":
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	fmt.Printf("Role: %s\n", p.Outcome)
+	for _, n := range p.Plans {
+		fmt.Printf("\n=== %s (%s, service %s)\n", n.Role, n.Distro, n.Service)
+		if n.Command != "" {
+			fmt.Printf("\nCommand:\n  %s\n", n.Command)
+		}
+		for _, name := range slices.Sorted(maps.Keys(n.Files)) {
+			fmt.Printf("\n--- %s\n%s", name, n.Files[name])
+			if !strings.HasSuffix(n.Files[name], "\n") {
+				fmt.Println()
+			}
+		}
+		if n.Error != "" {
+			fmt.Printf("\nThe bootstrap would stop: %s\n", n.Error)
+		}
+	}
+	return nil
+}
+
+var PlanCMD = cli.Command{
+	Name:      "plan",
+	Usage:     "Show what a node would do with a config",
+	UsageText: "kairos plan [--distro k3s|k0s|rke2] [--hostname name] [--output table|json|yaml] config.yaml",
+	Description: `
+		Renders the bootstrap of a node with the given cloud config, without touching the machine: the env files, config files and manifests it would write, the command line of the Kubernetes service and the role it would get.
+
+		Roles assigned by the leader can't be known in advance, so each of the roles the node could get is rendered. Placement rules and worker pools are matched against the --uuid and --hostname flags and the labels of the config.
+
+		The data published by other nodes at runtime, like the master IP and the tokens, is shown as placeholders.
+
+		Example:
+
+		$ kairos plan --distro k3s --hostname edge-1 cloud-config.yaml
+		`,
+	Flags: []cli.Flag{
+		outputFlag,
+		&cli.StringFlag{
+			Name:  "distro",
+			Usage: "Kubernetes distribution installed on the node: k3s, k0s or rke2",
+			Value: p2p.K3sDistroName,
+		},
+		&cli.StringFlag{
+			Name:  "uuid",
+			Usage: "Machine UUID of the node",
+			Value: "<uuid>",
+		},
+		&cli.StringFlag{
+			Name:  "hostname",
+			Usage: "Hostname of the node",
+		},
+		&cli.StringFlag{
+			Name:  "ip",
+			Usage: "IP address of the node interfaces",
+			Value: "10.1.0.2",
+		},
+		&cli.StringFlag{
+			Name:  "api",
+			Usage: "edgevpn API address",
+			Value: provider.DefaultEdgeVPNAPIAddress,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("plan takes a config file")
+		}
+		dat, err := os.ReadFile(c.Args().First())
+		if err != nil {
+			return err
+		}
+		config := &providerConfig.Config{}
+		if err := yaml.Unmarshal(dat, config); err != nil {
+			return fmt.Errorf("failed reading the config: %w", err)
+		}
+
+		switch c.String("distro") {
+		case p2p.K3sDistroName, p2p.K0sDistroName, p2p.RKE2DistroName:
+		default:
+			return fmt.Errorf("unknown distribution %q", c.String("distro"))
+		}
+
+		plan, err := planConfig(config, p2p.PlanOptions{
+			UUID:     c.String("uuid"),
+			Hostname: c.String("hostname"),
+			IP:       c.String("ip"),
+			Detector: planDetector(c.String("distro")),
+		}, c.String("api"))
+		if err != nil {
+			return err
+		}
+		return printPlan(plan, c.String("output"))
+	},
+}
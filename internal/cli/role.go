@@ -22,6 +22,8 @@ var RoleCMD = cli.Command{
 
 		A role must be set prior to the node joining a network. You can retrieve a node UUID by running "kairos uuid".
 
+		Roles set this way are not signed, so they are refused and cleared by the leader when the nodes are configured with a p2p.identity.
+
 		Example:
 
 		$ (node A) kairos uuid
@@ -1,10 +1,16 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/kairos-io/provider-kairos/v2/internal/provider"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
@@ -87,18 +93,85 @@ var GenerateTokenCMD = cli.Command{
 	},
 }
 
+// readConfigSource reads a cloud config from a URL, a file, or the
+// argument itself, like schema.Validate does.
+func readConfigSource(source string) ([]byte, error) {
+	if strings.HasPrefix(source, "http") {
+		resp, err := http.Get(source) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+	dat, err := os.ReadFile(source)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENAMETOOLONG) {
+		return []byte(source), nil
+	}
+	return dat, err
+}
+
 var ValidateSchemaCMD = cli.Command{
 	Name: "validate",
 	Action: func(c *cli.Context) error {
-		config := c.Args().First()
-		return schema.Validate(config)
+		source := c.Args().First()
+		// The provider problems are reported even if the schema doesn't validate
+		schemaErr := schema.Validate(source)
+
+		dat, err := readConfigSource(source)
+		if err != nil {
+			return err
+		}
+		config := &providerConfig.Config{}
+		if err := yaml.Unmarshal(dat, config); err != nil {
+			return fmt.Errorf("failed reading the config: %w", err)
+		}
+		problems := providerConfig.Validate(config)
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if schemaErr != nil {
+			return schemaErr
+		}
+		if problems.HasErrors() {
+			return fmt.Errorf("the config has errors")
+		}
+		return nil
 	},
 	Usage: "Validates a cloud config file",
 	Description: `
 The validate command expects a configuration file as its only argument. Local files and URLs are accepted.
+
+Besides the schema of the cloud config, the provider settings are checked: conflicting Kubernetes blocks, p2p roles, HA, kube-vip, placement rules and worker pools. Each problem is reported with its YAML path, as an error or a warning. Warnings alone don't fail the validation.
 		`,
 }
 
+var SchemaCMD = cli.Command{
+	Name:  "schema",
+	Usage: "Prints the JSON schema of the provider config",
+	Description: `
+Prints the JSON schema of the blocks of the cloud config read by the provider: p2p, kubevip and the Kubernetes distributions. Point an editor or a linter to it to complete and validate config files without a node.
+
+Example:
+
+	$ kairos schema --id https://example.com/kairos-provider.json > kairos-provider.json
+		`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "id",
+			Usage: "URL the schema is published at, set as its $id",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		schema, err := providerConfig.JSONSchema(c.String("id"))
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(schema))
+		return nil
+	},
+}
+
 var VersionCMD = cli.Command{
 	Name: "version",
 	Action: func(_ *cli.Context) error {
@@ -134,6 +207,10 @@ The kairos CLI can be used to manage a kairos box and perform all day-two tasks,
 - connect to a node in recovery mode
 - to establish a VPN connection
 - set, list roles
+- approve, reject and remove nodes of the cluster
+- show the cluster status, and upgrade its Kubernetes distribution
+- plan what a node would do with a config
+- validate a config, and print the JSON schema of the provider blocks
 - interact with the network API
 
 and much more.
@@ -178,9 +255,13 @@ For all the example cases, see: https://kairos.io/docs/
 			BridgeCMD(toolName),
 			&GetKubeConfigCMD,
 			&RoleCMD,
+			&NodeCMD,
+			&ClusterCMD,
+			&PlanCMD,
 			&CreateConfigCMD,
 			&GenerateTokenCMD,
 			&ValidateSchemaCMD,
+			&SchemaCMD,
 			&VersionCMD,
 		},
 	}
@@ -0,0 +1,92 @@
+// Package identity provides the keys nodes identify themselves with on the
+// p2p network, backed by a TPM or by a key sealed to the machine.
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Backends
+const (
+	// Auto uses the TPM if the node has one, and a software key otherwise
+	Auto = "auto"
+	TPM  = "tpm"
+	// Simulator is a TPM simulator like swtpm, meant for local testing
+	Simulator = "simulator"
+	Software  = "software"
+)
+
+// DefaultSimulatorTCTI is where swtpm listens with its default settings.
+const DefaultSimulatorTCTI = "swtpm:host=127.0.0.1,port=2321"
+
+// Identity is the key a node signs its ledger writes with.
+type Identity interface {
+	// ID is a stable identifier of the node, the fingerprint of its
+	// public key so that it can be verified
+	ID() string
+	// PublicKey returns the PKIX encoded public key signatures are verified with
+	PublicKey() []byte
+	// Sign signs the sha256 digest of msg, returning an ASN.1 ECDSA signature
+	Sign(msg []byte) ([]byte, error)
+}
+
+// Load returns the identity of the node for the given backend. tcti
+// selects the TPM to use, it defaults to the TPM of the node, or to a
+// local swtpm with the simulator backend.
+func Load(backend, tcti string) (Identity, error) {
+	switch backend {
+	case Auto:
+		if hasTPM() {
+			return NewTPM(tcti)
+		}
+		return NewSoftware(DefaultKeyFile, DefaultMachineIDFile)
+	case TPM:
+		return NewTPM(tcti)
+	case Simulator:
+		if tcti == "" {
+			tcti = DefaultSimulatorTCTI
+		}
+		return NewTPM(tcti)
+	case Software:
+		return NewSoftware(DefaultKeyFile, DefaultMachineIDFile)
+	}
+	return nil, fmt.Errorf("unknown identity backend %q", backend)
+}
+
+func hasTPM() bool {
+	for _, dev := range []string{"/dev/tpmrm0", "/dev/tpm0"} {
+		if _, err := os.Stat(dev); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Fingerprint returns the hex encoded sha256 of a public key.
+func Fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks a signature made by Sign against a PKIX encoded public key.
+func Verify(publicKey, msg, sig []byte) error {
+	pub, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("not an ECDSA public key")
+	}
+	digest := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return errors.New("signature doesn't verify")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeTPM emulates the tpm2-tools commands used by the tpm backend.
+type fakeTPM struct {
+	persistent map[string]*ecdsa.PrivateKey
+	contexts   map[string]*ecdsa.PrivateKey
+	calls      []string
+}
+
+func (f *fakeTPM) run(args ...string) error {
+	f.calls = append(f.calls, args[0])
+	flags := map[string]string{}
+	for i := 1; i+1 < len(args); i += 2 {
+		// -d takes no value
+		if args[i] == "-d" {
+			i--
+			continue
+		}
+		flags[args[i]] = args[i+1]
+	}
+	// The input file is the last argument of hash and sign
+	input := args[len(args)-1]
+
+	switch args[0] {
+	case "tpm2_readpublic":
+		key, ok := f.persistent[flags["-c"]]
+		if !ok {
+			return errors.New("handle not found")
+		}
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		return os.WriteFile(flags["-o"], der, 0600)
+	case "tpm2_createek":
+		f.persistent[flags["-c"]], _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "tpm2_createak":
+		f.contexts[flags["-c"]], _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "tpm2_evictcontrol":
+		f.persistent[input] = f.contexts[flags["-c"]]
+	case "tpm2_hash":
+		msg, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(msg)
+		if err := os.WriteFile(flags["-t"], []byte("ticket"), 0600); err != nil {
+			return err
+		}
+		return os.WriteFile(flags["-o"], digest[:], 0600)
+	case "tpm2_sign":
+		if _, err := os.Stat(flags["-t"]); err != nil {
+			return errors.New("restricted keys sign only with a ticket")
+		}
+		digest, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		sig, err := ecdsa.SignASN1(rand.Reader, f.persistent[flags["-c"]], digest)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(flags["-o"], sig, 0600)
+	}
+	return nil
+}
+
+var _ = Describe("Identity", func() {
+	Describe("software", func() {
+		var keyFile, machineID string
+
+		BeforeEach(func() {
+			dir := GinkgoT().TempDir()
+			keyFile = filepath.Join(dir, "state", "identity.key")
+			machineID = filepath.Join(dir, "machine-id")
+			Expect(os.WriteFile(machineID, []byte("0123456789abcdef\n"), 0600)).To(Succeed())
+		})
+
+		It("signs with a key kept across restarts", func() {
+			id, err := NewSoftware(keyFile, machineID)
+			Expect(err).ToNot(HaveOccurred())
+
+			sig, err := id.Sign([]byte("role/node/master"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(Verify(id.PublicKey(), []byte("role/node/master"), sig)).To(Succeed())
+			Expect(Verify(id.PublicKey(), []byte("role/node/worker"), sig)).ToNot(Succeed())
+
+			again, err := NewSoftware(keyFile, machineID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again.ID()).To(Equal(id.ID()))
+		})
+
+		It("can't be opened on another machine", func() {
+			_, err := NewSoftware(keyFile, machineID)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.WriteFile(machineID, []byte("fedcba9876543210\n"), 0600)).To(Succeed())
+			_, err = NewSoftware(keyFile, machineID)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("tpm", func() {
+		var f *fakeTPM
+
+		BeforeEach(func() {
+			f = &fakeTPM{persistent: map[string]*ecdsa.PrivateKey{}, contexts: map[string]*ecdsa.PrivateKey{}}
+		})
+
+		It("creates and persists the keys on first use", func() {
+			id, err := newTPM(f.run)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.persistent).To(HaveKey(EKHandle))
+			Expect(f.persistent).To(HaveKey(AKHandle))
+
+			again, err := newTPM(f.run)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again.ID()).To(Equal(id.ID()))
+			Expect(again.PublicKey()).To(Equal(id.PublicKey()))
+			// The second time, the keys are only read
+			Expect(f.calls).To(HaveLen(9))
+		})
+
+		It("derives the identity from the attestation key", func() {
+			id, err := newTPM(f.run)
+			Expect(err).ToNot(HaveOccurred())
+
+			ak, _ := x509.MarshalPKIXPublicKey(&f.persistent[AKHandle].PublicKey)
+			Expect(id.PublicKey()).To(Equal(ak))
+			Expect(id.ID()).To(Equal(Fingerprint(ak)))
+		})
+
+		It("signs with the attestation key", func() {
+			id, err := newTPM(f.run)
+			Expect(err).ToNot(HaveOccurred())
+
+			sig, err := id.Sign([]byte("ip/node/10.1.0.1"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(Verify(id.PublicKey(), []byte("ip/node/10.1.0.1"), sig)).To(Succeed())
+		})
+	})
+
+	It("refuses unknown backends", func() {
+		_, err := Load("hsm", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
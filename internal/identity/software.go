@@ -0,0 +1,113 @@
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// DefaultKeyFile is where the software identity key is kept, sealed
+	DefaultKeyFile = "/usr/local/.kairos/identity.key"
+	// DefaultMachineIDFile is what the software identity key is sealed to
+	DefaultMachineIDFile = "/etc/machine-id"
+)
+
+type software struct {
+	key *ecdsa.PrivateKey
+	pub []byte
+}
+
+// NewSoftware returns an identity backed by a key generated on first use,
+// and stored in keyFile sealed to the machine id. The key can't be used on
+// another machine, but unlike a TPM backed one it can be read by root.
+func NewSoftware(keyFile, machineIDFile string) (Identity, error) {
+	aead, err := machineSealer(machineIDFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var key *ecdsa.PrivateKey
+	sealed, err := os.ReadFile(keyFile)
+	switch {
+	case err == nil:
+		if len(sealed) < aead.NonceSize() {
+			return nil, fmt.Errorf("%s is truncated", keyFile)
+		}
+		der, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s, was it sealed on another machine? %w", keyFile, err)
+		}
+		if key, err = x509.ParseECPrivateKey(der); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyFile, aead.Seal(nonce, nonce, der, nil), 0600); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &software{key: key, pub: pub}, nil
+}
+
+func machineSealer(machineIDFile string) (cipher.AEAD, error) {
+	id, err := os.ReadFile(machineIDFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading the machine id: %w", err)
+	}
+	if strings.TrimSpace(string(id)) == "" {
+		return nil, errors.New("empty machine id")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(strings.TrimSpace(string(id))), nil, "provider-kairos identity key", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *software) ID() string {
+	return Fingerprint(s.pub)
+}
+
+func (s *software) PublicKey() []byte {
+	return s.pub
+}
+
+func (s *software) Sign(msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	return ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+}
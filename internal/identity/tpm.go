@@ -0,0 +1,126 @@
+package identity
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	// EKHandle is the persistent handle of the ECC endorsement key, as
+	// reserved by the TCG provisioning guidance
+	EKHandle = "0x81010002"
+	// AKHandle is the persistent handle the attestation key signing the
+	// ledger writes is kept at
+	AKHandle = "0x81000100"
+)
+
+// tpm drives the TPM through tpm2-tools. Writes are signed with an
+// attestation key created under the endorsement key, which can't be read
+// out of the TPM. The attestation key is not certified against the
+// endorsement key, there is no credential activation, so the identity is
+// derived from the attestation key: the endorsement key would be a claim
+// nobody can verify.
+type tpm struct {
+	run  func(args ...string) error
+	ak   []byte
+	temp string
+}
+
+// NewTPM returns an identity backed by the TPM selected by tcti, see
+// TPM2TOOLS_TCTI. The keys are created and persisted on first use.
+func NewTPM(tcti string) (Identity, error) {
+	return newTPM(func(args ...string) error {
+		cmd := exec.Command(args[0], args[1:]...) //nolint:gosec
+		cmd.Env = os.Environ()
+		if tcti != "" {
+			cmd.Env = append(cmd.Env, "TPM2TOOLS_TCTI="+tcti)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %w: %s", args[0], err, out)
+		}
+		return nil
+	})
+}
+
+func newTPM(run func(args ...string) error) (*tpm, error) {
+	temp, err := os.MkdirTemp("", "kairos-tpm")
+	if err != nil {
+		return nil, err
+	}
+	t := &tpm{run: run, temp: temp}
+
+	_, err = t.ensure(EKHandle, func() error {
+		return t.run("tpm2_createek", "-c", EKHandle, "-G", "ecc", "-u", t.file("ek.pub"))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the endorsement key: %w", err)
+	}
+
+	t.ak, err = t.ensure(AKHandle, func() error {
+		if err := t.run("tpm2_createak", "-C", EKHandle, "-c", t.file("ak.ctx"), "-G", "ecc", "-g", "sha256", "-s", "ecdsa"); err != nil {
+			return err
+		}
+		return t.run("tpm2_evictcontrol", "-C", "o", "-c", t.file("ak.ctx"), AKHandle)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the attestation key: %w", err)
+	}
+
+	return t, nil
+}
+
+func (t *tpm) file(name string) string {
+	return filepath.Join(t.temp, name)
+}
+
+// ensure returns the public key persisted at handle, creating it first if missing.
+func (t *tpm) ensure(handle string, create func() error) ([]byte, error) {
+	pub, err := t.readPublic(handle)
+	if err == nil {
+		return pub, nil
+	}
+	if err := create(); err != nil {
+		return nil, err
+	}
+	return t.readPublic(handle)
+}
+
+func (t *tpm) readPublic(handle string) ([]byte, error) {
+	out := t.file(handle + ".der")
+	if err := t.run("tpm2_readpublic", "-c", handle, "-f", "der", "-o", out); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+func (t *tpm) ID() string {
+	return Fingerprint(t.ak)
+}
+
+func (t *tpm) PublicKey() []byte {
+	return t.ak
+}
+
+// Sign hashes msg in the TPM first: the attestation key is restricted, and
+// only signs digests the TPM vouches it computed.
+func (t *tpm) Sign(msg []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp(t.temp, "sign")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, digest, ticket, sig := filepath.Join(dir, "msg"), filepath.Join(dir, "digest"), filepath.Join(dir, "ticket"), filepath.Join(dir, "sig")
+	if err := os.WriteFile(in, msg, 0600); err != nil {
+		return nil, err
+	}
+	if err := t.run("tpm2_hash", "-C", "e", "-g", "sha256", "-o", digest, "-t", ticket, in); err != nil {
+		return nil, err
+	}
+	if err := t.run("tpm2_sign", "-c", AKHandle, "-g", "sha256", "-s", "ecdsa", "-d", "-t", ticket, "-f", "plain", "-o", sig, digest); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(sig)
+}
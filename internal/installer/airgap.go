@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// AirgapManifest lists the image tarballs baked in the image at build time.
+// It lives under /usr, so it always describes what the image shipped with.
+var AirgapManifest = "/usr/share/provider-kairos/airgap.json"
+
+type airgapEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// SaveImage pulls an image for the given architecture and saves it as a
+// tarball which container runtimes can import.
+func SaveImage(ref, arch, dest string) error {
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		return err
+	}
+	img, err := crane.Pull(tag.String(), crane.WithPlatform(&v1.Platform{OS: "linux", Architecture: arch}))
+	if err != nil {
+		return fmt.Errorf("pulling %s: %w", ref, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	if err := tarball.WriteToFile(tmp, tag, img); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("saving %s: %w", ref, err)
+	}
+	return os.Rename(tmp, dest)
+}
+
+// RecordAirgap writes the manifest of the given image tarballs.
+func RecordAirgap(paths ...string) error {
+	entries := []airgapEntry{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, airgapEntry{Path: p, Size: info.Size()})
+	}
+
+	dat, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(AirgapManifest), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(AirgapManifest, dat, 0644)
+}
+
+// VerifyAirgap checks that the image tarballs baked in the image are still
+// in place. Images built without airgap images always pass.
+func VerifyAirgap() error {
+	dat, err := os.ReadFile(AirgapManifest)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := []airgapEntry{}
+	if err := json.Unmarshal(dat, &entries); err != nil {
+		return fmt.Errorf("reading %s: %w", AirgapManifest, err)
+	}
+	for _, e := range entries {
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			return fmt.Errorf("airgap image tarball %s is missing", e.Path)
+		}
+		if info.Size() != e.Size {
+			return fmt.Errorf("airgap image tarball %s is truncated or was modified", e.Path)
+		}
+	}
+	return nil
+}
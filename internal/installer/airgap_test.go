@@ -0,0 +1,77 @@
+package installer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Airgap images", func() {
+	var imagesDir string
+
+	BeforeEach(func() {
+		imagesDir = GinkgoT().TempDir()
+		AirgapManifest = filepath.Join(GinkgoT().TempDir(), "airgap.json")
+		DeferCleanup(func() { AirgapManifest = "/usr/share/provider-kairos/airgap.json" })
+	})
+
+	It("places the distribution tarball in the images directory", func() {
+		dir := mirror(map[string]string{
+			"v1.30.1+k0s.0/k0s-airgap-bundle-v1.30.1+k0s.0-amd64": "bundle",
+			"v1.30.1+k0s.0/sha256sums.txt":                        fmt.Sprintf("%s  k0s-airgap-bundle-v1.30.1+k0s.0-amd64\n", sha("bundle")),
+		})
+
+		path, err := InstallAirgapImages("k0s", "v1.30.1+k0s.0", Options{Source: "file://" + dir, Arch: "amd64", ImagesDir: imagesDir})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(filepath.Join(imagesDir, "k0s-airgap-bundle-v1.30.1+k0s.0-amd64")))
+		Expect(os.ReadFile(path)).To(Equal([]byte("bundle")))
+	})
+
+	It("saves an image as an importable tarball", func() {
+		server := httptest.NewServer(registry.New())
+		DeferCleanup(server.Close)
+		img, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+		ref := strings.TrimPrefix(server.URL, "http://") + "/kube-vip/kube-vip:v1.0.0"
+		Expect(crane.Push(img, ref)).To(Succeed())
+
+		dest := filepath.Join(imagesDir, "kube-vip.tar")
+		Expect(SaveImage(ref, "amd64", dest)).To(Succeed())
+
+		saved, err := tarball.ImageFromPath(dest, nil)
+		Expect(err).ToNot(HaveOccurred())
+		savedDigest, err := saved.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		digest, err := img.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(savedDigest).To(Equal(digest))
+	})
+
+	Context("verification", func() {
+		It("passes when the image has no airgap images", func() {
+			Expect(VerifyAirgap()).To(Succeed())
+		})
+
+		It("checks the recorded tarballs are still in place", func() {
+			images := filepath.Join(imagesDir, "images.tar")
+			Expect(os.WriteFile(images, []byte("images"), 0644)).To(Succeed())
+			Expect(RecordAirgap(images)).To(Succeed())
+			Expect(VerifyAirgap()).To(Succeed())
+
+			Expect(os.WriteFile(images, []byte("trunc"), 0644)).To(Succeed())
+			Expect(VerifyAirgap()).To(MatchError(ContainSubstring("truncated")))
+
+			Expect(os.Remove(images)).To(Succeed())
+			Expect(VerifyAirgap()).To(MatchError(fmt.Sprintf("airgap image tarball %s is missing", images)))
+		})
+	})
+})
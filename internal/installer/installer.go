@@ -0,0 +1,332 @@
+// Package installer installs the release binaries of the supported
+// Kubernetes distributions, verifying them against the published checksums.
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Options configures where artifacts are fetched from and installed to.
+type Options struct {
+	// Source is a mirror of the release artifacts, laid out as
+	// <source>/<version>/<artifact>. It can be an http(s) or a file:// URL.
+	// When empty, artifacts are fetched from the upstream releases.
+	Source string
+	// Arch defaults to the architecture of the running binary
+	Arch string
+	// BinDir defaults to /usr/bin, so the binaries are part of the image
+	// and not of the persistent state
+	BinDir string
+	// ImagesDir defaults to the directory the distribution imports image tarballs from
+	ImagesDir string
+	// Checksums are sha256sum lists pinned by the caller, which artifacts
+	// are verified against instead of the lists published next to them
+	Checksums []byte
+	Client    *http.Client
+}
+
+// Arches are the architectures the release checksums are pinned for.
+var Arches = []string{"amd64", "arm64", "arm"}
+
+type distro struct {
+	releases string
+	// latest returns the latest stable version, only used with upstream releases
+	latest func(o Options) (string, error)
+	// binary, airgap and checksums return the names of the release artifacts
+	binary    func(version, arch string) string
+	airgap    func(version, arch string) string
+	checksums func(version, arch string) string
+	// links are created next to the binary, pointing to it
+	links []string
+	// imagesDir is where the distribution imports image tarballs from on start
+	imagesDir string
+}
+
+var distros = map[string]distro{
+	"k3s": {
+		releases: "https://github.com/k3s-io/k3s/releases/download",
+		latest: func(o Options) (string, error) {
+			// The channel redirects to the release page of the version
+			resp, err := o.client().Get("https://update.k3s.io/v1-release/channels/stable")
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			return path.Base(resp.Request.URL.Path), nil
+		},
+		binary: func(_, arch string) string {
+			switch arch {
+			case "amd64":
+				return "k3s"
+			case "arm":
+				return "k3s-armhf"
+			}
+			return "k3s-" + arch
+		},
+		airgap: func(_, arch string) string {
+			return fmt.Sprintf("k3s-airgap-images-%s.tar.zst", arch)
+		},
+		checksums: func(_, arch string) string {
+			return fmt.Sprintf("sha256sum-%s.txt", arch)
+		},
+		links:     []string{"kubectl", "crictl", "ctr"},
+		imagesDir: "/var/lib/rancher/k3s/agent/images",
+	},
+	"k0s": {
+		releases: "https://github.com/k0sproject/k0s/releases/download",
+		latest: func(o Options) (string, error) {
+			dat, err := fetchAll(o, "https://docs.k0sproject.io/stable.txt")
+			return strings.TrimSpace(string(dat)), err
+		},
+		binary: func(version, arch string) string {
+			return fmt.Sprintf("k0s-%s-%s", version, arch)
+		},
+		airgap: func(version, arch string) string {
+			return fmt.Sprintf("k0s-airgap-bundle-%s-%s", version, arch)
+		},
+		checksums: func(_, _ string) string {
+			return "sha256sums.txt"
+		},
+		imagesDir: "/var/lib/k0s/images",
+	},
+}
+
+func (o Options) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// Install installs the given version of a distribution and returns the
+// installed version. An empty version installs the latest stable one.
+func Install(name, version string, o Options) (string, error) {
+	d, o, err := resolve(name, o)
+	if err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		// A mirror has no notion of latest, builds from it must be reproducible
+		if o.Source != "" {
+			return "", errors.New("a version is required when installing from a mirror")
+		}
+		latest, err := d.latest(o)
+		if err != nil {
+			return "", fmt.Errorf("resolving the latest %s version: %w", name, err)
+		}
+		version = latest
+	}
+
+	if err := fetchArtifact(o, d, version, d.binary(version, o.Arch), filepath.Join(o.BinDir, name)); err != nil {
+		return "", err
+	}
+
+	for _, link := range d.links {
+		target := filepath.Join(o.BinDir, link)
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+		if err := os.Symlink(name, target); err != nil {
+			return "", err
+		}
+	}
+
+	return version, nil
+}
+
+// InstallAirgapImages places the airgap image tarball of the given version
+// of a distribution where it is imported from on start, and returns its path.
+func InstallAirgapImages(name, version string, o Options) (string, error) {
+	d, o, err := resolve(name, o)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		return "", errors.New("a version is required to install airgap images")
+	}
+
+	artifact := d.airgap(version, o.Arch)
+	dest := filepath.Join(o.ImagesDir, artifact)
+	return dest, fetchArtifact(o, d, version, artifact, dest)
+}
+
+// DistroOf returns the distribution a version is released by, from its
+// build metadata, e.g. k3s for v1.30.2+k3s1.
+func DistroOf(version string) (string, error) {
+	_, build, _ := strings.Cut(version, "+")
+	for name := range distros {
+		if strings.HasPrefix(build, name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("can't tell the distribution of %q", version)
+}
+
+// ReleaseChecksums returns the checksum lists of a version of a
+// distribution for every architecture, fetched from the upstream
+// releases, to pin them when installing from a mirror.
+func ReleaseChecksums(name, version string, o Options) ([]byte, error) {
+	d, o, err := resolve(name, o)
+	if err != nil {
+		return nil, err
+	}
+	sums := []byte{}
+	fetched := map[string]bool{}
+	for _, arch := range Arches {
+		list := d.checksums(version, arch)
+		if fetched[list] {
+			continue
+		}
+		fetched[list] = true
+		dat, err := fetchAll(o, d.releases+"/"+version+"/"+list)
+		if err != nil {
+			return nil, fmt.Errorf("fetching the checksums of %s: %w", version, err)
+		}
+		sums = append(sums, dat...)
+		if len(dat) > 0 && dat[len(dat)-1] != '\n' {
+			sums = append(sums, '\n')
+		}
+	}
+	return sums, nil
+}
+
+// ImagesDir returns the directory a distribution imports image tarballs from.
+func ImagesDir(name string) string {
+	return distros[name].imagesDir
+}
+
+func resolve(name string, o Options) (distro, Options, error) {
+	d, ok := distros[name]
+	if !ok {
+		return d, o, fmt.Errorf("unsupported distribution %q", name)
+	}
+	if o.Arch == "" {
+		o.Arch = runtime.GOARCH
+	}
+	if o.BinDir == "" {
+		o.BinDir = "/usr/bin"
+	}
+	if o.ImagesDir == "" {
+		o.ImagesDir = d.imagesDir
+	}
+	return d, o, nil
+}
+
+// fetchArtifact downloads a release artifact to dest, verifying it against the release checksums.
+func fetchArtifact(o Options, d distro, version, artifact, dest string) error {
+	source := d.releases
+	if o.Source != "" {
+		source = strings.TrimSuffix(o.Source, "/")
+	}
+	base := source + "/" + version
+
+	sums := o.Checksums
+	if len(sums) == 0 {
+		var err error
+		if sums, err = fetchAll(o, base+"/"+d.checksums(version, o.Arch)); err != nil {
+			return fmt.Errorf("fetching the checksums of %s: %w", version, err)
+		}
+	}
+	sum, err := checksum(sums, artifact)
+	if err != nil {
+		return err
+	}
+
+	return download(o, base+"/"+artifact, dest, sum)
+}
+
+// Fetch opens an http(s) or file:// URL.
+func Fetch(o Options, location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return os.Open(u.Path)
+	case "http", "https":
+		resp, err := o.client().Get(location)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching %s: %s", location, resp.Status)
+		}
+		return resp.Body, nil
+	}
+
+	return nil, fmt.Errorf("unsupported source %s", location)
+}
+
+func fetchAll(o Options, location string) ([]byte, error) {
+	r, err := Fetch(o, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// checksum returns the sha256 of a file from a sha256sum formatted list.
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Binary mode entries are prefixed with an asterisk
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+// download fetches a file to dest, which is only replaced if it matches the checksum.
+func download(o Options, location, dest, sum string) error {
+	r, err := Fetch(o, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		return fmt.Errorf("downloading %s: %w", location, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", location, sum, got)
+	}
+
+	if err := tmp.Chmod(0755); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
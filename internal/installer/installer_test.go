@@ -0,0 +1,150 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func sha(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// mirror lays out release artifacts the way Install expects them
+func mirror(files map[string]string) string {
+	dir := GinkgoT().TempDir()
+	for name, content := range files {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+	return dir
+}
+
+var _ = Describe("Install", func() {
+	var binDir string
+
+	BeforeEach(func() {
+		binDir = GinkgoT().TempDir()
+	})
+
+	It("installs k3s from a file mirror", func() {
+		dir := mirror(map[string]string{
+			"v1.30.2+k3s1/k3s-arm64":           "k3s binary",
+			"v1.30.2+k3s1/sha256sum-arm64.txt": fmt.Sprintf("%s  k3s-arm64\n%s  k3s-airgap-images-arm64.tar\n", sha("k3s binary"), sha("images")),
+		})
+
+		version, err := Install("k3s", "v1.30.2+k3s1", Options{Source: "file://" + dir, Arch: "arm64", BinDir: binDir})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal("v1.30.2+k3s1"))
+
+		dat, err := os.ReadFile(filepath.Join(binDir, "k3s"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(Equal("k3s binary"))
+		info, err := os.Stat(filepath.Join(binDir, "k3s"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+		link, err := os.Readlink(filepath.Join(binDir, "kubectl"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(link).To(Equal("k3s"))
+	})
+
+	It("installs k0s from an http mirror", func() {
+		dir := mirror(map[string]string{
+			"v1.30.1+k0s.0/k0s-v1.30.1+k0s.0-amd64": "k0s binary",
+			"v1.30.1+k0s.0/sha256sums.txt":          fmt.Sprintf("%s *k0s-v1.30.1+k0s.0-amd64\n", sha("k0s binary")),
+		})
+		server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+		DeferCleanup(server.Close)
+
+		_, err := Install("k0s", "v1.30.1+k0s.0", Options{Source: server.URL + "/", Arch: "amd64", BinDir: binDir})
+		Expect(err).ToNot(HaveOccurred())
+
+		dat, err := os.ReadFile(filepath.Join(binDir, "k0s"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(Equal("k0s binary"))
+	})
+
+	It("refuses binaries which don't match their checksum", func() {
+		dir := mirror(map[string]string{
+			"v1.30.2+k3s1/k3s":                 "tampered",
+			"v1.30.2+k3s1/sha256sum-amd64.txt": fmt.Sprintf("%s  k3s\n", sha("k3s binary")),
+		})
+		Expect(os.WriteFile(filepath.Join(binDir, "k3s"), []byte("previous"), 0755)).To(Succeed())
+
+		_, err := Install("k3s", "v1.30.2+k3s1", Options{Source: "file://" + dir, Arch: "amd64", BinDir: binDir})
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+
+		dat, err := os.ReadFile(filepath.Join(binDir, "k3s"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(dat)).To(Equal("previous"))
+		entries, err := os.ReadDir(binDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("refuses binaries without a published checksum", func() {
+		dir := mirror(map[string]string{
+			"v1.30.2+k3s1/k3s":                 "k3s binary",
+			"v1.30.2+k3s1/sha256sum-amd64.txt": fmt.Sprintf("%s  k3s-arm64\n", sha("k3s binary")),
+		})
+
+		_, err := Install("k3s", "v1.30.2+k3s1", Options{Source: "file://" + dir, Arch: "amd64", BinDir: binDir})
+		Expect(err).To(MatchError("no checksum published for k3s"))
+	})
+
+	It("verifies the artifacts of a mirror against the pinned checksums", func() {
+		dir := mirror(map[string]string{
+			"v1.30.2+k3s1/k3s":                 "tampered",
+			"v1.30.2+k3s1/sha256sum-amd64.txt": fmt.Sprintf("%s  k3s\n", sha("tampered")),
+		})
+		pinned := []byte(fmt.Sprintf("%s  k3s\n", sha("k3s binary")))
+
+		_, err := Install("k3s", "v1.30.2+k3s1", Options{Source: "file://" + dir, Arch: "amd64", BinDir: binDir, Checksums: pinned})
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+	})
+
+	It("fetches the checksums of every architecture from the upstream release", func() {
+		dir := mirror(map[string]string{
+			"v1.30.2+k3s1/sha256sum-amd64.txt": fmt.Sprintf("%s  k3s\n", sha("amd64")),
+			"v1.30.2+k3s1/sha256sum-arm64.txt": fmt.Sprintf("%s  k3s-arm64\n", sha("arm64")),
+			"v1.30.2+k3s1/sha256sum-arm.txt":   fmt.Sprintf("%s  k3s-armhf", sha("arm")),
+		})
+		k3s := distros["k3s"]
+		DeferCleanup(func() { distros["k3s"] = k3s })
+		upstream := k3s
+		upstream.releases = "file://" + dir
+		distros["k3s"] = upstream
+
+		sums, err := ReleaseChecksums("k3s", "v1.30.2+k3s1", Options{Source: "https://mirror"})
+		Expect(err).ToNot(HaveOccurred())
+		for arch, artifact := range map[string]string{"amd64": "k3s", "arm64": "k3s-arm64", "arm": "k3s-armhf"} {
+			Expect(checksum(sums, artifact)).To(Equal(sha(arch)))
+		}
+	})
+
+	It("tells the distribution of a version", func() {
+		Expect(DistroOf("v1.30.2+k3s1")).To(Equal("k3s"))
+		Expect(DistroOf("v1.30.1+k0s.0")).To(Equal("k0s"))
+		_, err := DistroOf("v1.30.2")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires a version with a mirror", func() {
+		_, err := Install("k3s", "", Options{Source: "file:///srv", BinDir: binDir})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects unknown distributions", func() {
+		_, err := Install("microk8s", "v1", Options{BinDir: binDir})
+		Expect(err).To(HaveOccurred())
+	})
+})
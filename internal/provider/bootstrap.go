@@ -13,6 +13,7 @@ import (
 	"github.com/kairos-io/kairos-sdk/machine/systemd"
 	loggerpkg "github.com/kairos-io/kairos-sdk/types/logger"
 	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
 	"github.com/kairos-io/provider-kairos/v2/internal/role"
 	p2p "github.com/kairos-io/provider-kairos/v2/internal/role/p2p"
@@ -48,7 +49,6 @@ func Bootstrap(e *pluggable.Event) pluggable.EventResponse {
 
 	p2pBlockDefined := prvConfig.P2P != nil
 	tokenNotDefined := (p2pBlockDefined && prvConfig.P2P.NetworkToken == "") || !p2pBlockDefined
-	skipAuto := p2pBlockDefined && !prvConfig.P2P.Auto.IsEnabled()
 
 	if !prvConfig.IsP2PConfigured() && !prvConfig.IsKubernetesConfigured() {
 		return pluggable.EventResponse{State: "no P2P or kubernetes configured"}
@@ -89,7 +89,7 @@ func Bootstrap(e *pluggable.Event) pluggable.EventResponse {
 	// Do onetimebootstrap if a Kubernetes distribution is enabled.
 	// Those blocks are not required to be enabled in case of a kairos
 	// full automated setup. Otherwise, they must be explicitly enabled.
-	if (tokenNotDefined && prvConfig.IsKubernetesConfigured()) || skipAuto {
+	if p2p.IsStandalone(prvConfig) {
 		err := oneTimeBootstrap(logger, prvConfig, func() error {
 			return SetupVPN(services.EdgeVPNDefaultInstance, apiAddress, "/", true, prvConfig)
 		})
@@ -116,14 +116,17 @@ func Bootstrap(e *pluggable.Event) pluggable.EventResponse {
 		}
 	}
 
-	networkID := "kairos"
-
-	if p2pBlockDefined && prvConfig.P2P.NetworkID != "" {
-		networkID = prvConfig.P2P.NetworkID
+	if prvConfig.P2P.Metrics.IsEnabled() {
+		logger.Info("Serving metrics on ", prvConfig.P2P.Metrics.Listen)
+		go func() {
+			if err := role.ServeMetrics(prvConfig.P2P.Metrics.Listen); err != nil {
+				logger.Errorf("Failed serving metrics: %s", err.Error())
+			}
+		}()
 	}
 
 	cc := service.NewClient(
-		networkID,
+		role.NetworkID(prvConfig),
 		edgeVPNClient.NewClient(edgeVPNClient.WithHost(apiAddress)))
 
 	nodeOpts := []service.Option{
@@ -215,6 +218,14 @@ func oneTimeBootstrap(l loggerpkg.KairosLogger, c *providerConfig.Config, vpnSet
 		return err
 	}
 
+	// Some distributions take the user supplied config through a file rather than args
+	if n, ok := node.(interface{ WriteStandaloneConfig() error }); ok {
+		if err := n.WriteStandaloneConfig(); err != nil {
+			l.Errorf("Failed to write %s config file: %s", svcName, err.Error())
+			return err
+		}
+	}
+
 	// Initialize the service based on the system's init system
 	if utils.IsOpenRCBased() {
 		svc, err = openrc.NewService(openrc.WithName(svcName))
@@ -230,6 +241,11 @@ func oneTimeBootstrap(l loggerpkg.KairosLogger, c *providerConfig.Config, vpnSet
 		return fmt.Errorf("could not detect OS")
 	}
 
+	if err := installer.VerifyAirgap(); err != nil {
+		l.Errorf("Failed verifying the airgap images: %s", err.Error())
+		return err
+	}
+
 	// Override the service command and start it
 	if err := svc.OverrideCmd(fmt.Sprintf("%s %s %s", binPath, svcRole, args)); err != nil {
 		l.Errorf("Failed to override service command: %s", err.Error())
@@ -3,22 +3,29 @@ package provider
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/kairos-io/kairos-sdk/bus"
 	loggerpkg "github.com/kairos-io/kairos-sdk/types/logger"
 	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	p2p "github.com/kairos-io/provider-kairos/v2/internal/role/p2p"
 	"github.com/kairos-io/provider-kairos/v2/internal/services"
 	"github.com/mudler/go-pluggable"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	K3s = "k3s"
-	K0s = "k0s"
+	K3s  = "k3s"
+	K0s  = "k0s"
+	RKE2 = "rke2"
 )
 
 // BuildEvent handles the buildtime event for the provider. Called by kairos-init during the build process.
@@ -45,111 +52,100 @@ func BuildEvent(e *pluggable.Event) pluggable.EventResponse {
 	// Now move the logger to the requested log level
 	l.SetLevel(p.LogLevel)
 	l.Logger.Debug().Interface("payload", p).Msg("Payload details")
-	// Download the installer script for the provider
-	var url string
 	switch p.Provider {
-	case K3s:
-		url = "https://get.k3s.io"
-	case K0s:
-		url = "https://get.k0s.sh"
-	}
-
-	installerFile := filepath.Join(os.TempDir(), "installer.sh")
-
-	// Download the installer script
-	switch p.Provider {
-	case K3s, K0s:
-		l.Logger.Info().Msgf("Downloading installer script for %s from %s", p.Provider, url)
-		// TODO: Do it with golang instead of needing curl?
-		out, err := exec.Command("curl", "-sfL", url, "-o", installerFile).CombinedOutput()
-		if err != nil {
-			l.Logger.Error().Err(err).Msgf("Failed to download installer script: %s", string(out))
-			returnData.Error = fmt.Sprintf("Failed to download installer script: %s", string(out))
-			returnData.State = bus.EventResponseError
-			return returnData
-		}
+	case K3s, K0s, RKE2:
 	default:
 		// This is not for us, its for another provider or no provider was specified
 		l.Logger.Info().Msg("No valid provider specified or unsupported provider. Skipping buildtime logic.")
 		returnData.State = bus.EventResponseNotApplicable
 		return returnData
 	}
-	// Make the installer script executable
-	err := os.Chmod(installerFile, 0755)
-	if err != nil {
-		l.Logger.Error().Err(err).Msgf("Failed to make installer script executable: %s", installerFile)
-		returnData.Error = fmt.Sprintf("Failed to make installer script executable: %s", err)
+
+	build := providerConfig.Build{}
+	if err := yaml.Unmarshal([]byte(p.Config), &build); err != nil {
+		l.Logger.Error().Err(err).Msg("Failed to parse the build config")
+		returnData.Error = fmt.Sprintf("Failed to parse the build config: %s", err)
 		returnData.State = bus.EventResponseError
 		return returnData
 	}
+	opts := installer.Options{Source: build.Source}
 
-	// Install the binaries
 	var out []byte
 	switch p.Provider {
-	case K3s:
-		// Prepare environment variables
-		env := os.Environ()
-		env = append(env, "INSTALL_K3S_BIN_DIR=/usr/bin", "INSTALL_K3S_SKIP_ENABLE=true", "INSTALL_K3S_SKIP_SELINUX_RPM=true")
-		if p.Version != "" {
-			env = append(env, fmt.Sprintf("INSTALL_K3S_VERSION=%s", p.Version))
-		}
-
-		l.Logger.Info().Msg("Running k3s installer script")
-		cmd := exec.Command("sh", installerFile)
-		cmd.Env = env
-		out, err = cmd.CombinedOutput()
+	case K3s, K0s:
+		l.Logger.Info().Msgf("Installing %s %s", p.Provider, p.Version)
+		version, err := installer.Install(p.Provider, p.Version, opts)
 		if err != nil {
-			l.Logger.Error().Err(err).Msgf("Failed to run k3s installer script: %s", string(out))
-			returnData.Error = fmt.Sprintf("Failed to run k3s installer script: %s", string(out))
+			l.Logger.Error().Err(err).Msgf("Failed to install %s", p.Provider)
+			returnData.Error = fmt.Sprintf("Failed to install %s: %s", p.Provider, err)
 			returnData.State = bus.EventResponseError
 			return returnData
 		}
+		out = []byte(fmt.Sprintf("Installed %s %s", p.Provider, version))
 
-		// Now agent
-		agentCmd := exec.Command("sh", installerFile, "agent")
-		agentCmd.Env = env
-		out2, err := agentCmd.CombinedOutput()
+		// we are running in a dockerfile so the service manager identification
+		// of upstream installers does not work as expected, write the units ourselves
+		l.Logger.Info().Msgf("Creating %s service files", p.Provider)
+		if p.Provider == K3s {
+			err = services.K3sServices(l)
+		} else {
+			err = services.K0sServices(l)
+		}
 		if err != nil {
-			l.Logger.Error().Err(err).Msgf("Failed to run k3s agent installer script: %s", string(out))
-			returnData.Error = fmt.Sprintf("Failed to run k3s agent installer script: %s", string(out))
+			l.Logger.Error().Err(err).Msgf("Failed to create %s service files", p.Provider)
+			returnData.Error = fmt.Sprintf("Failed to create %s service files: %s", p.Provider, err)
 			returnData.State = bus.EventResponseError
 			return returnData
 		}
-		out = append(out, out2...)
-	case K0s:
-		env := os.Environ()
-		if p.Version != "" {
-			env = append(env, fmt.Sprintf("K0S_VERSION=%s", p.Version))
+
+		if build.Airgap {
+			l.Logger.Info().Msgf("Preloading the %s airgap images", p.Provider)
+			if err := installAirgap(p.Provider, version, opts); err != nil {
+				l.Logger.Error().Err(err).Msg("Failed to preload the airgap images")
+				returnData.Error = fmt.Sprintf("Failed to preload the airgap images: %s", err)
+				returnData.State = bus.EventResponseError
+				return returnData
+			}
 		}
-		l.Logger.Info().Msg("Running k0s installer script")
-		cmd := exec.Command("sh", installerFile)
-		cmd.Env = env
-		out, err = cmd.CombinedOutput()
-		if err != nil {
-			l.Logger.Error().Err(err).Msgf("Failed to run k0s installer script: %s", string(out))
-			returnData.Error = fmt.Sprintf("Failed to run k0s installer script: %s", string(out))
+	case RKE2:
+		if build.Airgap {
+			l.Logger.Error().Msg("Airgap images are not supported with rke2")
+			returnData.Error = "Airgap images are not supported with rke2"
 			returnData.State = bus.EventResponseError
 			return returnData
 		}
-		// move the binary to a decent location t avoid overwriting it with PERSISTENT
-		err = os.Rename("/usr/local/bin/k0s", "/usr/bin/k0s")
-		if err != nil {
-			l.Logger.Error().Err(err).Msg("Failed to move k0s binary to /usr/bin")
-			returnData.Error = fmt.Sprintf("Failed to move k0s binary to /usr/bin: %s", err)
+		url := "https://get.rke2.io"
+		installerFile := filepath.Join(os.TempDir(), "installer.sh")
+		l.Logger.Info().Msgf("Downloading installer script for %s from %s", p.Provider, url)
+		if err := fetchTo(opts, url, installerFile); err != nil {
+			l.Logger.Error().Err(err).Msg("Failed to download installer script")
+			returnData.Error = fmt.Sprintf("Failed to download installer script: %s", err)
 			returnData.State = bus.EventResponseError
 			return returnData
 		}
-		// Because we change the binary location, the installer script wont produce the proper services
-		// also we are running in a dockerfile so the service manager identification does not work as expected
-		l.Logger.Info().Msg("Creating k0s service file manually")
-		err = services.K0sServices(l)
+
+		// The tarball method installs both the server and agent units, under
+		// /usr so that they are part of the image and not of the persistent state
+		env := os.Environ()
+		env = append(env, "INSTALL_RKE2_METHOD=tar", "INSTALL_RKE2_TAR_PREFIX=/usr")
+		if p.Version != "" {
+			env = append(env, fmt.Sprintf("INSTALL_RKE2_VERSION=%s", p.Version))
+		}
+		// The installer script only knows about local artifacts
+		if strings.HasPrefix(build.Source, "file://") {
+			env = append(env, fmt.Sprintf("INSTALL_RKE2_ARTIFACT_PATH=%s", strings.TrimPrefix(build.Source, "file://")))
+		}
+		l.Logger.Info().Msg("Running rke2 installer script")
+		cmd := exec.Command("sh", installerFile)
+		cmd.Env = env
+		var err error
+		out, err = cmd.CombinedOutput()
 		if err != nil {
-			l.Logger.Error().Err(err).Msg("Failed to create k0s service file")
-			returnData.Error = fmt.Sprintf("Failed to create k0s service file: %s", err)
+			l.Logger.Error().Err(err).Msgf("Failed to run rke2 installer script: %s", string(out))
+			returnData.Error = fmt.Sprintf("Failed to run rke2 installer script: %s", string(out))
 			returnData.State = bus.EventResponseError
 			return returnData
 		}
-
 	}
 	returnData.Data = string(out)
 	returnData.State = bus.EventResponseSuccess
@@ -176,6 +172,10 @@ func InfoEvent(e *pluggable.Event) pluggable.EventResponse {
 		infoData.Provider = K0s
 		infoData.Version = k0sVersion(l)
 	}
+	if rke2 := p2p.RKE2Bin(); rke2 != "" {
+		infoData.Provider = RKE2
+		infoData.Version = rke2Version(l)
+	}
 
 	// This is the returned data for the info event
 	jsondata, err := json.Marshal(infoData)
@@ -238,3 +238,54 @@ func k0sVersion(logger loggerpkg.KairosLogger) string {
 
 	return strings.TrimSpace(string(out))
 }
+
+// rke2Version retrieves the version of rke2 installed on the system.
+func rke2Version(logger loggerpkg.KairosLogger) string {
+	out, err := exec.Command(p2p.RKE2Bin(), "--version").CombinedOutput()
+	if err != nil {
+		logger.Logger.Error().Msgf("Failed to get the rke2 version: %s", err)
+		return ""
+	}
+	// rke2 version v1.28.3+rke2r1 (hash)
+	// go version go1.20.10 X:boringcrypto
+	re := regexp.MustCompile(`rke2 version (v\d+\.\d+\.\d+\+rke2r\d+)`)
+	if re.MatchString(string(out)) {
+		match := re.FindStringSubmatch(string(out))
+		return match[1]
+	}
+	logger.Logger.Error().Msgf("Failed to parse the rke2 version: %s", string(out))
+	return ""
+}
+
+// installAirgap places the images of the distribution and kube-vip where they
+// are imported from on start, and records them for bootstrap to verify.
+func installAirgap(provider, version string, opts installer.Options) error {
+	images, err := installer.InstallAirgapImages(provider, version, opts)
+	if err != nil {
+		return err
+	}
+
+	kubeVIP := filepath.Join(installer.ImagesDir(provider), fmt.Sprintf("kube-vip-%s.tar", p2p.DefaultKubeVIPVersion))
+	ref := fmt.Sprintf("%s:%s", p2p.DefaultKubeVIPImage, p2p.DefaultKubeVIPVersion)
+	if err := installer.SaveImage(ref, runtime.GOARCH, kubeVIP); err != nil {
+		return err
+	}
+
+	return installer.RecordAirgap(images, kubeVIP)
+}
+
+func fetchTo(opts installer.Options, location, dest string) error {
+	r, err := installer.Fetch(opts, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
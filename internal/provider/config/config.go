@@ -1,25 +1,87 @@
 package config
 
-import "github.com/kube-vip/kube-vip/pkg/kubevip"
+import (
+	"path"
+	"slices"
+
+	"github.com/kube-vip/kube-vip/pkg/kubevip"
+)
 
 const (
-	K3sDistro = "k3s"
-	K0sDistro = "k0s"
+	K3sDistro  = "k3s"
+	K0sDistro  = "k0s"
+	RKE2Distro = "rke2"
 )
 
 type P2P struct {
-	NetworkToken string `yaml:"network_token,omitempty"`
-	NetworkID    string `yaml:"network_id,omitempty"`
-	Role         string `yaml:"role,omitempty"`
-	DNS          bool   `yaml:"dns,omitempty"`
-	LogLevel     string `yaml:"loglevel,omitempty"`
-	VPN          VPN    `yaml:"vpn,omitempty"`
+	NetworkToken string `yaml:"network_token,omitempty" description:"Shared secret the nodes coordinate with, generated by kairos generate-token"`
+	NetworkID    string `yaml:"network_id,omitempty" description:"User defined network id, to run multiple clusters on the same network"`
+	Role         string `yaml:"role,omitempty" enum:"[\"master\",\"worker\"]" description:"Static role of the node, the role is assigned automatically if not set"`
+	DNS          bool   `yaml:"dns,omitempty" description:"Enables the embedded DNS of the VPN"`
+	LogLevel     string `yaml:"loglevel,omitempty" description:"Log level of the p2p services"`
+	VPN          VPN    `yaml:"vpn,omitempty" description:"VPN the nodes of the cluster talk over"`
+
+	MinimumNodes int  `yaml:"minimum_nodes,omitempty" default:"2" minimum:"0" description:"Number of nodes required on the network before roles are scheduled"`
+	DisableDHT   bool `yaml:"disable_dht,omitempty" description:"Disables the DHT, nodes are only discovered on the local network"`
+	Auto         Auto `yaml:"auto,omitempty" description:"Automatic role assignment"`
+
+	DynamicRoles bool `yaml:"dynamic_roles,omitempty" description:"Lets the role of the node change after it is assigned"`
+
+	// Labels are advertised to the other nodes along with the node capabilities
+	Labels map[string]string `yaml:"labels,omitempty" description:"Labels advertised to the other nodes, matched by placement rules and worker pools"`
+	// ControlPlaneEligible set to false keeps the node out of the control plane
+	ControlPlaneEligible *bool `yaml:"control_plane_eligible,omitempty" default:"true" description:"Set to false to keep the node out of the control plane"`
 
-	MinimumNodes int  `yaml:"minimum_nodes,omitempty"`
-	DisableDHT   bool `yaml:"disable_dht,omitempty"`
-	Auto         Auto `yaml:"auto,omitempty"`
+	// ClusterSecret seals the cluster credentials shared over the ledger.
+	// Every node of the network must have the same one.
+	ClusterSecret string `yaml:"cluster_secret,omitempty" description:"Seals the cluster credentials shared over the ledger, the same on every node"`
+	// PreviousClusterSecrets can still open the credentials sealed before
+	// the cluster secret was rotated, until they are sealed again
+	PreviousClusterSecrets []string `yaml:"previous_cluster_secrets,omitempty" description:"Cluster secrets still accepted after a rotation"`
+
+	// JoinTokenTTL is how long the token minted for a worker to join is valid, in seconds
+	JoinTokenTTL int `yaml:"join_token_ttl,omitempty" default:"3600" minimum:"0" description:"Validity in seconds of the token minted for a worker to join"`
+
+	Admission Admission `yaml:"admission,omitempty" description:"Holds new nodes back from getting a role until they are approved, requires cluster_secret"`
+	Identity  Identity  `yaml:"identity,omitempty" description:"Signs the roles and IPs nodes claim with a key bound to the node, requires cluster_secret"`
+	Metrics   Metrics   `yaml:"metrics,omitempty" description:"Prometheus metrics of the p2p coordination"`
+}
+
+// Metrics exports the state of the p2p coordination for Prometheus.
+type Metrics struct {
+	// Listen is the address serving /metrics, e.g. ":9200"
+	Listen string `yaml:"listen,omitempty" example:"\":9200\"" description:"Address serving /metrics"`
+}
 
-	DynamicRoles bool `yaml:"dynamic_roles,omitempty"`
+func (m Metrics) IsEnabled() bool {
+	return m.Listen != ""
+}
+
+// Identity signs the roles and IPs nodes claim with a key bound to the node.
+// The identities the leader pins are sealed with the cluster secret, which
+// is required.
+type Identity struct {
+	// Backend is one of auto, tpm, software or simulator
+	Backend string `yaml:"backend,omitempty" enum:"[\"auto\",\"tpm\",\"software\",\"simulator\"]" description:"Where the key of the node is kept"`
+	// TCTI selects the TPM used by the tpm and simulator backends, as TPM2TOOLS_TCTI
+	TCTI string `yaml:"tcti,omitempty" description:"TPM used by the tpm and simulator backends, as TPM2TOOLS_TCTI"`
+}
+
+func (i Identity) IsEnabled() bool {
+	return i.Backend != ""
+}
+
+// Admission holds new nodes back from getting a role until they are
+// approved. The approvals are sealed with the cluster secret, which is
+// required.
+type Admission struct {
+	Enable *bool `yaml:"enable,omitempty" description:"Requires new nodes to be approved, defaults to true if allow is set"`
+	// Allow lists the machine UUIDs, or identities, admitted without an approval
+	Allow []string `yaml:"allow,omitempty" description:"Machine UUIDs or identities admitted without an approval"`
+}
+
+func (a Admission) IsEnabled() bool {
+	return (a.Enable != nil && *a.Enable) || (a.Enable == nil && len(a.Allow) > 0)
 }
 
 func (p P2P) IsAutoEnabled() bool {
@@ -27,9 +89,9 @@ func (p P2P) IsAutoEnabled() bool {
 }
 
 type VPN struct {
-	Create *bool             `yaml:"create,omitempty"`
-	Use    *bool             `yaml:"use,omitempty"`
-	Env    map[string]string `yaml:"env,omitempty"`
+	Create *bool             `yaml:"create,omitempty" default:"true" description:"Creates the VPN"`
+	Use    *bool             `yaml:"use,omitempty" default:"true" description:"Runs Kubernetes over the VPN"`
+	Env    map[string]string `yaml:"env,omitempty" description:"Environment of the VPN service"`
 }
 
 // If no setting is provided by the user,
@@ -44,12 +106,14 @@ func (p P2P) VPNNeedsCreation() bool {
 }
 
 type Config struct {
-	P2P       *P2P    `yaml:"p2p,omitempty"`
-	K3sAgent  K3s     `yaml:"k3s-agent,omitempty"`
-	K3s       K3s     `yaml:"k3s,omitempty"`
-	KubeVIP   KubeVIP `yaml:"kubevip,omitempty"`
-	K0sWorker K0s     `yaml:"k0s-worker,omitempty"`
-	K0s       K0s     `yaml:"k0s,omitempty"`
+	P2P       *P2P    `yaml:"p2p,omitempty" description:"Peer to peer coordination of the cluster"`
+	K3sAgent  K3s     `yaml:"k3s-agent,omitempty" description:"Standalone k3s agent"`
+	K3s       K3s     `yaml:"k3s,omitempty" description:"k3s server, or k3s settings of p2p nodes"`
+	KubeVIP   KubeVIP `yaml:"kubevip,omitempty" description:"Virtual IP of the control plane, served by kube-vip"`
+	K0sWorker K0s     `yaml:"k0s-worker,omitempty" description:"Standalone k0s worker"`
+	K0s       K0s     `yaml:"k0s,omitempty" description:"k0s controller, or k0s settings of p2p nodes"`
+	RKE2Agent RKE2    `yaml:"rke2-agent,omitempty" description:"Standalone rke2 agent"`
+	RKE2      RKE2    `yaml:"rke2,omitempty" description:"rke2 server, or rke2 settings of p2p nodes"`
 }
 
 func (c *Config) IsP2PConfigured() bool {
@@ -57,18 +121,20 @@ func (c *Config) IsP2PConfigured() bool {
 }
 
 func (c *Config) IsKubernetesConfigured() bool {
-	return c.K3s.IsEnabled() || c.K3sAgent.IsEnabled() || c.K0s.IsEnabled() || c.K0sWorker.IsEnabled()
+	return c.K3s.IsEnabled() || c.K3sAgent.IsEnabled() || c.K0s.IsEnabled() || c.K0sWorker.IsEnabled() ||
+		c.RKE2.IsEnabled() || c.RKE2Agent.IsEnabled()
 }
 
 type KubeVIP struct {
-	EIP         string `yaml:"eip,omitempty"`
-	ManifestURL string `yaml:"manifest_url,omitempty"`
-	Interface   string `yaml:"interface,omitempty"`
-	Enable      *bool  `yaml:"enable,omitempty"`
-	StaticPod   bool   `yaml:"static_pod,omitempty"`
-	Version     string `yaml:"version,omitempty"`
-	Image       string `yaml:"image,omitempty"`
-	kubevip.Config
+	EIP         string `yaml:"eip,omitempty" example:"192.168.1.110" description:"Virtual IP of the control plane"`
+	ManifestURL string `yaml:"manifest_url,omitempty" description:"URL of extra kube-vip manifests to deploy"`
+	Interface   string `yaml:"interface,omitempty" example:"eth0" description:"Interface the virtual IP is served on, the first one other than lo if not set"`
+	Enable      *bool  `yaml:"enable,omitempty" description:"Enables kube-vip, defaults to true if eip is set"`
+	StaticPod   bool   `yaml:"static_pod,omitempty" description:"Deploys kube-vip as a static pod instead of a daemonset"`
+	Version     string `yaml:"version,omitempty" description:"Version of the kube-vip image"`
+	Image       string `yaml:"image,omitempty" description:"kube-vip image"`
+	// Config is the kube-vip config, the key yaml gives the embedded struct
+	kubevip.Config `yaml:"config,omitempty" description:"kube-vip settings, see the kube-vip documentation"`
 }
 
 func (k KubeVIP) IsEnabled() bool {
@@ -76,8 +142,76 @@ func (k KubeVIP) IsEnabled() bool {
 }
 
 type Auto struct {
-	Enable *bool `yaml:"enable,omitempty"`
-	HA     HA    `yaml:"ha,omitempty"`
+	Enable *bool `yaml:"enable,omitempty" default:"true" description:"Assigns the roles automatically"`
+	HA     HA    `yaml:"ha,omitempty" description:"Highly available control plane"`
+
+	// LeaseTTL is the validity, in seconds, of the leadership lease held by
+	// the node scheduling roles.
+	LeaseTTL int `yaml:"lease_ttl,omitempty" default:"60" minimum:"0" description:"Validity in seconds of the leadership lease of the node scheduling roles"`
+
+	// Placement pins nodes to roles, the first matching rule wins
+	Placement []Placement `yaml:"placement,omitempty" description:"Pins nodes to roles, the first matching rule wins"`
+	// WorkerPools group the workers, the first matching pool wins
+	WorkerPools []WorkerPool `yaml:"worker_pools,omitempty" description:"Groups of workers, the first matching pool wins"`
+}
+
+// NodeSelector selects nodes by UUID, hostname and advertised labels.
+type NodeSelector struct {
+	UUIDs []string `yaml:"uuids,omitempty" description:"Machine UUIDs of the nodes"`
+	// Hostname is a glob, as in path.Match
+	Hostname string            `yaml:"hostname,omitempty" example:"edge-*" description:"Glob matching the hostname of the nodes"`
+	Labels   map[string]string `yaml:"labels,omitempty" description:"Labels the nodes advertise"`
+}
+
+// Matches returns true if the node matches all the selectors. A selector
+// without any criteria matches no node.
+func (s NodeSelector) Matches(uuid, hostname string, labels map[string]string) bool {
+	if len(s.UUIDs) == 0 && s.Hostname == "" && len(s.Labels) == 0 {
+		return false
+	}
+	if len(s.UUIDs) > 0 && !slices.Contains(s.UUIDs, uuid) {
+		return false
+	}
+	if s.Hostname != "" {
+		if ok, _ := path.Match(s.Hostname, hostname); !ok {
+			return false
+		}
+	}
+	for k, v := range s.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Placement pins the nodes it selects to a role, either master or worker.
+// Once any rule pins masters, only the nodes it matches are considered
+// for the control plane.
+type Placement struct {
+	Role         string `yaml:"role" required:"true" enum:"[\"master\",\"worker\"]" description:"Role the nodes are pinned to"`
+	NodeSelector `yaml:",inline"`
+}
+
+// WorkerPool is a named group of workers sharing extra agent args, and
+// the labels and taints of their Kubernetes nodes.
+type WorkerPool struct {
+	Name         string `yaml:"name" required:"true" description:"Name of the pool"`
+	NodeSelector `yaml:",inline"`
+	Args         []string          `yaml:"args,omitempty" description:"Extra args of the agent of the workers"`
+	NodeLabels   map[string]string `yaml:"node_labels,omitempty" description:"Labels of the Kubernetes nodes"`
+	// Taints are in the key=value:Effect form
+	Taints []string `yaml:"taints,omitempty" example:"[\"gpu=true:NoSchedule\"]" description:"Taints of the Kubernetes nodes, as key=value:Effect"`
+}
+
+// WorkerPool returns the worker pool with the given name, if configured.
+func (a Auto) WorkerPool(name string) (WorkerPool, bool) {
+	for _, p := range a.WorkerPools {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return WorkerPool{}, false
 }
 
 func (a Auto) IsEnabled() bool {
@@ -89,18 +223,24 @@ func (ha HA) IsEnabled() bool {
 }
 
 type HA struct {
-	Enable      *bool  `yaml:"enable,omitempty"`
-	ExternalDB  string `yaml:"external_db,omitempty"`
-	MasterNodes *int   `yaml:"master_nodes,omitempty"`
+	Enable      *bool  `yaml:"enable,omitempty" description:"Enables HA, defaults to true if master_nodes is set"`
+	ExternalDB  string `yaml:"external_db,omitempty" description:"Datastore endpoint of the control plane, instead of the embedded etcd"`
+	MasterNodes *int   `yaml:"master_nodes,omitempty" minimum:"1" description:"Number of masters on top of the one initializing the cluster"`
+
+	// Failover promotes a master/ha node when the clusterinit master is gone
+	Failover bool `yaml:"failover,omitempty" description:"Promotes a master when the one initializing the cluster is gone"`
+	// FailoverGracePeriod is how long, in seconds, the clusterinit master
+	// can stay unreachable before failing over
+	FailoverGracePeriod int `yaml:"failover_grace_period,omitempty" default:"300" minimum:"0" description:"Time in seconds the initializing master can stay unreachable before failing over"`
 }
 
 type K3s struct {
-	Env              map[string]string `yaml:"env,omitempty"`
-	ReplaceEnv       bool              `yaml:"replace_env,omitempty"`
-	ReplaceArgs      bool              `yaml:"replace_args,omitempty"`
-	Args             []string          `yaml:"args,omitempty"`
-	Enabled          *bool             `yaml:"enabled,omitempty"`
-	EmbeddedRegistry bool              `yaml:"embedded_registry,omitempty"`
+	Env              map[string]string `yaml:"env,omitempty" description:"Environment of the service"`
+	ReplaceEnv       bool              `yaml:"replace_env,omitempty" description:"Replaces the environment generated by the provider instead of extending it"`
+	ReplaceArgs      bool              `yaml:"replace_args,omitempty" description:"Replaces the args generated by the provider instead of extending them"`
+	Args             []string          `yaml:"args,omitempty" description:"Extra args of the service"`
+	Enabled          *bool             `yaml:"enabled,omitempty" description:"Deploys the distribution standalone, without p2p"`
+	EmbeddedRegistry bool              `yaml:"embedded_registry,omitempty" description:"Enables the embedded registry mirror"`
 }
 
 func (k K3s) IsEnabled() bool {
@@ -108,13 +248,40 @@ func (k K3s) IsEnabled() bool {
 }
 
 type K0s struct {
-	Env         map[string]string `yaml:"env,omitempty"`
-	ReplaceEnv  bool              `yaml:"replace_env,omitempty"`
-	ReplaceArgs bool              `yaml:"replace_args,omitempty"`
-	Args        []string          `yaml:"args,omitempty"`
-	Enabled     *bool             `yaml:"enabled,omitempty"`
+	Env         map[string]string `yaml:"env,omitempty" description:"Environment of the service"`
+	ReplaceEnv  bool              `yaml:"replace_env,omitempty" description:"Replaces the environment generated by the provider instead of extending it"`
+	ReplaceArgs bool              `yaml:"replace_args,omitempty" description:"Replaces the args generated by the provider instead of extending them"`
+	Args        []string          `yaml:"args,omitempty" description:"Extra args of the service"`
+	Enabled     *bool             `yaml:"enabled,omitempty" description:"Deploys the distribution standalone, without p2p"`
+	// Config is a partial k0s ClusterConfig, merged over the one generated by k0s
+	Config map[string]interface{} `yaml:"config,omitempty" description:"Partial k0s ClusterConfig, merged over the one generated by k0s"`
 }
 
 func (k K0s) IsEnabled() bool {
 	return k.Enabled != nil && *k.Enabled
 }
+
+type RKE2 struct {
+	Env         map[string]string `yaml:"env,omitempty" description:"Environment of the service"`
+	ReplaceEnv  bool              `yaml:"replace_env,omitempty" description:"Replaces the environment generated by the provider instead of extending it"`
+	ReplaceArgs bool              `yaml:"replace_args,omitempty" description:"Replaces the args generated by the provider instead of extending them"`
+	Args        []string          `yaml:"args,omitempty" description:"Extra args of the service"`
+	Enabled     *bool             `yaml:"enabled,omitempty" description:"Deploys the distribution standalone, without p2p"`
+	// Config is merged into the generated /etc/rancher/rke2/config.yaml,
+	// taking precedence over the generated settings
+	Config map[string]interface{} `yaml:"config,omitempty" description:"Merged into /etc/rancher/rke2/config.yaml, over the generated settings"`
+}
+
+func (r RKE2) IsEnabled() bool {
+	return r.Enabled != nil && *r.Enabled
+}
+
+// Build configures how the distribution is installed at image build time.
+// It is passed as the config of the build event.
+type Build struct {
+	// Source is a mirror of the release artifacts, as an http(s) or file:// URL
+	Source string `yaml:"source,omitempty"`
+	// Airgap preloads the images of the distribution and of kube-vip, for
+	// nodes without access to a registry
+	Airgap bool `yaml:"airgap,omitempty"`
+}
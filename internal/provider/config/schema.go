@@ -0,0 +1,30 @@
+package config
+
+import (
+	"encoding/json"
+
+	jsonschema "github.com/swaggest/jsonschema-go"
+)
+
+// JSONSchema returns the JSON Schema of the provider blocks of the cloud
+// config, with the descriptions and defaults of the fields. If url is set
+// it is the $id of the schema.
+func JSONSchema(url string) ([]byte, error) {
+	reflector := jsonschema.Reflector{}
+	schema, err := reflector.Reflect(Config{},
+		// The config is read with yaml, fields without a yaml tag fall back to json
+		jsonschema.PropertyNameTag("yaml", "json"),
+		jsonschema.InlineRefs,
+		jsonschema.SkipUnsupportedProperties,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.WithSchema("http://json-schema.org/draft-07/schema#")
+	schema.WithTitle("Kairos provider config")
+	if url != "" {
+		schema.WithID(url)
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type schemaNode struct {
+	ID          string                `json:"$id"`
+	Description string                `json:"description"`
+	Default     any                   `json:"default"`
+	Enum        []string              `json:"enum"`
+	Required    []string              `json:"required"`
+	Properties  map[string]schemaNode `json:"properties"`
+	Items       *schemaNode           `json:"items"`
+}
+
+var _ = Describe("JSONSchema", func() {
+	var schema schemaNode
+
+	BeforeEach(func() {
+		data, err := JSONSchema("https://example.com/provider.json")
+		Expect(err).ToNot(HaveOccurred())
+		schema = schemaNode{}
+		Expect(json.Unmarshal(data, &schema)).To(Succeed())
+	})
+
+	It("has the provider blocks, by their yaml keys", func() {
+		Expect(schema.ID).To(Equal("https://example.com/provider.json"))
+		Expect(schema.Properties).To(HaveKey("p2p"))
+		Expect(schema.Properties).To(HaveKey("k3s-agent"))
+		Expect(schema.Properties).To(HaveKey("k0s-worker"))
+		Expect(schema.Properties).To(HaveKey("rke2-agent"))
+		Expect(schema.Properties["k3s"].Properties).To(HaveKey("replace_args"))
+	})
+
+	It("describes the p2p settings with their defaults", func() {
+		p2p := schema.Properties["p2p"]
+		Expect(p2p.Properties["network_token"].Description).ToNot(BeEmpty())
+		Expect(p2p.Properties["role"].Enum).To(Equal([]string{"master", "worker"}))
+		Expect(p2p.Properties["minimum_nodes"].Default).To(BeEquivalentTo(2))
+		Expect(p2p.Properties["vpn"].Properties["create"].Default).To(BeTrue())
+
+		auto := p2p.Properties["auto"]
+		Expect(auto.Properties["enable"].Default).To(BeTrue())
+		Expect(auto.Properties["ha"].Properties["master_nodes"].Description).ToNot(BeEmpty())
+		Expect(auto.Properties["ha"].Properties["failover_grace_period"].Default).To(BeEquivalentTo(300))
+
+		pools := auto.Properties["worker_pools"].Items
+		Expect(pools.Required).To(Equal([]string{"name"}))
+		// The node selector is inlined
+		Expect(pools.Properties).To(HaveKey("hostname"))
+		Expect(pools.Properties).To(HaveKey("taints"))
+	})
+
+	It("nests the kube-vip settings under config", func() {
+		kubevip := schema.Properties["kubevip"]
+		Expect(kubevip.Properties).To(HaveKey("eip"))
+		Expect(kubevip.Properties).To(HaveKey("config"))
+		Expect(kubevip.Properties["config"].Properties).ToNot(BeEmpty())
+	})
+})
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Severities of the problems found by Validate
+const (
+	// SeverityError is a config the provider refuses, or can't bootstrap with
+	SeverityError = "error"
+	// SeverityWarning is a config that is likely not doing what is meant
+	SeverityWarning = "warning"
+)
+
+// Problem is a mistake in the provider config, at a YAML path.
+type Problem struct {
+	Path     string `json:"path" yaml:"path"`
+	Severity string `json:"severity" yaml:"severity"`
+	Message  string `json:"message" yaml:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Path, p.Message)
+}
+
+// Problems are all the problems found in a config.
+type Problems []Problem
+
+// HasErrors returns true if any of the problems is an error.
+func (p Problems) HasErrors() bool {
+	return slices.ContainsFunc(p, func(p Problem) bool { return p.Severity == SeverityError })
+}
+
+func (p *Problems) errorf(path, format string, a ...any) {
+	*p = append(*p, Problem{Path: path, Severity: SeverityError, Message: fmt.Sprintf(format, a...)})
+}
+
+func (p *Problems) warnf(path, format string, a ...any) {
+	*p = append(*p, Problem{Path: path, Severity: SeverityWarning, Message: fmt.Sprintf(format, a...)})
+}
+
+// Validate checks the provider rules the JSON schema of the cloud config
+// knows nothing about, and reports every problem found.
+func Validate(c *Config) Problems {
+	problems := Problems{}
+	validateDistros(c, &problems)
+	validateKubeVIP(c, &problems)
+	if c.P2P != nil {
+		validateP2P(c, &problems)
+	}
+	return problems
+}
+
+func validateDistros(c *Config, problems *Problems) {
+	enabled := []string{}
+	for _, d := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"k3s", c.K3s.IsEnabled()},
+		{"k3s-agent", c.K3sAgent.IsEnabled()},
+		{"k0s", c.K0s.IsEnabled()},
+		{"k0s-worker", c.K0sWorker.IsEnabled()},
+		{"rke2", c.RKE2.IsEnabled()},
+		{"rke2-agent", c.RKE2Agent.IsEnabled()},
+	} {
+		if d.enabled {
+			enabled = append(enabled, d.name)
+		}
+	}
+	for _, name := range enabled[min(1, len(enabled)):] {
+		problems.errorf(name+".enabled", "only one Kubernetes block can be enabled, %s is already enabled and takes precedence", enabled[0])
+	}
+
+	if c.P2P == nil || !c.P2P.UseVPNWithKubernetes() {
+		return
+	}
+	// Nodes talk to each other over the VPN, flannel has to use its interface
+	for _, k := range []struct {
+		name string
+		K3s
+	}{{"k3s", c.K3s}, {"k3s-agent", c.K3sAgent}} {
+		if k.ReplaceArgs && !slices.ContainsFunc(k.Args, func(a string) bool { return strings.HasPrefix(a, "--flannel-iface") }) {
+			problems.errorf(k.name+".replace_args", "replaces --flannel-iface=edgevpn0, which is required to use the VPN with Kubernetes")
+		}
+	}
+	if c.K0sWorker.ReplaceArgs && !slices.ContainsFunc(c.K0sWorker.Args, func(a string) bool { return strings.HasPrefix(a, "--token-file") }) {
+		problems.errorf("k0s-worker.replace_args", "replaces --token-file /etc/k0s/token, which is required to join the cluster")
+	}
+}
+
+func validateKubeVIP(c *Config, problems *Problems) {
+	k := c.KubeVIP
+	if !k.IsEnabled() {
+		return
+	}
+	if k.EIP == "" {
+		problems.errorf("kubevip.eip", "is required when kubevip is enabled")
+		return
+	}
+	if k.Interface == "" {
+		problems.warnf("kubevip.interface", "not set, the first interface of the node other than lo is used")
+	}
+}
+
+func validateP2P(c *Config, problems *Problems) {
+	p := c.P2P
+	if p.NetworkToken == "" && !c.IsKubernetesConfigured() {
+		problems.errorf("p2p.network_token", "is required unless a Kubernetes block is enabled")
+	}
+	if p.Role != "" && p.Role != "master" && p.Role != "worker" {
+		problems.errorf("p2p.role", "must be 'master' or 'worker', not %q", p.Role)
+	}
+
+	for _, n := range []struct {
+		path  string
+		value int
+	}{
+		{"p2p.minimum_nodes", p.MinimumNodes},
+		{"p2p.join_token_ttl", p.JoinTokenTTL},
+		{"p2p.auto.lease_ttl", p.Auto.LeaseTTL},
+		{"p2p.auto.ha.failover_grace_period", p.Auto.HA.FailoverGracePeriod},
+	} {
+		if n.value < 0 {
+			problems.errorf(n.path, "can't be negative")
+		}
+	}
+
+	switch p.Identity.Backend {
+	case "", "auto", "tpm", "software", "simulator":
+	default:
+		problems.errorf("p2p.identity.backend", "must be one of auto, tpm, software or simulator, not %q", p.Identity.Backend)
+	}
+
+	validateHA(p, problems)
+	validatePlacement(p.Auto, problems)
+	validateWorkerPools(p.Auto, problems)
+}
+
+func validateHA(p *P2P, problems *Problems) {
+	ha := p.Auto.HA
+	if !ha.IsEnabled() {
+		if ha.Failover {
+			problems.warnf("p2p.auto.ha.failover", "has no effect without HA")
+		}
+		return
+	}
+	if ha.MasterNodes == nil {
+		return
+	}
+	if *ha.MasterNodes < 1 {
+		problems.errorf("p2p.auto.ha.master_nodes", "must be at least 1")
+		return
+	}
+
+	minimumNodes := p.MinimumNodes
+	if minimumNodes == 0 {
+		minimumNodes = 2
+	}
+	// The clusterinit master comes on top of the master/ha nodes
+	if *ha.MasterNodes+1 > minimumNodes {
+		problems.warnf("p2p.auto.ha.master_nodes", "%d masters and the clusterinit one need more nodes than p2p.minimum_nodes (%d), roles are scheduled before the control plane can be complete",
+			*ha.MasterNodes, minimumNodes)
+	}
+}
+
+func validateSelector(s NodeSelector, at string, problems *Problems) {
+	if len(s.UUIDs) == 0 && s.Hostname == "" && len(s.Labels) == 0 {
+		problems.warnf(at, "has no uuids, hostname or labels, it matches no node")
+	}
+	if _, err := path.Match(s.Hostname, ""); err != nil {
+		problems.errorf(at+".hostname", "invalid pattern %q: %s", s.Hostname, err.Error())
+	}
+}
+
+func validatePlacement(a Auto, problems *Problems) {
+	for i, p := range a.Placement {
+		at := fmt.Sprintf("p2p.auto.placement[%d]", i)
+		if p.Role != "master" && p.Role != "worker" {
+			problems.errorf(at+".role", "must be 'master' or 'worker', not %q", p.Role)
+		}
+		validateSelector(p.NodeSelector, at, problems)
+	}
+}
+
+func validateWorkerPools(a Auto, problems *Problems) {
+	names := map[string]bool{}
+	for i, p := range a.WorkerPools {
+		at := fmt.Sprintf("p2p.auto.worker_pools[%d]", i)
+		switch {
+		case p.Name == "":
+			problems.errorf(at+".name", "is required")
+		case names[p.Name]:
+			problems.errorf(at+".name", "pool %q is already defined", p.Name)
+		}
+		names[p.Name] = true
+
+		validateSelector(p.NodeSelector, at, problems)
+		for j, t := range p.Taints {
+			if !validTaint(t) {
+				problems.errorf(fmt.Sprintf("%s.taints[%d]", at, j), "%q is not in the key=value:Effect form, with NoSchedule, PreferNoSchedule or NoExecute as effect", t)
+			}
+		}
+	}
+}
+
+func validTaint(t string) bool {
+	kv, effect, ok := strings.Cut(t, ":")
+	if !ok || !slices.Contains([]string{"NoSchedule", "PreferNoSchedule", "NoExecute"}, effect) {
+		return false
+	}
+	key, _, _ := strings.Cut(kv, "=")
+	return key != ""
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+func validate(config string) Problems {
+	c := &Config{}
+	ExpectWithOffset(1, yaml.Unmarshal([]byte(config), c)).To(Succeed())
+	return Validate(c)
+}
+
+func paths(problems Problems, severity string) []string {
+	p := []string{}
+	for _, problem := range problems {
+		if problem.Severity == severity {
+			p = append(p, problem.Path)
+		}
+	}
+	return p
+}
+
+var _ = Describe("Validate", func() {
+	It("accepts a valid config", func() {
+		problems := validate(`
+p2p:
+  network_token: token
+  minimum_nodes: 3
+  auto:
+    ha:
+      master_nodes: 2
+    placement:
+    - role: master
+      hostname: "cp-*"
+    worker_pools:
+    - name: gpu
+      labels:
+        gpu: "true"
+      taints: ["nvidia.com/gpu=true:NoSchedule"]
+kubevip:
+  eip: 10.1.0.100
+  interface: eth0
+`)
+		Expect(problems).To(BeEmpty())
+		Expect(problems.HasErrors()).To(BeFalse())
+	})
+
+	It("reports conflicting Kubernetes blocks", func() {
+		problems := validate(`
+k3s:
+  enabled: true
+k0s:
+  enabled: true
+rke2-agent:
+  enabled: true
+`)
+		Expect(paths(problems, SeverityError)).To(Equal([]string{"k0s.enabled", "rke2-agent.enabled"}))
+		Expect(problems[0].Message).To(ContainSubstring("k3s is already enabled"))
+	})
+
+	It("reports args replaced without the flannel interface", func() {
+		problems := validate(`
+p2p:
+  network_token: token
+k3s:
+  replace_args: true
+  args: ["--disable=traefik"]
+k3s-agent:
+  replace_args: true
+  args: ["--flannel-iface=edgevpn0"]
+`)
+		Expect(paths(problems, SeverityError)).To(Equal([]string{"k3s.replace_args"}))
+
+		problems = validate(`
+p2p:
+  network_token: token
+  vpn:
+    use: false
+k3s:
+  replace_args: true
+`)
+		Expect(problems).To(BeEmpty())
+	})
+
+	It("reports p2p mistakes", func() {
+		problems := validate(`
+p2p:
+  role: auto
+  join_token_ttl: -1
+  identity:
+    backend: hsm
+  auto:
+    ha:
+      failover: true
+`)
+		Expect(paths(problems, SeverityError)).To(Equal([]string{
+			"p2p.network_token",
+			"p2p.role",
+			"p2p.join_token_ttl",
+			"p2p.identity.backend",
+		}))
+		Expect(paths(problems, SeverityWarning)).To(Equal([]string{"p2p.auto.ha.failover"}))
+		Expect(problems[1].String()).To(Equal(`error: p2p.role: must be 'master' or 'worker', not "auto"`))
+	})
+
+	It("reports HA control planes larger than the minimum nodes", func() {
+		problems := validate(`
+p2p:
+  network_token: token
+  auto:
+    ha:
+      master_nodes: 2
+`)
+		Expect(paths(problems, SeverityWarning)).To(Equal([]string{"p2p.auto.ha.master_nodes"}))
+		Expect(problems.HasErrors()).To(BeFalse())
+
+		problems = validate(`
+p2p:
+  network_token: token
+  auto:
+    ha:
+      master_nodes: 0
+`)
+		Expect(paths(problems, SeverityError)).To(Equal([]string{"p2p.auto.ha.master_nodes"}))
+	})
+
+	It("reports kube-vip without an address or interface", func() {
+		Expect(paths(validate("kubevip:\n  enable: true\n"), SeverityError)).To(Equal([]string{"kubevip.eip"}))
+		Expect(paths(validate("kubevip:\n  eip: 10.1.0.100\n"), SeverityWarning)).To(Equal([]string{"kubevip.interface"}))
+	})
+
+	It("reports invalid placement rules and worker pools", func() {
+		problems := validate(`
+p2p:
+  network_token: token
+  auto:
+    placement:
+    - role: etcd
+      uuids: ["a"]
+    - role: worker
+    worker_pools:
+    - name: gpu
+      hostname: "gpu-["
+      taints: ["gpu", "gpu=true:NoSchedule", "=x:NoExecute"]
+    - name: gpu
+      uuids: ["b"]
+    - uuids: ["c"]
+`)
+		Expect(paths(problems, SeverityError)).To(Equal([]string{
+			"p2p.auto.placement[0].role",
+			"p2p.auto.worker_pools[0].hostname",
+			"p2p.auto.worker_pools[0].taints[0]",
+			"p2p.auto.worker_pools[0].taints[2]",
+			"p2p.auto.worker_pools[1].name",
+			"p2p.auto.worker_pools[2].name",
+		}))
+		Expect(paths(problems, SeverityWarning)).To(Equal([]string{"p2p.auto.placement[1]"}))
+	})
+})
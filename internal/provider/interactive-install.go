@@ -5,6 +5,7 @@ import (
 
 	"github.com/kairos-io/kairos-sdk/bus"
 	"github.com/kairos-io/kairos-sdk/utils"
+	p2p "github.com/kairos-io/provider-kairos/v2/internal/role/p2p"
 
 	"github.com/mudler/edgevpn/pkg/node"
 	"github.com/mudler/go-pluggable"
@@ -34,6 +35,12 @@ func InteractiveInstall(e *pluggable.Event) pluggable.EventResponse { //nolint:r
 			Bool:        true,
 			Prompt:      "Do you want to enable k0s?",
 		})
+	} else if p2p.RKE2Bin() != "" {
+		prompts = append(prompts, bus.YAMLPrompt{
+			YAMLSection: "rke2.enabled",
+			Bool:        true,
+			Prompt:      "Do you want to enable rke2?",
+		})
 	}
 
 	payload, err := json.Marshal(prompts)
@@ -19,6 +19,8 @@ const (
 	enabledValue                = "true"
 	DefaultEdgeVPNAPIAddress    = "unix:///run/edgevpn-kairos.sock"
 	DefaultEdgeVPNAPISocketMode = "0600"
+	// EdgeVPNEnvFile is the env file of the edgevpn instance, under the root dir
+	EdgeVPNEnvFile = "/etc/systemd/system.conf.d/edgevpn-kairos.env"
 )
 
 func normalizeAPIAddress(apiAddress string) string {
@@ -44,16 +46,8 @@ func SaveCloudConfig(name string, c []byte) error {
 	return os.WriteFile(filepath.Join("oem", fmt.Sprintf("%s.yaml", name)), c, 0700)
 }
 
-func SetupAPI(apiAddress, rootDir string, start bool, c *providerConfig.Config) error {
-	if c.P2P == nil || c.P2P.NetworkToken == "" {
-		return fmt.Errorf("no network token defined")
-	}
-
-	svc, err := services.P2PAPI(rootDir)
-	if err != nil {
-		return fmt.Errorf("could not create svc: %w", err)
-	}
-
+// APIEnv returns the env of the edgevpn instance only serving the API.
+func APIEnv(apiAddress string, c *providerConfig.Config) map[string]string {
 	vpnOpts := map[string]string{
 		"EDGEVPNTOKEN": c.P2P.NetworkToken,
 		"APILISTEN":    normalizeAPIAddress(apiAddress),
@@ -63,10 +57,22 @@ func SetupAPI(apiAddress, rootDir string, start bool, c *providerConfig.Config)
 	if c.P2P.DisableDHT {
 		vpnOpts["EDGEVPNDHT"] = "false"
 	}
+	return vpnOpts
+}
+
+func SetupAPI(apiAddress, rootDir string, start bool, c *providerConfig.Config) error {
+	if c.P2P == nil || c.P2P.NetworkToken == "" {
+		return fmt.Errorf("no network token defined")
+	}
+
+	svc, err := services.P2PAPI(rootDir)
+	if err != nil {
+		return fmt.Errorf("could not create svc: %w", err)
+	}
 
 	os.MkdirAll("/etc/systemd/system.conf.d/", 0600) //nolint:errcheck
 	// Setup edgevpn instance
-	err = utils.WriteEnv(filepath.Join(rootDir, "/etc/systemd/system.conf.d/edgevpn-kairos.env"), vpnOpts)
+	err = utils.WriteEnv(filepath.Join(rootDir, EdgeVPNEnvFile), APIEnv(apiAddress, c))
 	if err != nil {
 		return fmt.Errorf("could not create write env file: %w", err)
 	}
@@ -87,17 +93,13 @@ func SetupAPI(apiAddress, rootDir string, start bool, c *providerConfig.Config)
 	return nil
 }
 
-func SetupVPN(instance, apiAddress, rootDir string, start bool, c *providerConfig.Config) error {
+// VPNEnv returns the env of the edgevpn instance creating the VPN.
+func VPNEnv(apiAddress string, c *providerConfig.Config) map[string]string {
 	token := ""
 	if c.P2P != nil && c.P2P.NetworkToken != "" {
 		token = c.P2P.NetworkToken
 	}
 
-	svc, err := services.EdgeVPN(instance, rootDir)
-	if err != nil {
-		return fmt.Errorf("could not create svc: %w", err)
-	}
-
 	vpnOpts := map[string]string{
 		"API":          enabledValue,
 		"APILISTEN":    normalizeAPIAddress(apiAddress),
@@ -117,7 +119,19 @@ func SetupVPN(instance, apiAddress, rootDir string, start bool, c *providerConfi
 	if c.P2P.DNS {
 		vpnOpts["DNSADDRESS"] = "127.0.0.1:53"
 		vpnOpts["DNSFORWARD"] = enabledValue
+	}
+	return vpnOpts
+}
 
+func SetupVPN(instance, apiAddress, rootDir string, start bool, c *providerConfig.Config) error {
+	svc, err := services.EdgeVPN(instance, rootDir)
+	if err != nil {
+		return fmt.Errorf("could not create svc: %w", err)
+	}
+
+	vpnOpts := VPNEnv(apiAddress, c)
+
+	if c.P2P.DNS {
 		_ = machine.ExecuteInlineCloudConfig(assets.LocalDNS, "initramfs")
 		if !utils.IsOpenRCBased() {
 			svc, err := systemd.NewService(
@@ -135,7 +149,7 @@ func SetupVPN(instance, apiAddress, rootDir string, start bool, c *providerConfi
 
 	os.MkdirAll("/etc/systemd/system.conf.d/", 0600) //nolint:errcheck
 	// Setup edgevpn instance
-	err = utils.WriteEnv(filepath.Join(rootDir, "/etc/systemd/system.conf.d/edgevpn-kairos.env"), vpnOpts)
+	err = utils.WriteEnv(filepath.Join(rootDir, EdgeVPNEnvFile), vpnOpts)
 	if err != nil {
 		return fmt.Errorf("could not create write env file: %w", err)
 	}
@@ -0,0 +1,73 @@
+package role
+
+import (
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	"github.com/mudler/edgevpn/api/client/service"
+	"github.com/samber/lo"
+)
+
+// Admission states, stored under admission/<uuid>. They are sealed with
+// the cluster secret, so that nodes can't approve themselves.
+const (
+	AdmissionPending  = "pending"
+	AdmissionApproved = "approved"
+	AdmissionRejected = "rejected"
+)
+
+// ApproveNode admits a node, which gets a role on the next scheduling round.
+func ApproveNode(l ledger.Ledger, uuid string) error {
+	return l.Set("admission", uuid, AdmissionApproved)
+}
+
+// RejectNode keeps a node from ever getting a role.
+func RejectNode(l ledger.Ledger, uuid string) error {
+	return l.Set("admission", uuid, AdmissionRejected)
+}
+
+// PendingNodes returns the advertizing nodes waiting for an approval.
+func PendingNodes(l ledger.Ledger) ([]string, error) {
+	nodes, err := l.AdvertizingNodes()
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(nodes, func(u string, _ int) bool {
+		state, _ := l.Get("admission", u)
+		return state == AdmissionPending
+	}), nil
+}
+
+// admitNodes returns the nodes which are admitted to get a role. New nodes
+// are admitted if their UUID is allowlisted, or their identity when it was
+// verified, and are put on the pending list otherwise. Nodes which got a
+// role before admission was enabled need an approval too, as roles are
+// written by the nodes themselves.
+func admitNodes(nodes []string, c *service.RoleConfig, l ledger.Ledger, allow []string, verifiedIdentities bool) ([]string, error) {
+	admitted := []string{}
+	for _, u := range nodes {
+		state, _ := l.Get("admission", u)
+		switch state {
+		case AdmissionApproved:
+			admitted = append(admitted, u)
+			continue
+		case AdmissionRejected:
+			continue
+		}
+
+		id, _ := ledger.GetIdentity(l, u)
+		if lo.Contains(allow, u) || (verifiedIdentities && id.ID != "" && lo.Contains(allow, id.ID)) {
+			if err := ApproveNode(l, u); err != nil {
+				return nodes, err
+			}
+			admitted = append(admitted, u)
+			continue
+		}
+
+		if state == "" {
+			c.Logger.Infof("Node '%s' is waiting for an approval", u)
+			if err := l.Set("admission", u, AdmissionPending); err != nil {
+				return nodes, err
+			}
+		}
+	}
+	return admitted, nil
+}
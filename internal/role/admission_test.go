@@ -0,0 +1,71 @@
+package role
+
+import (
+	logging "github.com/ipfs/go-log"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Node admission", func() {
+	var (
+		l     ledger.Ledger
+		c     *service.RoleConfig
+		nodes = []string{"leader", "old", "new", "allowed"}
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		l = ledger.NewMemoryNetwork(nodes...).Node("leader")
+		c = &service.RoleConfig{UUID: "leader", Logger: logging.Logger("role-test")}
+		Expect(l.Set("role", "old", "master")).To(Succeed())
+	})
+
+	It("holds new nodes in the pending list", func() {
+		admitted, err := admitNodes(nodes, c, l, []string{"allowed"}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(ConsistOf("allowed"))
+
+		Expect(PendingNodes(l)).To(ConsistOf("leader", "old", "new"))
+		Expect(l.Get("admission", "allowed")).To(Equal(AdmissionApproved))
+	})
+
+	It("admits approved nodes", func() {
+		_, err := admitNodes(nodes, c, l, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ApproveNode(l, "new")).To(Succeed())
+		Expect(RejectNode(l, "leader")).To(Succeed())
+
+		admitted, err := admitNodes(nodes, c, l, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(ConsistOf("new"))
+		Expect(PendingNodes(l)).To(ConsistOf("old", "allowed"))
+	})
+
+	It("doesn't admit rejected nodes, even with a role", func() {
+		Expect(RejectNode(l, "old")).To(Succeed())
+
+		admitted, err := admitNodes(nodes, c, l, []string{"old"}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(BeEmpty())
+	})
+
+	It("ignores the approvals which are not sealed with the cluster secret", func() {
+		raw := l
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = ledger.NewSealed(raw, keys)
+
+		Expect(raw.Set("admission", "new", AdmissionApproved)).To(Succeed())
+		admitted, err := admitNodes(nodes, c, l, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(BeEmpty())
+		Expect(PendingNodes(l)).To(ConsistOf("leader", "old", "new", "allowed"))
+
+		Expect(ApproveNode(l, "new")).To(Succeed())
+		admitted, err = admitNodes(nodes, c, l, nil, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(ConsistOf("new"))
+	})
+})
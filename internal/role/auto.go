@@ -1,10 +1,12 @@
 package role
 
 import (
+	"errors"
+	"time"
+
 	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
 
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
-	utils "github.com/mudler/edgevpn/pkg/utils"
 
 	service "github.com/mudler/edgevpn/api/client/service"
 )
@@ -18,16 +20,40 @@ func contains(slice []string, elem string) bool {
 	return false
 }
 
+// Auto returns the role electing a leader among the nodes, which then
+// schedules roles to everyone else.
 func Auto(cc *sdkConfig.Config, pconfig *providerConfig.Config) Role { //nolint:revive
+	ledgerFor, err := ConfiguredLedger(pconfig)
+	if err != nil {
+		return func(*service.RoleConfig) error { return err }
+	}
+	return AutoWithLedger(cc, pconfig, ledgerFor)
+}
+
+// AutoWithLedger returns the Auto role coordinating through a custom ledger.
+func AutoWithLedger(cc *sdkConfig.Config, pconfig *providerConfig.Config, ledgerFor LedgerProvider) Role { //nolint:revive
+	// The elector keeps the term we were elected with across invocations
+	var e *elector
+	gate := &factsGate{timeout: DefaultFactsTimeout}
+	pinned := pins{}
+	outage := &outageTimer{}
+
 	return func(c *service.RoleConfig) error {
-		advertizing, _ := c.Client.AdvertizingNodes()
-		actives, _ := c.Client.ActiveNodes()
+		l := ledgerFor(c)
+		if err := PublishFacts(l, c.UUID, LocalFacts(pconfig)); err != nil {
+			c.Logger.Warnf("Failed publishing node facts: %s", err.Error())
+		}
+
+		advertizing, _ := l.AdvertizingNodes()
+		actives, _ := l.ActiveNodes()
 
 		minimumNodes := pconfig.P2P.MinimumNodes
 		if minimumNodes == 0 {
 			minimumNodes = 2
 		}
 
+		observeNetwork(l, advertizing, actives, minimumNodes)
+
 		c.Logger.Info("Active nodes:", actives)
 		c.Logger.Info("Advertizing nodes:", advertizing)
 
@@ -45,31 +71,57 @@ func Auto(cc *sdkConfig.Config, pconfig *providerConfig.Config) Role { //nolint:
 
 		// first get available nodes
 		nodes := advertizing
-		shouldBeLeader := utils.Leader(advertizing)
 
-		lead, _ := c.Client.Get("auto", "leader")
+		if e == nil {
+			e = newElector(c.UUID, time.Duration(pconfig.P2P.Auto.LeaseTTL)*time.Second)
+		}
 
-		// From now on, only the leader keeps processing
-		// TODO: Make this more reliable with consensus
-		if shouldBeLeader != c.UUID && lead != c.UUID {
-			c.Logger.Infof("<%s> not a leader, leader is '%s', sleeping", c.UUID, shouldBeLeader)
-			return nil
+		current, leader, err := e.campaign(l, nodes)
+		if err != nil {
+			c.Logger.Error(err)
+			return err
 		}
+		observeLeader(c.UUID, current)
 
-		if shouldBeLeader == c.UUID && (lead == "" || !contains(nodes, lead)) {
-			if err := c.Client.Set("auto", "leader", c.UUID); err != nil {
-				c.Logger.Error(err)
-				return err
+		// From now on, only the leader keeps processing
+		if !leader {
+			if current.Holder == c.UUID {
+				c.Logger.Infof("Claimed leadership lease for term %d, backing off until it settles", current.Term)
+			} else {
+				c.Logger.Infof("<%s> not a leader, leader is '%s' (term %d), sleeping", c.UUID, current.Holder, current.Term)
 			}
-			c.Logger.Info("Announcing ourselves as leader, backing off")
 			return nil
 		}
 
-		if lead != c.UUID {
-			c.Logger.Info("Backing off, as we are not currently flagged as leader")
+		fenced := fencedLedger{Ledger: l, elector: e}
+		if err := resealSecrets(l, fenced); err != nil && !errors.Is(err, ErrFenced) {
+			c.Logger.Warnf("Failed sealing the cluster credentials again: %s", err.Error())
+		}
+		nodes, err = removeNodes(nodes, c, fenced, func() (*Kubectl, error) { return LedgerKubectl(l) })
+		if err == nil && pconfig.P2P.Identity.IsEnabled() {
+			nodes, err = verifyClaims(nodes, c, l, fenced, pinned)
+		}
+		if err == nil && pconfig.P2P.Admission.IsEnabled() {
+			nodes, err = admitNodes(nodes, c, fenced, pconfig.P2P.Admission.Allow, pconfig.P2P.Identity.IsEnabled())
+		}
+		if err == nil {
+			unassigned, _ := getRoles(l, nodes)
+			if missing := gate.wait(l, unassigned, time.Now()); len(missing) > 0 {
+				c.Logger.Infof("Waiting for the facts of %v before scheduling roles", missing)
+			} else {
+				err = scheduleRoles(nodes, c, fenced, cc, pconfig, outage)
+			}
+		}
+		if err == nil {
+			err = upgradeNodes(nodes, c, fenced, func() (*Kubectl, error) { return LedgerKubectl(l) })
+		}
+		if errors.Is(err, ErrFenced) {
+			c.Logger.Warn("Lost the leadership lease while scheduling, stepping down")
 			return nil
 		}
-
-		return scheduleRoles(nodes, c, cc, pconfig)
+		if err != nil {
+			schedulingErrors.Inc()
+		}
+		return err
 	}
 }
@@ -0,0 +1,151 @@
+package role
+
+import (
+	"fmt"
+
+	logging "github.com/ipfs/go-log"
+	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+)
+
+// simulation runs the Auto role on a set of nodes sharing an in-memory
+// ledger. Nodes which got a role announce it back like the Master and
+// Worker roles do once they are deployed.
+type simulation struct {
+	network *ledger.MemoryNetwork
+	nodes   []string
+	auto    map[string]Role
+}
+
+func newSimulation(pconfig *providerConfig.Config, n int) *simulation {
+	logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+
+	s := &simulation{auto: map[string]Role{}}
+	for i := 0; i < n; i++ {
+		s.nodes = append(s.nodes, fmt.Sprintf("node-%d", i))
+	}
+	s.network = ledger.NewMemoryNetwork(s.nodes...)
+	for _, uuid := range s.nodes {
+		s.auto[uuid] = AutoWithLedger(&sdkConfig.Config{}, pconfig, func(c *service.RoleConfig) ledger.Ledger {
+			return s.network.Node(c.UUID)
+		})
+	}
+	return s
+}
+
+func (s *simulation) ip(uuid string) string {
+	for i, n := range s.nodes {
+		if n == uuid {
+			return fmt.Sprintf("10.1.0.%d", i+1)
+		}
+	}
+	return ""
+}
+
+func (s *simulation) run(rounds int) {
+	for i := 0; i < rounds; i++ {
+		for _, uuid := range s.nodes {
+			l := s.network.Node(uuid)
+			if _, ok := s.auto[uuid]; !ok {
+				continue
+			}
+			Expect(s.auto[uuid](&service.RoleConfig{UUID: uuid, Logger: logging.Logger("role-test")})).To(Succeed())
+
+			role, _ := l.Get("role", uuid)
+			switch role {
+			case "master", "master/clusterinit":
+				Expect(l.Set("role", uuid, role)).To(Succeed())
+				Expect(l.Set("ip", uuid, s.ip(uuid))).To(Succeed())
+				if owner, _ := l.Get("master", "uuid"); owner != "" && owner != uuid {
+					continue
+				}
+				Expect(l.Set("master", "uuid", uuid)).To(Succeed())
+				Expect(l.Set("nodetoken", "token", "secret")).To(Succeed())
+				Expect(l.Set("master", "ip", s.ip(uuid))).To(Succeed())
+			case "master/ha":
+				Expect(l.Set("role", uuid, role)).To(Succeed())
+				Expect(l.Set("ip", uuid, s.ip(uuid))).To(Succeed())
+			case "worker":
+				Expect(l.Set("ip", uuid, s.ip(uuid))).To(Succeed())
+			}
+		}
+	}
+}
+
+func (s *simulation) roles() map[string][]string {
+	roles := map[string][]string{}
+	l := s.network.Node(s.nodes[0])
+	for _, uuid := range s.nodes {
+		if _, ok := s.auto[uuid]; !ok {
+			continue
+		}
+		role, _ := l.Get("role", uuid)
+		roles[role] = append(roles[role], uuid)
+	}
+	return roles
+}
+
+var _ = Describe("Auto role", func() {
+	It("bootstraps a single master with workers", func() {
+		sim := newSimulation(&providerConfig.Config{P2P: &providerConfig.P2P{MinimumNodes: 4}}, 4)
+		sim.run(5)
+
+		roles := sim.roles()
+		Expect(roles["master"]).To(HaveLen(1))
+		Expect(roles["worker"]).To(HaveLen(3))
+		Expect(roles).To(HaveLen(2))
+
+		masterIP, _ := sim.network.Node(sim.nodes[0]).Get("master", "ip")
+		Expect(masterIP).To(Equal(sim.ip(roles["master"][0])))
+	})
+
+	It("bootstraps an HA control plane", func() {
+		masters := 2
+		sim := newSimulation(&providerConfig.Config{P2P: &providerConfig.P2P{
+			MinimumNodes: 5,
+			Auto:         providerConfig.Auto{HA: providerConfig.HA{MasterNodes: &masters}},
+		}}, 5)
+		sim.run(8)
+
+		roles := sim.roles()
+		Expect(roles["master/clusterinit"]).To(HaveLen(1))
+		Expect(roles["master/ha"]).To(HaveLen(2))
+		Expect(roles["worker"]).To(HaveLen(2))
+		Expect(roles).To(HaveLen(3))
+
+		masterIP, _ := sim.network.Node(sim.nodes[0]).Get("master", "ip")
+		Expect(masterIP).To(Equal(sim.ip(roles["master/clusterinit"][0])))
+	})
+
+	It("waits for the minimum amount of nodes", func() {
+		sim := newSimulation(&providerConfig.Config{P2P: &providerConfig.P2P{MinimumNodes: 4}}, 3)
+		sim.run(5)
+
+		Expect(sim.roles()).To(Equal(map[string][]string{"": sim.nodes}))
+	})
+
+	It("unassigns the role of unreachable nodes with dynamic roles", func() {
+		sim := newSimulation(&providerConfig.Config{P2P: &providerConfig.P2P{MinimumNodes: 2, DynamicRoles: true}}, 4)
+		sim.run(5)
+		roles := sim.roles()
+		Expect(roles["worker"]).To(HaveLen(3))
+
+		// Pick a worker which is not leading, the lease would keep it in charge until it expires
+		holder := readLease(sim.network.Node(sim.nodes[0])).Holder
+		gone := lo.Without(roles["worker"], holder)[0]
+		// The node stops advertizing once its announce expires
+		sim.network.Remove(gone)
+		sim.network.Expire(gone)
+		delete(sim.auto, gone)
+		sim.run(3)
+
+		role, _ := sim.network.Node(roles["master"][0]).Get("role", gone)
+		Expect(role).To(BeEmpty())
+		Expect(sim.roles()["worker"]).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,114 @@
+package role
+
+import (
+	"fmt"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/identity"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	"github.com/mudler/edgevpn/api/client/service"
+)
+
+// pin is what the leader records under pinned/<uuid> the first time it sees a node.
+func pin(i ledger.PublishedIdentity) string {
+	return i.ID + "/" + identity.Fingerprint(i.PublicKey)
+}
+
+// pins remembers the identities pinned in the ledger, so that deleting a
+// pin doesn't get the leader to trust the next identity showing up.
+type pins map[string]string
+
+// get returns the identity pinned for a node. Pins are sealed with the
+// cluster secret, an error is returned for the ones which don't open, but
+// not for nodes which were never pinned.
+func (p pins) get(l ledger.Ledger, uuid string) (string, error) {
+	pinned, err := ledger.Lookup(l, "pinned", uuid)
+	if err != nil {
+		return "", fmt.Errorf("the identity pinned for '%s' doesn't verify: %w", uuid, err)
+	}
+	if pinned != "" {
+		p[uuid] = pinned
+	}
+	return p[uuid], nil
+}
+
+// trustedIdentity returns the identity of a node, if it is the one of its
+// key and matches the one pinned for it.
+func trustedIdentity(l ledger.Ledger, p pins, uuid string) (ledger.PublishedIdentity, error) {
+	i, ok := ledger.GetIdentity(l, uuid)
+	if !ok {
+		return i, fmt.Errorf("'%s' didn't publish its identity", uuid)
+	}
+	if i.ID != identity.Fingerprint(i.PublicKey) {
+		return i, fmt.Errorf("the identity '%s' published is not the one of its key", uuid)
+	}
+	pinned, err := p.get(l, uuid)
+	if err != nil {
+		return i, err
+	}
+	if pinned != "" && pinned != pin(i) {
+		return i, fmt.Errorf("the identity of '%s' changed since it was first seen", uuid)
+	}
+	return i, nil
+}
+
+// verifyClaims returns the nodes whose role and IP claims are signed by
+// either themselves or by another trusted node, like the leader which
+// assigned the role. The identity of a node is pinned the first time it is
+// seen, and a node showing up with another identity under the same UUID
+// is refused. The role claims of the nodes refused are cleared, so that
+// the other nodes don't act on them. Reads go through l, writes through w.
+func verifyClaims(nodes []string, c *service.RoleConfig, l, w ledger.Ledger, p pins) ([]string, error) {
+	claims, ok := ledger.As[ledger.ClaimReader](l)
+	if !ok {
+		return nodes, nil
+	}
+
+	verified := []string{}
+	for _, u := range nodes {
+		i, err := trustedIdentity(l, p, u)
+		if err == nil {
+			if pinned, _ := l.Get("pinned", u); pinned == "" {
+				c.Logger.Infof("Pinning the identity of '%s' to %s", u, i.ID)
+				if err := w.Set("pinned", u, pin(i)); err != nil {
+					return nodes, err
+				}
+				p[u] = pin(i)
+			}
+			err = verifyNodeClaims(claims, l, p, u)
+		}
+		if err != nil {
+			c.Logger.Warnf("Refusing node '%s': %s", u, err.Error())
+			if r, _ := l.Get("role", u); r != "" {
+				if err := w.Delete("role", u); err != nil {
+					return nodes, err
+				}
+			}
+			continue
+		}
+		verified = append(verified, u)
+	}
+	return verified, nil
+}
+
+func verifyNodeClaims(claims ledger.ClaimReader, l ledger.Ledger, p pins, uuid string) error {
+	for _, thing := range ledger.SignedKinds {
+		claim, err := claims.Claim(thing, uuid)
+		if err != nil {
+			return err
+		}
+		if claim.Value == "" {
+			continue
+		}
+		if claim.Signer == "" {
+			return fmt.Errorf("its %s claim is not signed", thing)
+		}
+		signer, err := trustedIdentity(l, p, claim.Signer)
+		if err != nil {
+			return fmt.Errorf("its %s claim is signed by an untrusted node: %w", thing, err)
+		}
+		if err := claim.Verify(thing, uuid, signer.PublicKey); err != nil {
+			return fmt.Errorf("its %s claim doesn't verify: %w", thing, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/kairos-io/provider-kairos/v2/internal/identity"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// strictLedger fails reading missing keys, like the edgevpn API client.
+type strictLedger struct {
+	ledger.Ledger
+}
+
+func (s strictLedger) Get(args ...string) (string, error) {
+	v, err := s.Ledger.Get(args...)
+	if err == nil && v == "" {
+		return "", errors.New("unexpected end of JSON input")
+	}
+	return v, err
+}
+
+func (s strictLedger) Unwrap() ledger.Ledger {
+	return s.Ledger
+}
+
+func testIdentity() identity.Identity {
+	dir := GinkgoT().TempDir()
+	Expect(os.WriteFile(filepath.Join(dir, "machine-id"), []byte("machine"), 0600)).To(Succeed())
+	id, err := identity.NewSoftware(filepath.Join(dir, "identity.key"), filepath.Join(dir, "machine-id"))
+	Expect(err).ToNot(HaveOccurred())
+	return id
+}
+
+var _ = Describe("Signed claims", func() {
+	var (
+		network *ledger.MemoryNetwork
+		leader  ledger.Ledger
+		worker  ledger.Ledger
+		c       *service.RoleConfig
+		p       pins
+		nodes   = []string{"leader", "worker"}
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		network = ledger.NewMemoryNetwork(nodes...)
+		leader = ledger.NewSigned(network.Node("leader"), "leader", testIdentity())
+		worker = ledger.NewSigned(network.Node("worker"), "worker", testIdentity())
+		c = &service.RoleConfig{UUID: "leader", Logger: logging.Logger("role-test")}
+		p = pins{}
+
+		Expect(leader.Set("role", "leader", "master")).To(Succeed())
+		Expect(worker.Set("ip", "worker", "10.1.0.2")).To(Succeed())
+	})
+
+	It("accepts claims signed by the node or by the leader", func() {
+		Expect(leader.Set("role", "worker", "worker")).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal(nodes))
+		Expect(leader.Get("pinned", "worker")).ToNot(BeEmpty())
+	})
+
+	It("refuses unsigned claims", func() {
+		Expect(network.Node("worker").Set("role", "worker", "master")).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal([]string{"leader"}))
+		// The other nodes must not act on it
+		Expect(leader.Get("role", "worker")).To(BeEmpty())
+	})
+
+	It("refuses nodes which didn't publish an identity", func() {
+		verified, err := verifyClaims(append(nodes, "other"), c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal(nodes))
+	})
+
+	It("refuses a node showing up with another identity", func() {
+		_, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Another box claiming the same UUID
+		spoofed := ledger.NewSigned(network.Node("worker"), "worker", testIdentity())
+		Expect(spoofed.Set("role", "worker", "master")).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal([]string{"leader"}))
+	})
+
+	It("admits nodes by identity", func() {
+		id, _ := ledger.GetIdentity(leader, "worker")
+		admitted, err := admitNodes(nodes, c, leader, []string{id.ID}, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(Equal([]string{"worker"}))
+	})
+
+	It("doesn't admit nodes by an identity which wasn't verified", func() {
+		id, _ := ledger.GetIdentity(leader, "worker")
+		admitted, err := admitNodes(nodes, c, leader, []string{id.ID}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admitted).To(BeEmpty())
+	})
+
+	It("refuses identities which are not the one of their key", func() {
+		id, _ := ledger.GetIdentity(leader, "worker")
+		forged, err := json.Marshal(ledger.PublishedIdentity{ID: "endorsed", PublicKey: id.PublicKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(network.Node("worker").Set("identity", "worker", string(forged))).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal([]string{"leader"}))
+	})
+
+	It("keeps the pins deleted from the ledger", func() {
+		_, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+
+		spoofed := ledger.NewSigned(network.Node("worker"), "worker", testIdentity())
+		Expect(spoofed.Set("role", "worker", "master")).To(Succeed())
+		Expect(network.Node("worker").Delete("pinned", "worker")).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal([]string{"leader"}))
+	})
+
+	It("refuses the pins which are not sealed with the cluster secret", func() {
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		sealed := ledger.NewSigned(ledger.NewSealed(network.Node("leader"), keys), "leader", testIdentity())
+		Expect(sealed.Set("role", "leader", "master")).To(Succeed())
+
+		// Another box claiming the UUID, pinning its own identity
+		spoofed := ledger.NewSigned(network.Node("worker"), "worker", testIdentity())
+		Expect(spoofed.Set("ip", "worker", "10.1.0.3")).To(Succeed())
+		i, _ := ledger.GetIdentity(spoofed, "worker")
+		Expect(network.Node("worker").Set("pinned", "worker", pin(i))).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, sealed, sealed, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal([]string{"leader"}))
+	})
+
+	It("pins the first nodes on backends failing to read missing keys", func() {
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		network := ledger.NewMemoryNetwork(nodes...)
+		strict := func(uuid string) ledger.Ledger {
+			return ledger.NewSigned(ledger.NewSealed(strictLedger{network.Node(uuid)}, keys), uuid, testIdentity())
+		}
+		leader, worker := strict("leader"), strict("worker")
+		Expect(leader.Set("role", "leader", "master")).To(Succeed())
+		Expect(worker.Set("ip", "worker", "10.1.0.2")).To(Succeed())
+
+		verified, err := verifyClaims(nodes, c, leader, leader, p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(Equal(nodes))
+		Expect(leader.Get("pinned", "worker")).ToNot(BeEmpty())
+		Expect(leader.Get("pinned", "leader")).ToNot(BeEmpty())
+	})
+})
@@ -1,13 +1,107 @@
 package role
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
+	"github.com/kairos-io/provider-kairos/v2/internal/identity"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	service "github.com/mudler/edgevpn/api/client/service"
 )
 
 type Role func(*service.RoleConfig) error
 
+// LedgerProvider returns the ledger a role handler coordinates through.
+type LedgerProvider func(*service.RoleConfig) ledger.Ledger
+
+// DefaultNetworkID is the edgevpn service nodes coordinate through when
+// p2p.network_id is not set.
+const DefaultNetworkID = "kairos"
+
+// NetworkID returns the edgevpn service the nodes of the config coordinate through.
+func NetworkID(pconfig *providerConfig.Config) string {
+	if pconfig.P2P != nil && pconfig.P2P.NetworkID != "" {
+		return pconfig.P2P.NetworkID
+	}
+	return DefaultNetworkID
+}
+
+// EdgeVPNLedger is the LedgerProvider backed by the edgevpn API client of
+// the role, for the given service.
+func EdgeVPNLedger(serviceID string) LedgerProvider {
+	return func(c *service.RoleConfig) ledger.Ledger {
+		return ledger.NewEdgeVPN(c.Client, serviceID)
+	}
+}
+
+// ConfiguredLedger returns the LedgerProvider for the given config. The
+// cluster credentials are sealed when a cluster secret is configured, and
+// the node claims are signed when an identity backend is. Failed writes
+// are counted in the metrics.
+func ConfiguredLedger(pconfig *providerConfig.Config) (LedgerProvider, error) {
+	keys, err := Keyring(pconfig)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil && pconfig.P2P != nil && pconfig.P2P.Admission.IsEnabled() {
+		return nil, errors.New("p2p.admission requires a p2p.cluster_secret, the approvals are sealed with it")
+	}
+	if keys == nil && pconfig.P2P != nil && pconfig.P2P.Identity.IsEnabled() {
+		return nil, errors.New("p2p.identity requires a p2p.cluster_secret, the pinned identities are sealed with it")
+	}
+	var id identity.Identity
+	if pconfig.P2P != nil && pconfig.P2P.Identity.IsEnabled() {
+		id, err = identity.Load(pconfig.P2P.Identity.Backend, pconfig.P2P.Identity.TCTI)
+		if err != nil {
+			return nil, fmt.Errorf("loading the node identity: %w", err)
+		}
+	}
+
+	edgeVPN := EdgeVPNLedger(NetworkID(pconfig))
+	return func(c *service.RoleConfig) ledger.Ledger {
+		l := edgeVPN(c)
+		if keys != nil {
+			l = ledger.NewSealed(l, keys)
+		}
+		if id != nil {
+			l = ledger.NewSigned(l, c.UUID, id)
+		}
+		return instrumentedLedger{Ledger: l}
+	}, nil
+}
+
+// Keyring returns the keyring sealing the cluster credentials, or nil if
+// no cluster secret is configured.
+func Keyring(pconfig *providerConfig.Config) (*ledger.Keyring, error) {
+	if pconfig.P2P == nil || pconfig.P2P.ClusterSecret == "" {
+		return nil, nil
+	}
+	return ledger.NewKeyring(pconfig.P2P.NetworkToken, pconfig.P2P.ClusterSecret, pconfig.P2P.PreviousClusterSecrets...)
+}
+
+// resealSecrets seals again the secrets stored in plain text or sealed with
+// a previous cluster secret, and the admission decisions, pinned identities,
+// removal and upgrade records sealed with a previous cluster secret, so that
+// the previous secret can be dropped. Reads go through l, writes through w.
+func resealSecrets(l, w ledger.Ledger) error {
+	r, ok := ledger.As[ledger.Resealer](l)
+	if !ok {
+		return nil
+	}
+	for _, s := range r.Stale() {
+		v, err := l.Get(s[0], s[1])
+		if err != nil {
+			return err
+		}
+		if err := w.Set(s[0], s[1], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func SentinelExist() bool {
 	if _, err := os.Stat("/usr/local/.kairos/deployed"); err == nil {
 		return true
@@ -19,7 +113,7 @@ func CreateSentinel() error {
 	return os.WriteFile("/usr/local/.kairos/deployed", []byte{}, os.ModePerm)
 }
 
-func getRoles(client *service.Client, nodes []string) ([]string, map[string]string) {
+func getRoles(client ledger.Ledger, nodes []string) ([]string, map[string]string) {
 	unassignedNodes := []string{}
 	currentRoles := map[string]string{}
 	for _, a := range nodes {
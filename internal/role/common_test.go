@@ -0,0 +1,72 @@
+package role
+
+import (
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sealed cluster credentials", func() {
+	It("isn't enabled without a cluster secret", func() {
+		keys, err := Keyring(&providerConfig.Config{P2P: &providerConfig.P2P{NetworkToken: "token"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(BeNil())
+	})
+
+	It("seals again the credentials after a rotation", func() {
+		raw := ledger.NewMemoryNetwork("leader").Node("leader")
+		Expect(raw.Set("nodetoken", "token", "plain")).To(Succeed())
+
+		old, err := Keyring(&providerConfig.Config{P2P: &providerConfig.P2P{NetworkToken: "token", ClusterSecret: "old"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ledger.NewSealed(raw, old).Set("kubeconfig", "master", "config")).To(Succeed())
+
+		keys, err := Keyring(&providerConfig.Config{P2P: &providerConfig.P2P{
+			NetworkToken:           "token",
+			ClusterSecret:          "new",
+			PreviousClusterSecrets: []string{"old"},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		l := ledger.NewSealed(raw, keys)
+		Expect(l.(ledger.Resealer).Stale()).To(HaveLen(2))
+
+		Expect(resealSecrets(l, l)).To(Succeed())
+		Expect(l.(ledger.Resealer).Stale()).To(BeEmpty())
+		Expect(l.Get("nodetoken", "token")).To(Equal("plain"))
+
+		// The previous secret is no longer needed
+		current, err := ledger.NewKeyring("token", "new")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ledger.NewSealed(raw, current).Get("kubeconfig", "master")).To(Equal("config"))
+	})
+
+	It("drops the previous secret after a rotation without refusing the cluster", func() {
+		raw := ledger.NewMemoryNetwork("leader").Node("leader")
+		old, err := ledger.NewKeyring("token", "old")
+		Expect(err).ToNot(HaveOccurred())
+		sealed := ledger.NewSealed(raw, old)
+		Expect(sealed.Set("kubeconfig", "master", "config")).To(Succeed())
+		Expect(ApproveNode(sealed, "worker")).To(Succeed())
+		Expect(sealed.Set("pinned", "worker", "key")).To(Succeed())
+		Expect(RequestRemoval(sealed, "gone")).To(Succeed())
+		Expect(RequestUpgrade(sealed, "v1.30.2+k3s1", "", "sums")).To(Succeed())
+
+		rotated, err := ledger.NewKeyring("token", "new", "old")
+		Expect(err).ToNot(HaveOccurred())
+		l := ledger.NewSealed(raw, rotated)
+		Expect(resealSecrets(l, l)).To(Succeed())
+		Expect(l.(ledger.Resealer).Stale()).To(BeEmpty())
+
+		current, err := ledger.NewKeyring("token", "new")
+		Expect(err).ToNot(HaveOccurred())
+		l = ledger.NewSealed(raw, current)
+		Expect(l.Get("kubeconfig", "master")).To(Equal("config"))
+		Expect(l.Get("admission", "worker")).To(Equal(AdmissionApproved))
+		Expect(l.Get("pinned", "worker")).To(Equal("key"))
+		Expect(l.Get("remove", "gone")).To(Equal(removalRequested))
+		req, ok := getJSON[UpgradeRequest](l, "upgrade", "request")
+		Expect(ok).To(BeTrue())
+		Expect(req.Version).To(Equal("v1.30.2+k3s1"))
+	})
+})
@@ -0,0 +1,169 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+)
+
+// DefaultLeaseTTL is how long a leadership lease stays valid without being renewed.
+const DefaultLeaseTTL = 60 * time.Second
+
+// ErrFenced is returned when a write is attempted by a node that no longer
+// holds the leadership lease it was elected with.
+var ErrFenced = errors.New("leadership lease lost, refusing to write")
+
+// lease is the leadership record stored in the ledger under auto/lease.
+//
+// Nodes don't share a clock, so a lease is not valid until a point in time:
+// every node times it from when it last saw the record change. Renewal
+// changes the record on every renew for followers to notice it.
+type lease struct {
+	Holder  string `json:"holder"`
+	Term    uint64 `json:"term"`
+	Renewal uint64 `json:"renewal"`
+	// Expires is the expiry on the clock of the holder. It is only reported,
+	// as the clocks of the nodes can be skewed.
+	Expires time.Time `json:"expires"`
+}
+
+func readLease(l ledger.Ledger) lease {
+	raw, _ := l.Get("auto", "lease")
+	return parseLease(raw)
+}
+
+func parseLease(raw string) lease {
+	var current lease
+	if raw == "" {
+		return current
+	}
+	// A record we can't parse is treated as a vacant lease
+	if err := json.Unmarshal([]byte(raw), &current); err != nil {
+		return lease{}
+	}
+	return current
+}
+
+// elector runs a lease-based leader election on top of the ledger.
+//
+// The ledger is eventually consistent and has no compare-and-swap, so a
+// lease is only considered acquired once the node reads back its own claim
+// on a later round. Every write performed on behalf of the leader goes
+// through fence, which re-reads the lease and refuses to proceed if the
+// holder or the term changed in the meantime.
+//
+// Leases are timed with the local clock from when the elector last saw the
+// record change, or wrote it. The holder starts its timer before anyone
+// else sees its write, so it steps down before the others take over.
+type elector struct {
+	uuid string
+	ttl  time.Duration
+	now  func() time.Time
+	term uint64
+
+	// seen is the last lease record observed, at seenAt
+	seen   string
+	seenAt time.Time
+}
+
+func newElector(uuid string, ttl time.Duration) *elector {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &elector{uuid: uuid, ttl: ttl, now: time.Now}
+}
+
+// observe reads the lease, and restarts its timer if the record changed.
+func (e *elector) observe(l ledger.Ledger) lease {
+	raw, _ := l.Get("auto", "lease")
+	if raw != e.seen {
+		e.seen, e.seenAt = raw, e.now()
+	}
+	return parseLease(raw)
+}
+
+// valid returns true if the lease was renewed less than a ttl ago, as
+// observed by this node.
+func (e *elector) valid(current lease, now time.Time) bool {
+	return current.Holder != "" && now.Sub(e.seenAt) < e.ttl
+}
+
+func (e *elector) write(l ledger.Ledger, term, renewal uint64) (lease, error) {
+	now := e.now()
+	claim := lease{Holder: e.uuid, Term: term, Renewal: renewal, Expires: now.Add(e.ttl)}
+	dat, err := json.Marshal(claim)
+	if err != nil {
+		return claim, err
+	}
+	if err := l.Set("auto", "lease", string(dat)); err != nil {
+		return claim, err
+	}
+	e.seen, e.seenAt = string(dat), now
+	return claim, nil
+}
+
+// campaign runs a single election round against the given set of reachable
+// nodes. It returns the lease as known to this node at the end of the round
+// and whether this node is the leader for the rest of it.
+func (e *elector) campaign(l ledger.Ledger, nodes []string) (lease, bool, error) {
+	current := e.observe(l)
+	now := e.now()
+
+	switch {
+	case current.Holder == e.uuid && e.valid(current, now):
+		e.term = current.Term
+		// Renew only once half of the lease is gone, to avoid announcing on every round
+		if now.Sub(e.seenAt) >= e.ttl/2 {
+			renewed, err := e.write(l, e.term, current.Renewal+1)
+			if err != nil {
+				return current, false, err
+			}
+			current = renewed
+		}
+		return current, true, nil
+	case e.valid(current, now) && contains(nodes, current.Holder):
+		e.term = 0
+		return current, false, nil
+	default:
+		// The lease is vacant, expired, or held by a node which is gone. Claim
+		// the next term and back off until the claim propagated.
+		e.term = 0
+		claim, err := e.write(l, current.Term+1, 0)
+		return claim, false, err
+	}
+}
+
+// fence checks that the lease this node was elected with is still in place.
+func (e *elector) fence(l ledger.Ledger) error {
+	current := e.observe(l)
+	if e.term == 0 || current.Holder != e.uuid || current.Term != e.term || !e.valid(current, e.now()) {
+		return ErrFenced
+	}
+	return nil
+}
+
+// fencedLedger guards every write with the elector fencing check.
+type fencedLedger struct {
+	ledger.Ledger
+	elector *elector
+}
+
+func (f fencedLedger) Unwrap() ledger.Ledger {
+	return f.Ledger
+}
+
+func (f fencedLedger) Set(thing, uuid, value string) error {
+	if err := f.elector.fence(f.Ledger); err != nil {
+		return err
+	}
+	return f.Ledger.Set(thing, uuid, value)
+}
+
+func (f fencedLedger) Delete(thing, uuid string) error {
+	if err := f.elector.fence(f.Ledger); err != nil {
+		return err
+	}
+	return f.Ledger.Delete(thing, uuid)
+}
@@ -0,0 +1,181 @@
+package role
+
+import (
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leader election", func() {
+	var (
+		network   *ledger.MemoryNetwork
+		electors  map[string]*elector
+		nodes     = []string{"node-a", "node-b", "node-c"}
+		clock     time.Time
+		ttl       = 60 * time.Second
+		leadersOf = func(round func(uuid string) bool) []string {
+			var leaders []string
+			for _, uuid := range nodes {
+				if round(uuid) {
+					leaders = append(leaders, uuid)
+				}
+			}
+			return leaders
+		}
+	)
+
+	// tick runs one election round on every node which can see at least
+	// two peers, like role.Auto does with the default minimum_nodes.
+	tick := func() []string {
+		return leadersOf(func(uuid string) bool {
+			l := network.Node(uuid)
+			reachable, _ := l.AdvertizingNodes()
+			if len(reachable) < 2 {
+				return false
+			}
+			_, leader, err := electors[uuid].campaign(l, reachable)
+			Expect(err).ToNot(HaveOccurred())
+			return leader
+		})
+	}
+
+	BeforeEach(func() {
+		clock = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		network = ledger.NewMemoryNetwork(nodes...)
+		electors = map[string]*elector{}
+		for _, uuid := range nodes {
+			e := newElector(uuid, ttl)
+			e.now = func() time.Time { return clock }
+			electors[uuid] = e
+		}
+	})
+
+	It("elects a single leader once the claim settles", func() {
+		Expect(tick()).To(BeEmpty())
+		leaders := tick()
+		Expect(leaders).To(HaveLen(1))
+		Expect(readLease(network.Node(leaders[0])).Term).To(Equal(uint64(1)))
+	})
+
+	It("converges to a single leader after concurrent claims", func() {
+		// Every node claims in isolation, as if gossip did not deliver in time
+		network.Partition(nodes[:1], nodes[1:2], nodes[2:])
+		for _, uuid := range nodes {
+			_, leader, err := electors[uuid].campaign(network.Node(uuid), nodes)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leader).To(BeFalse())
+		}
+		network.Heal()
+
+		Expect(tick()).To(HaveLen(1))
+		Expect(tick()).To(HaveLen(1))
+	})
+
+	It("keeps the same leader while the lease is renewed", func() {
+		tick()
+		leaders := tick()
+		Expect(leaders).To(HaveLen(1))
+
+		for i := 0; i < 20; i++ {
+			clock = clock.Add(10 * time.Second)
+			Expect(tick()).To(Equal(leaders))
+		}
+		Expect(readLease(network.Node(leaders[0])).Term).To(Equal(uint64(1)))
+	})
+
+	It("elects a new leader with a higher term when the leader is partitioned away", func() {
+		tick()
+		old := tick()
+		Expect(old).To(HaveLen(1))
+
+		var rest []string
+		for _, uuid := range nodes {
+			if uuid != old[0] {
+				rest = append(rest, uuid)
+			}
+		}
+		network.Partition(old, rest)
+
+		// The majority doesn't see the leader anymore and takes over
+		Expect(tick()).To(BeEmpty())
+		leaders := tick()
+		Expect(leaders).To(HaveLen(1))
+		Expect(leaders[0]).ToNot(Equal(old[0]))
+		Expect(readLease(network.Node(leaders[0])).Term).To(Equal(uint64(2)))
+
+		// Once healed, the old leader can't write anymore and follows
+		network.Heal()
+		fenced := fencedLedger{Ledger: network.Node(old[0]), elector: electors[old[0]]}
+		Expect(fenced.Set("role", "node-x", "master")).To(MatchError(ErrFenced))
+		Expect(network.Node(old[0]).Get("role", "node-x")).To(BeEmpty())
+
+		Expect(tick()).To(Equal(leaders))
+	})
+
+	It("takes over once the lease expires", func() {
+		tick()
+		old := tick()
+		Expect(old).To(HaveLen(1))
+
+		// The leader stops renewing but is still listed as advertizing
+		delete(electors, old[0])
+		round := func() []string {
+			return leadersOf(func(uuid string) bool {
+				if uuid == old[0] {
+					return false
+				}
+				_, leader, err := electors[uuid].campaign(network.Node(uuid), nodes)
+				Expect(err).ToNot(HaveOccurred())
+				return leader
+			})
+		}
+
+		Expect(round()).To(BeEmpty())
+		clock = clock.Add(ttl + time.Second)
+		Expect(round()).To(BeEmpty())
+		leaders := round()
+		Expect(leaders).To(HaveLen(1))
+		Expect(readLease(network.Node(leaders[0])).Term).To(Equal(uint64(2)))
+	})
+
+	It("times the lease locally, regardless of the clock of the holder", func() {
+		// The clocks of the nodes are an hour apart
+		for i, uuid := range nodes {
+			skew := time.Duration(i-1) * time.Hour
+			electors[uuid].now = func() time.Time { return clock.Add(skew) }
+		}
+
+		tick()
+		old := tick()
+		Expect(old).To(HaveLen(1))
+		for i := 0; i < 20; i++ {
+			clock = clock.Add(10 * time.Second)
+			Expect(tick()).To(Equal(old))
+		}
+
+		delete(electors, old[0])
+		round := func() []string {
+			return leadersOf(func(uuid string) bool {
+				if uuid == old[0] {
+					return false
+				}
+				_, leader, err := electors[uuid].campaign(network.Node(uuid), nodes)
+				Expect(err).ToNot(HaveOccurred())
+				return leader
+			})
+		}
+		Expect(round()).To(BeEmpty())
+		clock = clock.Add(ttl / 2)
+		Expect(round()).To(BeEmpty())
+		clock = clock.Add(ttl / 2)
+		Expect(round()).To(BeEmpty())
+		Expect(round()).To(HaveLen(1))
+	})
+
+	It("fences writes of a node that never held the lease", func() {
+		fenced := fencedLedger{Ledger: network.Node("node-a"), elector: electors["node-a"]}
+		Expect(fenced.Delete("role", "node-b")).To(MatchError(ErrFenced))
+	})
+})
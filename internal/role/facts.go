@@ -0,0 +1,148 @@
+package role
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kairos-io/kairos-sdk/utils"
+
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+)
+
+// Facts describe the capabilities of a node. Every node advertises its own
+// facts to the ledger so that the leader can place roles accordingly.
+type Facts struct {
+	CPUs     int               `json:"cpus"`
+	Memory   uint64            `json:"memory"`
+	Arch     string            `json:"arch"`
+	Hostname string            `json:"hostname,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// ControlPlaneEligible is nil when the node didn't express a preference
+	ControlPlaneEligible *bool `json:"control_plane_eligible,omitempty"`
+
+	// Distro and Version are the Kubernetes distribution installed on the node
+	Distro  string `json:"distro,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Bootstrapped is true once the node finished deploying its role
+	Bootstrapped bool `json:"bootstrapped,omitempty"`
+}
+
+// IsControlPlaneEligible returns false only if the node opted out of the control plane.
+func (f Facts) IsControlPlaneEligible() bool {
+	return f.ControlPlaneEligible == nil || *f.ControlPlaneEligible
+}
+
+// LocalFacts collects the facts of the running node.
+func LocalFacts(pconfig *providerConfig.Config) Facts {
+	hostname, _ := os.Hostname()
+	f := Facts{
+		CPUs:     runtime.NumCPU(),
+		Memory:   memTotal("/proc/meminfo"),
+		Arch:     runtime.GOARCH,
+		Hostname: hostname,
+	}
+	f.Distro, f.Version = localDistro()
+	f.Bootstrapped = SentinelExist()
+	if pconfig.P2P != nil {
+		f.Labels = pconfig.P2P.Labels
+		f.ControlPlaneEligible = pconfig.P2P.ControlPlaneEligible
+	}
+	return f
+}
+
+// localDistro returns the distribution installed on the node and its
+// version. It doesn't change until the node is upgraded, see ResetLocalDistro.
+var localDistro = sync.OnceValues(detectDistro)
+
+// ResetLocalDistro detects the distribution and version of the node again,
+// after it was upgraded.
+func ResetLocalDistro() {
+	localDistro = sync.OnceValues(detectDistro)
+}
+
+func detectDistro() (string, string) {
+	var distro, bin string
+	var args []string
+	switch {
+	case UpgradedBin("k3s") != "":
+		distro, bin, args = "k3s", UpgradedBin("k3s"), []string{"--version"}
+	case UpgradedBin("k0s") != "":
+		distro, bin, args = "k0s", UpgradedBin("k0s"), []string{"version"}
+	case utils.K3sBin() != "":
+		distro, bin, args = "k3s", utils.K3sBin(), []string{"--version"}
+	case utils.K0sBin() != "":
+		distro, bin, args = "k0s", utils.K0sBin(), []string{"version"}
+	case RKE2Bin() != "":
+		distro, bin, args = "rke2", RKE2Bin(), []string{"--version"}
+	default:
+		return "", ""
+	}
+
+	out, _ := exec.Command(bin, args...).Output()
+	return distro, parseVersion(string(out))
+}
+
+// parseVersion returns the first version looking field of a version command output.
+func parseVersion(out string) string {
+	for _, field := range strings.Fields(out) {
+		if strings.HasPrefix(field, "v") && strings.Contains(field, ".") {
+			return field
+		}
+	}
+	return ""
+}
+
+// memTotal returns the total memory in bytes as reported by meminfo, or 0 if unknown.
+func memTotal(meminfo string) uint64 {
+	f, err := os.Open(meminfo)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
+
+// PublishFacts writes the node facts to the ledger, unless they are already there.
+func PublishFacts(l ledger.Ledger, uuid string, f Facts) error {
+	dat, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if current, _ := l.Get("facts", uuid); current == string(dat) {
+		return nil
+	}
+	return l.Set("facts", uuid, string(dat))
+}
+
+// GetFacts returns the facts advertised by a node, and whether there were any.
+func GetFacts(l ledger.Ledger, uuid string) (Facts, bool) {
+	var f Facts
+	raw, _ := l.Get("facts", uuid)
+	if raw == "" {
+		return f, false
+	}
+	if err := json.Unmarshal([]byte(raw), &f); err != nil {
+		return Facts{}, false
+	}
+	return f, true
+}
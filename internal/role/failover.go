@@ -0,0 +1,104 @@
+package role
+
+import (
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	"github.com/samber/lo"
+)
+
+// DefaultFailoverGracePeriod is how long the clusterinit master can be
+// unreachable before a master/ha node is promoted in its place.
+const DefaultFailoverGracePeriod = 5 * time.Minute
+
+// outageTimer times how long the master has been unreachable with the
+// local clock of the leader. The "unreachable" key of the ledger only marks
+// the outage, its timestamp was written with the clock of whichever leader
+// saw it first, so a new leader starts over the grace period.
+type outageTimer struct {
+	master string
+	since  time.Time
+}
+
+// observe returns when the master was first seen unreachable.
+func (o *outageTimer) observe(master string, now time.Time) time.Time {
+	if o.master != master || o.since.IsZero() {
+		o.master, o.since = master, now
+	}
+	return o.since
+}
+
+func (o *outageTimer) reset() {
+	o.master, o.since = "", time.Time{}
+}
+
+// failover promotes a master/ha node to master/clusterinit when the current
+// one stayed unreachable, that is not advertizing, for longer than the
+// grace period, as timed by this leader. The promoted node
+// takes over publishing the master data (ip, node token and kubeconfig),
+// and workers follow the new "master/ip".
+//
+// It returns true when the ledger was changed, in which case scheduling
+// should wait for the next round.
+func failover(l ledger.Ledger, c *service.RoleConfig, currentRoles map[string]string, advertizing []string, grace time.Duration, outage *outageTimer, now time.Time) (bool, error) {
+	// The master publishing data might not be advertizing anymore, so look it up directly
+	master, _ := l.Get("master", "uuid")
+	if master == "" {
+		for u, r := range currentRoles {
+			if r == "master/clusterinit" {
+				master = u
+			}
+		}
+	}
+	if master == "" {
+		return false, nil
+	}
+
+	marked, _ := l.Get("unreachable", master)
+	if lo.Contains(advertizing, master) {
+		outage.reset()
+		if marked != "" {
+			c.Logger.Infof("Master '%s' is reachable again", master)
+			return true, l.Delete("unreachable", master)
+		}
+		return false, nil
+	}
+
+	since := outage.observe(master, now)
+	if marked == "" {
+		c.Logger.Warnf("Master '%s' is unreachable, failing over in %s", master, grace-now.Sub(since))
+		return true, l.Set("unreachable", master, now.UTC().Format(time.RFC3339))
+	}
+	if now.Sub(since) < grace {
+		return false, nil
+	}
+
+	candidates := []string{}
+	for u, r := range currentRoles {
+		if r == "master/ha" && lo.Contains(advertizing, u) {
+			candidates = append(candidates, u)
+		}
+	}
+	candidates = rankControlPlane(l, candidates)
+	if len(candidates) == 0 {
+		c.Logger.Warnf("Master '%s' unreachable since %s, but no master/ha node is available to take over", master, since.Format(time.RFC3339))
+		return false, nil
+	}
+
+	promoted := candidates[0]
+	c.Logger.Infof("Master '%s' unreachable since %s, promoting '%s'", master, since.Format(time.RFC3339), promoted)
+
+	// Demote first, so that the old master rejoins as a regular control plane node if it comes back
+	if err := l.Set("role", master, "master/ha"); err != nil {
+		return true, err
+	}
+	if err := l.Set("master", "uuid", promoted); err != nil {
+		return true, err
+	}
+	if err := l.Set("role", promoted, "master/clusterinit"); err != nil {
+		return true, err
+	}
+	outage.reset()
+	return true, l.Delete("unreachable", master)
+}
@@ -0,0 +1,132 @@
+package role
+
+import (
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Control plane failover", func() {
+	var (
+		l           ledger.Ledger
+		c           *service.RoleConfig
+		roles       map[string]string
+		outage      *outageTimer
+		now         time.Time
+		grace       = time.Minute
+		advertizing = []string{"ha-1", "ha-2", "worker"}
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		l = ledger.NewMemoryNetwork("leader").Node("leader")
+		c = &service.RoleConfig{UUID: "leader", Logger: logging.Logger("role-test")}
+		now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		outage = &outageTimer{}
+		roles = map[string]string{
+			"init":   "master/clusterinit",
+			"ha-1":   "master/ha",
+			"ha-2":   "master/ha",
+			"worker": "worker",
+		}
+		for u, r := range roles {
+			Expect(l.Set("role", u, r)).To(Succeed())
+		}
+		Expect(l.Set("master", "uuid", "init")).To(Succeed())
+		Expect(PublishFacts(l, "ha-1", Facts{CPUs: 2})).To(Succeed())
+		Expect(PublishFacts(l, "ha-2", Facts{CPUs: 8})).To(Succeed())
+	})
+
+	It("does nothing while the master is reachable", func() {
+		changed, err := failover(l, c, roles, append(advertizing, "init"), grace, outage, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+	})
+
+	It("promotes the best master/ha node after the grace period", func() {
+		changed, err := failover(l, c, roles, advertizing, grace, outage, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(l.Get("unreachable", "init")).ToNot(BeEmpty())
+
+		changed, err = failover(l, c, roles, advertizing, grace, outage, now.Add(30*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(l.Get("role", "init")).To(Equal("master/clusterinit"))
+
+		changed, err = failover(l, c, roles, advertizing, grace, outage, now.Add(2*time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(l.Get("role", "ha-2")).To(Equal("master/clusterinit"))
+		Expect(l.Get("role", "init")).To(Equal("master/ha"))
+		Expect(l.Get("master", "uuid")).To(Equal("ha-2"))
+		Expect(l.Get("unreachable", "init")).To(BeEmpty())
+	})
+
+	It("forgets about the outage when the master comes back", func() {
+		_, err := failover(l, c, roles, advertizing, grace, outage, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		changed, err := failover(l, c, roles, append(advertizing, "init"), grace, outage, now.Add(2*time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(l.Get("unreachable", "init")).To(BeEmpty())
+		Expect(l.Get("role", "init")).To(Equal("master/clusterinit"))
+	})
+
+	It("fails over from scheduleRoles once the master stops advertizing", func() {
+		masters := 2
+		pconfig := &providerConfig.Config{P2P: &providerConfig.P2P{Auto: providerConfig.Auto{
+			HA: providerConfig.HA{MasterNodes: &masters, Failover: true},
+		}}}
+		network := ledger.NewMemoryNetwork("leader", "init", "ha-1", "ha-2", "worker")
+		l := network.Node("leader")
+		for u, r := range roles {
+			Expect(l.Set("role", u, r)).To(Succeed())
+		}
+		Expect(l.Set("master", "uuid", "init")).To(Succeed())
+		Expect(PublishFacts(l, "ha-1", Facts{CPUs: 2})).To(Succeed())
+		Expect(PublishFacts(l, "ha-2", Facts{CPUs: 8})).To(Succeed())
+
+		network.Remove("init")
+		network.Expire("init")
+		Expect(l.Set("unreachable", "init", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))).To(Succeed())
+		outage := &outageTimer{master: "init", since: time.Now().Add(-time.Hour)}
+
+		nodes, _ := l.AdvertizingNodes()
+		Expect(scheduleRoles(nodes, c, l, &sdkConfig.Config{}, pconfig, outage)).To(Succeed())
+		Expect(l.Get("master", "uuid")).To(Equal("ha-2"))
+		Expect(l.Get("role", "ha-2")).To(Equal("master/clusterinit"))
+	})
+
+	It("times the outage with its own clock after a leader change", func() {
+		// The previous leader marked the master unreachable long ago, by its clock
+		Expect(l.Set("unreachable", "init", now.Add(-time.Hour).UTC().Format(time.RFC3339))).To(Succeed())
+
+		changed, err := failover(l, c, roles, advertizing, grace, outage, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(l.Get("role", "init")).To(Equal("master/clusterinit"))
+
+		changed, err = failover(l, c, roles, advertizing, grace, outage, now.Add(2*time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(l.Get("master", "uuid")).To(Equal("ha-2"))
+	})
+
+	It("waits when no master/ha node is reachable", func() {
+		_, err := failover(l, c, roles, []string{"worker"}, grace, outage, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		changed, err := failover(l, c, roles, []string{"worker"}, grace, outage, now.Add(2*time.Minute))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(l.Get("master", "uuid")).To(Equal("init"))
+	})
+})
@@ -0,0 +1,156 @@
+package role
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+)
+
+const rke2Kubectl = "/var/lib/rancher/rke2/bin/kubectl"
+
+// RKE2Bin returns the path of the rke2 binary, or an empty string if it is not installed.
+func RKE2Bin() string {
+	for _, p := range []string{"/usr/bin/rke2", "/usr/local/bin/rke2", "/opt/rke2/bin/rke2"} {
+		if fileExists(p) {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// Kubectl runs kubectl commands against the cluster of the network.
+type Kubectl struct {
+	// Distro is the distribution the cluster runs: k3s, k0s or rke2
+	Distro string
+	Run    func(args ...string) (string, error)
+}
+
+// LedgerKubectl returns a Kubectl using the kubeconfig published under
+// kubeconfig/master and the kubectl shipped with the local distribution.
+func LedgerKubectl(l ledger.Ledger) (*Kubectl, error) {
+	raw, _ := l.Get("kubeconfig", "master")
+	if raw == "" {
+		return nil, errors.New("no kubeconfig was published by the master yet")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("decoding kubeconfig: %w", err)
+	}
+	masterIP, _ := l.Get("master", "ip")
+	kubeconfig := []byte(strings.ReplaceAll(string(b), "127.0.0.1", masterIP))
+
+	// k3s and k0s embed kubectl, RKE2 ships it alongside its data
+	var distro, bin string
+	var prefix []string
+	switch {
+	case utils.K3sBin() != "":
+		distro, bin, prefix = "k3s", utils.K3sBin(), []string{"kubectl"}
+	case utils.K0sBin() != "":
+		distro, bin, prefix = "k0s", utils.K0sBin(), []string{"kubectl"}
+	case fileExists(rke2Kubectl):
+		distro, bin = "rke2", rke2Kubectl
+	default:
+		return nil, errors.New("no k8s binary is available to run kubectl")
+	}
+
+	return &Kubectl{
+		Distro: distro,
+		Run: func(args ...string) (string, error) {
+			// The kubeconfig is cluster-admin, it only lives on disk while kubectl runs
+			path, err := writeKubeconfig(kubeconfig)
+			if err != nil {
+				return "", err
+			}
+			defer os.Remove(path)
+
+			argv := append(append(append([]string{}, prefix...), "--kubeconfig", path), args...)
+			out, err := exec.Command(bin, argv...).CombinedOutput()
+			if err != nil {
+				return string(out), fmt.Errorf("kubectl %s: %w: %s", strings.Join(args, " "), err, out)
+			}
+			return string(out), nil
+		},
+	}, nil
+}
+
+// writeKubeconfig writes a kubeconfig to a new file only readable by the
+// current user, and returns its path.
+func writeKubeconfig(kubeconfig []byte) (string, error) {
+	f, err := os.CreateTemp("", "kairos-kubeconfig-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(kubeconfig); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// NodeName returns the name of the Kubernetes node with the given internal IP,
+// or an empty string if there is none.
+func (k *Kubectl) NodeName(ip string) (string, error) {
+	out, err := k.Run("get", "nodes", "-o",
+		`jsonpath={range .items[*]}{.metadata.name}{"\t"}{.status.addresses[?(@.type=="InternalIP")].address}{"\n"}{end}`)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == ip {
+			return fields[0], nil
+		}
+	}
+	return "", nil
+}
+
+// NodeReadiness returns whether the Kubernetes nodes are Ready, by internal IP.
+func (k *Kubectl) NodeReadiness() (map[string]bool, error) {
+	out, err := k.Run("get", "nodes", "-o",
+		`jsonpath={range .items[*]}{.status.addresses[?(@.type=="InternalIP")].address}{"\t"}{.status.conditions[?(@.type=="Ready")].status}{"\n"}{end}`)
+	if err != nil {
+		return nil, err
+	}
+	ready := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			ready[fields[0]] = fields[1] == "True"
+		}
+	}
+	return ready, nil
+}
+
+// NodeVersions returns the kubelet version of the Kubernetes nodes, by internal IP.
+func (k *Kubectl) NodeVersions() (map[string]string, error) {
+	out, err := k.Run("get", "nodes", "-o",
+		`jsonpath={range .items[*]}{.status.addresses[?(@.type=="InternalIP")].address}{"\t"}{.status.nodeInfo.kubeletVersion}{"\n"}{end}`)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	return versions, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,147 @@
+// Package ledger abstracts the edgevpn ledger used to coordinate nodes,
+// so that role scheduling can run against other backends.
+package ledger
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	service "github.com/mudler/edgevpn/api/client/service"
+)
+
+// Ledger is the shared key/value store nodes coordinate through.
+// Keys are addressed by a kind and a node UUID, e.g. ("role", uuid).
+type Ledger interface {
+	// Get returns the value stored for the given kind and UUID. A missing
+	// key returns an empty string.
+	Get(args ...string) (string, error)
+	Set(thing, uuid, value string) error
+	// Delete clears a key. Readers treat an empty value as unset.
+	Delete(thing, uuid string) error
+	// List returns the UUIDs which have a value set for the given kind,
+	// e.g. the nodes with a role, whether they are advertizing or not.
+	List(thing string) ([]string, error)
+	// AdvertizingNodes returns the nodes that announced themselves recently.
+	AdvertizingNodes() ([]string, error)
+	// ActiveNodes returns the peer IDs of the nodes that passed the network
+	// healthchecks recently. These are not node UUIDs, nodes are looked up
+	// with AdvertizingNodes, and told apart from their peer with Peers.
+	ActiveNodes() ([]string, error)
+	// Peers returns the machines of the network, by peer ID.
+	Peers() (map[string]Peer, error)
+}
+
+// Peer is a machine of the network, as announced by its edgevpn node.
+type Peer struct {
+	// Address is the VPN address of the machine
+	Address  string
+	Hostname string
+}
+
+type edgeVPN struct {
+	*service.Client
+	serviceID string
+}
+
+// NewEdgeVPN returns a Ledger backed by the edgevpn API, for the service
+// the client was created with.
+func NewEdgeVPN(c *service.Client, serviceID string) Ledger {
+	return edgeVPN{Client: c, serviceID: serviceID}
+}
+
+// Get returns an empty string for missing keys. The edgevpn API answers
+// them with an empty payload the client fails to decode, so whether the key
+// exists is only checked when the read fails.
+func (e edgeVPN) Get(args ...string) (string, error) {
+	// The client reverses the arguments in place
+	v, err := e.Client.Get(slices.Clone(args)...)
+	if err == nil {
+		return v, nil
+	}
+	keys, kerr := e.Client.Client.GetBucketKeys(e.serviceID)
+	if kerr != nil {
+		return "", err
+	}
+	key := slices.Clone(args)
+	slices.Reverse(key)
+	if !slices.Contains(keys, strings.Join(key, "-")) {
+		return "", nil
+	}
+	return "", err
+}
+
+// Peers returns the machines announced on the network.
+func (e edgeVPN) Peers() (map[string]Peer, error) {
+	machines, err := e.Client.Client.Machines()
+	if err != nil {
+		return nil, err
+	}
+	peers := map[string]Peer{}
+	for _, m := range machines {
+		address, _, _ := strings.Cut(m.Address, "/")
+		peers[m.PeerID] = Peer{Address: address, Hostname: m.Hostname}
+	}
+	return peers, nil
+}
+
+// Delete overwrites the key with an empty value. Other nodes only learn
+// about a key through its latest value, so a cleared key has to be written.
+func (e edgeVPN) Delete(thing, uuid string) error {
+	return e.Client.Set(thing, uuid, "")
+}
+
+// List scans the keys of the service bucket, which are stored as
+// <uuid>-<kind>. A kind ending with another one, like upgrade-status and
+// status, can't be told apart, so only list kinds which don't.
+func (e edgeVPN) List(thing string) ([]string, error) {
+	keys, err := e.Client.Client.GetBucketKeys(e.serviceID)
+	if err != nil {
+		return nil, err
+	}
+	uuids := []string{}
+	for _, k := range keys {
+		uuid, ok := strings.CutSuffix(k, "-"+thing)
+		if !ok || uuid == "" {
+			continue
+		}
+		if v, _ := e.Get(thing, uuid); v != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids, nil
+}
+
+// Lookup returns the value stored for the given kind and UUID, or an empty
+// string if it is not set. Backends which fail reading missing keys are
+// told apart from values which don't read, like the ones which are not
+// sealed, by listing the keys set.
+func Lookup(l Ledger, thing, uuid string) (string, error) {
+	v, err := l.Get(thing, uuid)
+	if err == nil {
+		return v, nil
+	}
+	set, lerr := l.List(thing)
+	if lerr == nil && !slices.Contains(set, uuid) {
+		return "", nil
+	}
+	return "", err
+}
+
+// As finds the first ledger in the chain of wrapped ledgers implementing
+// T, like errors.As. Wrappers expose the ledger they wrap with Unwrap.
+func As[T any](l Ledger) (T, bool) {
+	for l != nil {
+		if t, ok := l.(T); ok {
+			return t, true
+		}
+		u, ok := l.(interface{ Unwrap() Ledger })
+		if !ok {
+			break
+		}
+		l = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
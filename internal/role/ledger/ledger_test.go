@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	client "github.com/mudler/edgevpn/api/client"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// edgeVPNAPI serves the ledger endpoints of the edgevpn API for the given
+// keys of a service bucket, encoded the way the API stores them.
+func edgeVPNAPI(bucket map[string]string) *httptest.Server {
+	encode := func(v string) string {
+		dat, _ := json.Marshal(v)
+		stored, _ := json.Marshal(base64.URLEncoding.EncodeToString(dat))
+		return string(stored)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/ledger/"), "/")
+		switch {
+		case len(path) == 1:
+			data := map[string]string{}
+			for k, v := range bucket {
+				data[k] = encode(v)
+			}
+			json.NewEncoder(w).Encode(data) //nolint:errcheck
+		case len(path) == 2:
+			v, ok := bucket[path[1]]
+			if !ok {
+				// The API answers missing keys with the empty value of the ledger data
+				w.Write([]byte(`""`)) //nolint:errcheck
+				return
+			}
+			json.NewEncoder(w).Encode(encode(v)) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+var _ = Describe("EdgeVPN ledger", func() {
+	It("returns an empty string for missing keys", func() {
+		api := edgeVPNAPI(map[string]string{"node-role": "worker"})
+		DeferCleanup(api.Close)
+		l := NewEdgeVPN(service.NewClient("svc", client.NewClient(client.WithHost(api.URL))), "svc")
+
+		Expect(l.Get("role", "node")).To(Equal("worker"))
+		v, err := l.Get("role", "other")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(BeEmpty())
+	})
+
+	It("doesn't reverse the arguments of the caller", func() {
+		api := edgeVPNAPI(map[string]string{"node-role": "worker"})
+		DeferCleanup(api.Close)
+		l := NewEdgeVPN(service.NewClient("svc", client.NewClient(client.WithHost(api.URL))), "svc")
+
+		args := []string{"role", "node"}
+		Expect(l.Get(args...)).To(Equal("worker"))
+		Expect(args).To(Equal([]string{"role", "node"}))
+	})
+
+	It("returns the machines of the network by peer ID", func() {
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"PeerID":"12D3Koo","Hostname":"node-a","Address":"10.1.0.1/24"}]`)) //nolint:errcheck
+		}))
+		DeferCleanup(api.Close)
+		l := NewEdgeVPN(service.NewClient("svc", client.NewClient(client.WithHost(api.URL))), "svc")
+
+		Expect(l.Peers()).To(Equal(map[string]Peer{"12D3Koo": {Address: "10.1.0.1", Hostname: "node-a"}}))
+	})
+})
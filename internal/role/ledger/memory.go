@@ -0,0 +1,215 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+)
+
+type memoryEntry struct {
+	value string
+	seq   int
+}
+
+// MemoryNetwork is an in-memory ledger shared by a set of simulated nodes.
+//
+// Nodes can be split into partitions, each with its own copy of the data,
+// and merged back with last-writer-wins semantics like the edgevpn ledger.
+// It is meant to exercise role scheduling without a running edgevpn daemon.
+type MemoryNetwork struct {
+	sync.Mutex
+	seq        int
+	partitions map[string]map[string]memoryEntry
+	members    map[string][]string
+	// removed nodes are still advertized until their announce expires
+	removed []string
+	peers   map[string]Peer
+}
+
+// NewMemoryNetwork returns a network where all the given nodes can reach each other.
+func NewMemoryNetwork(nodes ...string) *MemoryNetwork {
+	n := &MemoryNetwork{}
+	n.Partition(nodes)
+	return n
+}
+
+// Partition splits the network into the given groups. Each group starts
+// from the merged view of the whole network. Nodes which are not part of
+// the current network join it this way too.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.Lock()
+	defer n.Unlock()
+
+	merged := n.merged()
+	n.partitions = map[string]map[string]memoryEntry{}
+	n.members = map[string][]string{}
+	for _, g := range groups {
+		group := append([]string{}, g...)
+		data := map[string]memoryEntry{}
+		for k, v := range merged {
+			data[k] = v
+		}
+		for _, uuid := range group {
+			n.partitions[uuid] = data
+			n.members[uuid] = group
+		}
+	}
+	n.removed = lo.Filter(n.removed, func(uuid string, _ int) bool {
+		_, ok := n.members[uuid]
+		return !ok
+	})
+}
+
+// Heal merges back all the partitions.
+func (n *MemoryNetwork) Heal() {
+	n.Lock()
+	all := make([]string, 0, len(n.members))
+	for uuid := range n.members {
+		all = append(all, uuid)
+	}
+	n.Unlock()
+
+	sort.Strings(all)
+	n.Partition(all)
+}
+
+// Remove drops a node from the network, as if it was powered off. The
+// node stops being active, but is still advertizing until Expire is called.
+func (n *MemoryNetwork) Remove(uuid string) {
+	n.Lock()
+	defer n.Unlock()
+
+	members := []string{}
+	for _, m := range n.members[uuid] {
+		if m != uuid {
+			members = append(members, m)
+		}
+	}
+	for _, m := range members {
+		n.members[m] = members
+	}
+	delete(n.members, uuid)
+	delete(n.partitions, uuid)
+	n.removed = append(n.removed, uuid)
+}
+
+// Expire drops the advertisement of a removed node.
+func (n *MemoryNetwork) Expire(uuid string) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.removed = lo.Without(n.removed, uuid)
+}
+
+// SetPeer sets the machine announced by a node, which defaults to the
+// address it published under "ip" and its UUID as hostname.
+func (n *MemoryNetwork) SetPeer(uuid string, p Peer) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.peers == nil {
+		n.peers = map[string]Peer{}
+	}
+	n.peers[uuid] = p
+}
+
+func (n *MemoryNetwork) merged() map[string]memoryEntry {
+	merged := map[string]memoryEntry{}
+	for _, data := range n.partitions {
+		for k, v := range data {
+			if v.seq > merged[k].seq {
+				merged[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+// Node returns the ledger as seen by the given node.
+func (n *MemoryNetwork) Node(uuid string) Ledger {
+	return memoryView{network: n, uuid: uuid}
+}
+
+type memoryView struct {
+	network *MemoryNetwork
+	uuid    string
+}
+
+func (m memoryView) Get(args ...string) (string, error) {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	return m.network.partitions[m.uuid][strings.Join(args, "/")].value, nil
+}
+
+func (m memoryView) Set(thing, uuid, value string) error {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	data, ok := m.network.partitions[m.uuid]
+	if !ok {
+		// The node is disconnected, writes are lost
+		return nil
+	}
+	m.network.seq++
+	data[thing+"/"+uuid] = memoryEntry{value: value, seq: m.network.seq}
+	return nil
+}
+
+func (m memoryView) Delete(thing, uuid string) error {
+	return m.Set(thing, uuid, "")
+}
+
+func (m memoryView) List(thing string) ([]string, error) {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	uuids := []string{}
+	for k, v := range m.network.partitions[m.uuid] {
+		if uuid, ok := strings.CutPrefix(k, thing+"/"); ok && v.value != "" {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	return uuids, nil
+}
+
+func (m memoryView) AdvertizingNodes() ([]string, error) {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	if _, ok := m.network.members[m.uuid]; !ok {
+		return []string{}, nil
+	}
+	return append(append([]string{}, m.network.members[m.uuid]...), m.network.removed...), nil
+}
+
+func (m memoryView) ActiveNodes() ([]string, error) {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	return lo.Map(m.network.members[m.uuid], func(uuid string, _ int) string { return PeerID(uuid) }), nil
+}
+
+// Peers returns the machines of the nodes reachable from this one.
+func (m memoryView) Peers() (map[string]Peer, error) {
+	m.network.Lock()
+	defer m.network.Unlock()
+
+	peers := map[string]Peer{}
+	for _, uuid := range m.network.members[m.uuid] {
+		p, ok := m.network.peers[uuid]
+		if !ok {
+			p = Peer{Address: m.network.partitions[m.uuid]["ip/"+uuid].value, Hostname: uuid}
+		}
+		peers[PeerID(uuid)] = p
+	}
+	return peers, nil
+}
+
+// PeerID is the peer ID of a node of a MemoryNetwork, as returned by ActiveNodes.
+func PeerID(uuid string) string {
+	return "peer-" + uuid
+}
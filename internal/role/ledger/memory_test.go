@@ -0,0 +1,29 @@
+package ledger
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory ledger", func() {
+	It("lists the nodes with a key set, advertizing or not", func() {
+		network := NewMemoryNetwork("a", "b")
+		l := network.Node("a")
+		Expect(l.Set("role", "b", "worker")).To(Succeed())
+		Expect(l.Set("role", "a", "master")).To(Succeed())
+		Expect(l.Set("role", "c", "worker")).To(Succeed())
+		Expect(l.Set("role-signature", "d", "sig")).To(Succeed())
+		Expect(l.Delete("role", "c")).To(Succeed())
+
+		network.Remove("b")
+		network.Expire("b")
+		Expect(l.List("role")).To(Equal([]string{"a", "b"}))
+		Expect(l.List("remove")).To(BeEmpty())
+	})
+
+	It("reports active nodes by peer ID, like the healthchecks", func() {
+		l := NewMemoryNetwork("a", "b").Node("a")
+		Expect(l.ActiveNodes()).To(Equal([]string{PeerID("a"), PeerID("b")}))
+		Expect(l.AdvertizingNodes()).To(Equal([]string{"a", "b"}))
+	})
+})
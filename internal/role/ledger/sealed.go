@@ -0,0 +1,246 @@
+package ledger
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks the values sealed by a Keyring.
+const sealedPrefix = "sealed:v1:"
+
+// Secrets are the keys holding cluster credentials, which are sealed at rest.
+var Secrets = [][2]string{
+	{"nodetoken", "token"},
+	{"controllertoken", "token"},
+	{"kubeconfig", "master"},
+}
+
+// SecretKinds are the kinds whose keys all hold credentials, like the
+// per-node join tokens. They are short-lived, so they are not resealed.
+var SecretKinds = []string{"jointoken"}
+
+// AuthenticatedKinds are the kinds only the holders of the cluster secret
+// may write, like the admission decisions, the identities pinned by the
+// leader, the removal requests and the upgrade requests and plans. They are
+// sealed like the secrets, and values which are not are refused on reads.
+var AuthenticatedKinds = []string{"admission", "pinned", "remove", "upgrade"}
+
+// ErrSealed is returned when a value is sealed with a key the node doesn't have.
+var ErrSealed = errors.New("value is sealed with an unknown cluster secret")
+
+type sealingKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring seals values with a key derived from the network token and the
+// cluster secret. Keys derived from previous cluster secrets can still open
+// the values sealed before a rotation.
+type Keyring struct {
+	current  sealingKey
+	previous []sealingKey
+}
+
+// NewKeyring derives the sealing keys for the given network token and cluster secrets.
+func NewKeyring(networkToken, clusterSecret string, previousSecrets ...string) (*Keyring, error) {
+	current, err := deriveKey(networkToken, clusterSecret)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{current: current}
+	for _, s := range previousSecrets {
+		previous, err := deriveKey(networkToken, s)
+		if err != nil {
+			return nil, err
+		}
+		k.previous = append(k.previous, previous)
+	}
+	return k, nil
+}
+
+func deriveKey(networkToken, clusterSecret string) (sealingKey, error) {
+	if clusterSecret == "" {
+		return sealingKey{}, errors.New("empty cluster secret")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(clusterSecret), []byte(networkToken), "provider-kairos ledger secrets", 32)
+	if err != nil {
+		return sealingKey{}, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return sealingKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return sealingKey{}, err
+	}
+	// The id only tells keys apart, it doesn't need to be secret
+	id := sha256.Sum256(key)
+	return sealingKey{id: hex.EncodeToString(id[:4]), aead: aead}, nil
+}
+
+// Seal encrypts a value stored under the given key with the current cluster secret.
+func (k *Keyring) Seal(thing, uuid, value string) (string, error) {
+	nonce := make([]byte, k.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The key is authenticated too, so that sealed values can't be swapped around
+	sealed := k.current.aead.Seal(nonce, nonce, []byte(value), []byte(thing+"/"+uuid))
+	return sealedPrefix + k.current.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed value. Values which are not sealed are returned as is.
+func (k *Keyring) Open(thing, uuid, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, payload, _ := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	for _, key := range append([]sealingKey{k.current}, k.previous...) {
+		if key.id != id {
+			continue
+		}
+		sealed, err := base64.RawURLEncoding.DecodeString(payload)
+		if err != nil || len(sealed) < key.aead.NonceSize() {
+			return "", fmt.Errorf("malformed sealed value for %s/%s", thing, uuid)
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(thing+"/"+uuid))
+		if err != nil {
+			return "", fmt.Errorf("opening %s/%s: %w", thing, uuid, err)
+		}
+		return string(plain), nil
+	}
+	return "", ErrSealed
+}
+
+// sealedWithCurrent returns true if the value is sealed with the current cluster secret.
+func (k *Keyring) sealedWithCurrent(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix+k.current.id+":")
+}
+
+// sealedWithPrevious returns true if the value is sealed with a previous cluster secret.
+func (k *Keyring) sealedWithPrevious(value string) bool {
+	for _, key := range k.previous {
+		if strings.HasPrefix(value, sealedPrefix+key.id+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSealed returns true if the value was sealed by a Keyring.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func isAuthenticated(thing string) bool {
+	for _, kind := range AuthenticatedKinds {
+		if kind == thing {
+			return true
+		}
+	}
+	return false
+}
+
+func isSecret(thing, uuid string) bool {
+	for _, kind := range SecretKinds {
+		if kind == thing {
+			return true
+		}
+	}
+	for _, s := range Secrets {
+		if s[0] == thing && s[1] == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Resealer is implemented by ledgers which seal secrets.
+type Resealer interface {
+	// Stale returns the secrets which are stored in plain text or sealed
+	// with a previous cluster secret, and the values of the authenticated
+	// kinds sealed with a previous cluster secret.
+	Stale() [][2]string
+}
+
+type sealed struct {
+	Ledger
+	keys *Keyring
+}
+
+// NewSealed returns a Ledger sealing the Secrets and AuthenticatedKinds it
+// stores, and opening them transparently on reads.
+func NewSealed(l Ledger, keys *Keyring) Ledger {
+	return sealed{Ledger: l, keys: keys}
+}
+
+func (s sealed) Unwrap() Ledger {
+	return s.Ledger
+}
+
+func (s sealed) Get(args ...string) (string, error) {
+	v, err := s.Ledger.Get(args...)
+	if err != nil || len(args) != 2 {
+		return v, err
+	}
+	if isAuthenticated(args[0]) {
+		if v != "" && !IsSealed(v) {
+			return "", fmt.Errorf("%s/%s is not sealed with the cluster secret", args[0], args[1])
+		}
+		return s.keys.Open(args[0], args[1], v)
+	}
+	if !isSecret(args[0], args[1]) {
+		return v, nil
+	}
+	return s.keys.Open(args[0], args[1], v)
+}
+
+func (s sealed) Set(thing, uuid, value string) error {
+	if value != "" && (isSecret(thing, uuid) || isAuthenticated(thing)) {
+		v, err := s.keys.Seal(thing, uuid, value)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	return s.Ledger.Set(thing, uuid, value)
+}
+
+// IsSealing returns true if the ledger seals the secrets and the
+// authenticated kinds.
+func IsSealing(l Ledger) bool {
+	_, ok := As[Resealer](l)
+	return ok
+}
+
+func (s sealed) Stale() [][2]string {
+	stale := [][2]string{}
+	for _, secret := range Secrets {
+		v, _ := s.Ledger.Get(secret[0], secret[1])
+		if v != "" && !s.keys.sealedWithCurrent(v) {
+			stale = append(stale, secret)
+		}
+	}
+	// Values of the authenticated kinds in plain text were not written by
+	// the holders of the cluster secret, they must not be sealed
+	for _, kind := range AuthenticatedKinds {
+		keys, _ := s.Ledger.List(kind)
+		for _, uuid := range keys {
+			v, _ := s.Ledger.Get(kind, uuid)
+			if s.keys.sealedWithPrevious(v) {
+				stale = append(stale, [2]string{kind, uuid})
+			}
+		}
+	}
+	return stale
+}
@@ -0,0 +1,133 @@
+package ledger
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sealed ledger", func() {
+	var (
+		raw  Ledger
+		keys *Keyring
+		l    Ledger
+	)
+
+	BeforeEach(func() {
+		var err error
+		raw = NewMemoryNetwork("node").Node("node")
+		keys, err = NewKeyring("network-token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = NewSealed(raw, keys)
+	})
+
+	It("seals secrets and opens them on reads", func() {
+		Expect(l.Set("nodetoken", "token", "K10abc::server:xyz")).To(Succeed())
+
+		stored, _ := raw.Get("nodetoken", "token")
+		Expect(IsSealed(stored)).To(BeTrue())
+		Expect(stored).ToNot(ContainSubstring("K10abc"))
+
+		Expect(l.Get("nodetoken", "token")).To(Equal("K10abc::server:xyz"))
+	})
+
+	It("refuses the authenticated values written without the cluster secret", func() {
+		Expect(l.Set("admission", "node", "approved")).To(Succeed())
+		stored, _ := raw.Get("admission", "node")
+		Expect(IsSealed(stored)).To(BeTrue())
+		Expect(l.Get("admission", "node")).To(Equal("approved"))
+
+		Expect(raw.Set("admission", "node", "approved")).To(Succeed())
+		v, err := l.Get("admission", "node")
+		Expect(err).To(HaveOccurred())
+		Expect(v).To(BeEmpty())
+
+		Expect(IsSealing(l)).To(BeTrue())
+		Expect(IsSealing(raw)).To(BeFalse())
+	})
+
+	It("leaves other keys in plain text", func() {
+		Expect(l.Set("master", "ip", "10.1.0.1")).To(Succeed())
+		Expect(raw.Get("master", "ip")).To(Equal("10.1.0.1"))
+		Expect(l.Get("master", "ip")).To(Equal("10.1.0.1"))
+	})
+
+	It("doesn't open values moved to another key", func() {
+		Expect(l.Set("nodetoken", "token", "node")).To(Succeed())
+		stored, _ := raw.Get("nodetoken", "token")
+		Expect(raw.Set("controllertoken", "token", stored)).To(Succeed())
+
+		_, err := l.Get("controllertoken", "token")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires the same network token and cluster secret", func() {
+		Expect(l.Set("kubeconfig", "master", "config")).To(Succeed())
+
+		other, err := NewKeyring("another-network", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewSealed(raw, other).Get("kubeconfig", "master")
+		Expect(err).To(MatchError(ErrSealed))
+
+		other, err = NewKeyring("network-token", "another-secret")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = NewSealed(raw, other).Get("kubeconfig", "master")
+		Expect(err).To(MatchError(ErrSealed))
+	})
+
+	It("opens values sealed before a rotation and reports them as stale", func() {
+		Expect(l.Set("kubeconfig", "master", "config")).To(Succeed())
+		Expect(raw.Set("nodetoken", "token", "plain")).To(Succeed())
+		Expect(l.Set("controllertoken", "token", "controller")).To(Succeed())
+
+		rotated, err := NewKeyring("network-token", "new-secret", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = NewSealed(raw, rotated)
+		Expect(l.Set("controllertoken", "token", "controller")).To(Succeed())
+
+		Expect(l.Get("kubeconfig", "master")).To(Equal("config"))
+		Expect(l.Get("nodetoken", "token")).To(Equal("plain"))
+		Expect(l.(Resealer).Stale()).To(ConsistOf(
+			[2]string{"kubeconfig", "master"},
+			[2]string{"nodetoken", "token"},
+		))
+
+		Expect(l.Set("kubeconfig", "master", "config")).To(Succeed())
+		Expect(l.Set("nodetoken", "token", "plain")).To(Succeed())
+		Expect(l.(Resealer).Stale()).To(BeEmpty())
+	})
+
+	It("reports the authenticated values sealed before a rotation as stale", func() {
+		Expect(l.Set("admission", "node", "approved")).To(Succeed())
+		Expect(raw.Set("admission", "forged", "approved")).To(Succeed())
+
+		rotated, err := NewKeyring("network-token", "new-secret", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = NewSealed(raw, rotated)
+		Expect(l.Set("pinned", "node", "key")).To(Succeed())
+
+		Expect(l.(Resealer).Stale()).To(Equal([][2]string{{"admission", "node"}}))
+	})
+
+	It("rejects malformed sealed values", func() {
+		Expect(l.Set("kubeconfig", "master", "config")).To(Succeed())
+		stored, _ := raw.Get("kubeconfig", "master")
+		Expect(raw.Set("kubeconfig", "master", strings.TrimSuffix(stored, stored[len(stored)-4:]))).To(Succeed())
+
+		_, err := l.Get("kubeconfig", "master")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("seals the join tokens of every node", func() {
+		Expect(l.Set("jointoken", "worker", "K10abc::worker:xyz")).To(Succeed())
+		stored, _ := raw.Get("jointoken", "worker")
+		Expect(IsSealed(stored)).To(BeTrue())
+		Expect(l.Get("jointoken", "worker")).To(Equal("K10abc::worker:xyz"))
+	})
+
+	It("refuses an empty cluster secret", func() {
+		_, err := NewKeyring("network-token", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,140 @@
+package ledger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/identity"
+)
+
+// SignedKinds are the kinds a node claims for itself, which are signed
+// with the identity of the writer. Values are stored as they are, for
+// edgevpn to read the roles, and signatures under <kind>-signature/<uuid>.
+var SignedKinds = []string{"role", "ip"}
+
+// PublishedIdentity is what a node announces under identity/<uuid> for its
+// signatures to be verified.
+type PublishedIdentity struct {
+	ID        string `json:"id"`
+	PublicKey []byte `json:"key"`
+}
+
+// GetIdentity returns the identity published by a node.
+func GetIdentity(l Ledger, uuid string) (PublishedIdentity, bool) {
+	var i PublishedIdentity
+	raw, _ := l.Get("identity", uuid)
+	if raw == "" || json.Unmarshal([]byte(raw), &i) != nil || len(i.PublicKey) == 0 {
+		return i, false
+	}
+	return i, true
+}
+
+// Claim is a value written through a signed ledger.
+type Claim struct {
+	Value     string
+	Signer    string
+	Signature []byte
+}
+
+func signatureKind(thing string) string {
+	return thing + "-signature"
+}
+
+func claimMessage(thing, uuid, value string) []byte {
+	return []byte(thing + "/" + uuid + "/" + value)
+}
+
+// Verify checks that the claim stored under the given key was signed with publicKey.
+func (c Claim) Verify(thing, uuid string, publicKey []byte) error {
+	if c.Signer == "" {
+		return errors.New("the value is not signed")
+	}
+	return identity.Verify(publicKey, claimMessage(thing, uuid, c.Value), c.Signature)
+}
+
+func isSigned(thing string) bool {
+	for _, kind := range SignedKinds {
+		if kind == thing {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimReader is implemented by ledgers which sign claims.
+type ClaimReader interface {
+	// Claim returns the claim stored under the given key, as written.
+	Claim(thing, uuid string) (Claim, error)
+}
+
+type signed struct {
+	Ledger
+	uuid string
+	id   identity.Identity
+}
+
+// NewSigned returns a Ledger signing the SignedKinds written by the node
+// uuid, and publishing the identity they are verified with.
+func NewSigned(l Ledger, uuid string, id identity.Identity) Ledger {
+	return signed{Ledger: l, uuid: uuid, id: id}
+}
+
+func (s signed) Unwrap() Ledger {
+	return s.Ledger
+}
+
+func (s signed) Claim(thing, uuid string) (Claim, error) {
+	v, err := Lookup(s.Ledger, thing, uuid)
+	if err != nil {
+		return Claim{}, err
+	}
+	c := Claim{Value: v}
+	raw, _ := s.Ledger.Get(signatureKind(thing), uuid)
+	signer, encoded, ok := strings.Cut(raw, ":")
+	if !ok {
+		return c, nil
+	}
+	if c.Signature, err = base64.RawURLEncoding.DecodeString(encoded); err != nil {
+		return c, nil
+	}
+	c.Signer = signer
+	return c, nil
+}
+
+func (s signed) Set(thing, uuid, value string) error {
+	if value == "" || !isSigned(thing) {
+		return s.Ledger.Set(thing, uuid, value)
+	}
+
+	// Signing can take a round trip to the TPM, don't sign claims again
+	if c, _ := s.Claim(thing, uuid); c.Value == value && c.Signer == s.uuid && c.Verify(thing, uuid, s.id.PublicKey()) == nil {
+		return nil
+	}
+
+	if err := s.publish(); err != nil {
+		return fmt.Errorf("publishing the node identity: %w", err)
+	}
+	sig, err := s.id.Sign(claimMessage(thing, uuid, value))
+	if err != nil {
+		return fmt.Errorf("signing %s/%s: %w", thing, uuid, err)
+	}
+	if err := s.Ledger.Set(signatureKind(thing), uuid, s.uuid+":"+base64.RawURLEncoding.EncodeToString(sig)); err != nil {
+		return err
+	}
+	return s.Ledger.Set(thing, uuid, value)
+}
+
+// publish announces the identity of the node, unless it is already there.
+func (s signed) publish() error {
+	dat, err := json.Marshal(PublishedIdentity{ID: s.id.ID(), PublicKey: s.id.PublicKey()})
+	if err != nil {
+		return err
+	}
+	if current, _ := s.Ledger.Get("identity", s.uuid); current == string(dat) {
+		return nil
+	}
+	return s.Ledger.Set("identity", s.uuid, string(dat))
+}
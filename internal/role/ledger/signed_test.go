@@ -0,0 +1,90 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/identity"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func testIdentity() identity.Identity {
+	dir := GinkgoT().TempDir()
+	Expect(os.WriteFile(filepath.Join(dir, "machine-id"), []byte("machine"), 0600)).To(Succeed())
+	id, err := identity.NewSoftware(filepath.Join(dir, "identity.key"), filepath.Join(dir, "machine-id"))
+	Expect(err).ToNot(HaveOccurred())
+	return id
+}
+
+var _ = Describe("Signed ledger", func() {
+	var (
+		raw Ledger
+		id  identity.Identity
+		l   Ledger
+	)
+
+	BeforeEach(func() {
+		raw = NewMemoryNetwork("node").Node("node")
+		id = testIdentity()
+		l = NewSigned(raw, "node", id)
+	})
+
+	It("signs claims and publishes the identity they verify with", func() {
+		Expect(l.Set("role", "node", "master")).To(Succeed())
+		Expect(raw.Get("role", "node")).To(Equal("master"))
+
+		published, ok := GetIdentity(raw, "node")
+		Expect(ok).To(BeTrue())
+		Expect(published.ID).To(Equal(id.ID()))
+
+		c, err := l.(ClaimReader).Claim("role", "node")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Signer).To(Equal("node"))
+		Expect(c.Verify("role", "node", published.PublicKey)).To(Succeed())
+	})
+
+	It("doesn't verify tampered claims", func() {
+		Expect(l.Set("role", "node", "worker")).To(Succeed())
+		Expect(raw.Set("role", "node", "master")).To(Succeed())
+
+		c, err := l.(ClaimReader).Claim("role", "node")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Verify("role", "node", id.PublicKey())).ToNot(Succeed())
+
+		// Signatures are bound to the key too
+		Expect(l.Set("ip", "node", "10.1.0.1")).To(Succeed())
+		sig, _ := raw.Get("ip-signature", "node")
+		Expect(raw.Set("ip-signature", "other", sig)).To(Succeed())
+		Expect(raw.Set("ip", "other", "10.1.0.1")).To(Succeed())
+		c, err = l.(ClaimReader).Claim("ip", "other")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Verify("ip", "other", id.PublicKey())).ToNot(Succeed())
+	})
+
+	It("leaves other kinds unsigned", func() {
+		Expect(l.Set("facts", "node", "{}")).To(Succeed())
+		Expect(raw.Get("facts-signature", "node")).To(BeEmpty())
+		Expect(raw.Get("identity", "node")).To(BeEmpty())
+	})
+
+	It("reports unsigned claims", func() {
+		Expect(raw.Set("role", "node", "master")).To(Succeed())
+		c, err := l.(ClaimReader).Claim("role", "node")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Verify("role", "node", id.PublicKey())).To(MatchError(ContainSubstring("not signed")))
+	})
+
+	It("finds wrapped ledgers", func() {
+		keys, err := NewKeyring("network-token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		wrapped := NewSigned(NewSealed(raw, keys), "node", id)
+
+		_, ok := As[Resealer](wrapped)
+		Expect(ok).To(BeTrue())
+		_, ok = As[ClaimReader](wrapped)
+		Expect(ok).To(BeTrue())
+		_, ok = As[Resealer](raw)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,150 @@
+package role
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsRegistry holds the metrics exported by the metrics listener.
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	advertizingNodesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kairos_p2p_advertizing_nodes",
+		Help: "Number of nodes advertizing on the network.",
+	})
+	activeNodesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kairos_p2p_active_nodes",
+		Help: "Number of nodes recently seen on the network.",
+	})
+	minimumNodesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kairos_p2p_minimum_nodes",
+		Help: "Number of advertizing nodes required before roles are scheduled.",
+	})
+	leaderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kairos_p2p_leader",
+		Help: "Leader of the network as seen by this node, labelled by its UUID. The value is the lease term.",
+	}, []string{"uuid"})
+	isLeaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kairos_p2p_is_leader",
+		Help: "Whether this node is the leader scheduling the roles.",
+	})
+	rolesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kairos_p2p_nodes_by_role",
+		Help: "Number of advertizing nodes by assigned role, unassigned ones have an empty role.",
+	}, []string{"role"})
+	schedulingErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kairos_p2p_scheduling_errors_total",
+		Help: "Number of scheduling rounds of the leader which failed.",
+	})
+	ledgerSetFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kairos_p2p_ledger_set_failures_total",
+		Help: "Number of writes to the ledger which failed, by kind of key.",
+	}, []string{"kind"})
+	bootstrapSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kairos_bootstrap_duration_seconds",
+		Help: "Time the bootstrap of the node role took to complete.",
+	}, []string{"role"})
+	bootstrapFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kairos_bootstrap_failed",
+		Help: "Whether the last attempt of the bootstrap of the node role failed.",
+	}, []string{"role"})
+	sentinelGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "kairos_bootstrap_sentinel",
+		Help: "Whether the node wrote the sentinel marking its role as deployed.",
+	}, func() float64 {
+		if SentinelExist() {
+			return 1
+		}
+		return 0
+	})
+)
+
+func init() {
+	MetricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		advertizingNodesGauge,
+		activeNodesGauge,
+		minimumNodesGauge,
+		leaderGauge,
+		isLeaderGauge,
+		rolesGauge,
+		schedulingErrors,
+		ledgerSetFailures,
+		bootstrapSeconds,
+		bootstrapFailed,
+		sentinelGauge,
+	)
+}
+
+// ServeMetrics exports the metrics over HTTP at /metrics on the given address.
+func ServeMetrics(listen string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return srv.ListenAndServe()
+}
+
+// observeNetwork records the view of the network of a round of the auto role.
+func observeNetwork(l ledger.Ledger, advertizing, actives []string, minimumNodes int) {
+	advertizingNodesGauge.Set(float64(len(advertizing)))
+	activeNodesGauge.Set(float64(len(actives)))
+	minimumNodesGauge.Set(float64(minimumNodes))
+
+	_, roles := getRoles(l, advertizing)
+	rolesGauge.Reset()
+	for _, r := range roles {
+		rolesGauge.WithLabelValues(r).Inc()
+	}
+}
+
+// observeLeader records the leader as seen by the node uuid.
+func observeLeader(uuid string, current lease) {
+	leaderGauge.Reset()
+	if current.Holder != "" {
+		leaderGauge.WithLabelValues(current.Holder).Set(float64(current.Term))
+	}
+	if current.Holder == uuid {
+		isLeaderGauge.Set(1)
+	} else {
+		isLeaderGauge.Set(0)
+	}
+}
+
+// observeBootstrap records the outcome of the bootstrap of the node role.
+func observeBootstrap(p Progress) {
+	if p.Role == "" {
+		return
+	}
+	if p.Phase == StepsDone {
+		bootstrapSeconds.WithLabelValues(p.Role).Set(p.UpdatedAt.Sub(p.StartedAt).Seconds())
+	}
+	if p.Error != "" {
+		bootstrapFailed.WithLabelValues(p.Role).Set(1)
+	} else {
+		bootstrapFailed.WithLabelValues(p.Role).Set(0)
+	}
+}
+
+// instrumentedLedger counts the writes to the ledger which failed.
+type instrumentedLedger struct {
+	ledger.Ledger
+}
+
+func (i instrumentedLedger) Unwrap() ledger.Ledger {
+	return i.Ledger
+}
+
+func (i instrumentedLedger) Set(thing, uuid, value string) error {
+	err := i.Ledger.Set(thing, uuid, value)
+	if err != nil {
+		ledgerSetFailures.WithLabelValues(thing).Inc()
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package role
+
+import (
+	"errors"
+	"time"
+
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type readOnlyLedger struct {
+	ledger.Ledger
+}
+
+func (readOnlyLedger) Set(string, string, string) error {
+	return errors.New("read only")
+}
+
+var _ = Describe("Metrics", func() {
+	It("exports the view of the network", func() {
+		sim := newSimulation(&providerConfig.Config{P2P: &providerConfig.P2P{MinimumNodes: 3}}, 3)
+		sim.run(5)
+
+		Expect(testutil.ToFloat64(advertizingNodesGauge)).To(Equal(3.0))
+		Expect(testutil.ToFloat64(activeNodesGauge)).To(Equal(3.0))
+		Expect(testutil.ToFloat64(minimumNodesGauge)).To(Equal(3.0))
+		Expect(testutil.ToFloat64(rolesGauge.WithLabelValues("master"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(rolesGauge.WithLabelValues("worker"))).To(Equal(2.0))
+
+		current := readLease(sim.network.Node(sim.nodes[0]))
+		Expect(testutil.CollectAndCount(leaderGauge)).To(Equal(1))
+		Expect(testutil.ToFloat64(leaderGauge.WithLabelValues(current.Holder))).To(Equal(float64(current.Term)))
+	})
+
+	It("counts the failed writes by kind", func() {
+		before := testutil.ToFloat64(ledgerSetFailures.WithLabelValues("role"))
+		l := instrumentedLedger{Ledger: readOnlyLedger{Ledger: ledger.NewMemoryNetwork("node").Node("node")}}
+		Expect(l.Set("role", "node", "worker")).ToNot(Succeed())
+		Expect(testutil.ToFloat64(ledgerSetFailures.WithLabelValues("role"))).To(Equal(before + 1))
+	})
+
+	It("exports the time the bootstrap took", func() {
+		start := time.Now()
+		observeBootstrap(Progress{Role: "worker", Phase: StepsDone, StartedAt: start, UpdatedAt: start.Add(90 * time.Second)})
+		Expect(testutil.ToFloat64(bootstrapSeconds.WithLabelValues("worker"))).To(Equal(90.0))
+		Expect(testutil.ToFloat64(bootstrapFailed.WithLabelValues("worker"))).To(Equal(0.0))
+
+		observeBootstrap(Progress{Role: "master", Phase: "start", Error: "unit not found"})
+		Expect(testutil.ToFloat64(bootstrapFailed.WithLabelValues("master"))).To(Equal(1.0))
+	})
+})
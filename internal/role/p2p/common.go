@@ -1,9 +1,6 @@
 package role
 
 import (
-	"fmt"
-	"net"
-
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
 )
 
@@ -15,19 +12,9 @@ const (
 	RoleAuto              = "auto"
 )
 
-func guessInterface(pconfig *providerConfig.Config) string {
+func guessInterface(pconfig *providerConfig.Config, h Host) string {
 	if pconfig.KubeVIP.Interface != "" {
 		return pconfig.KubeVIP.Interface
 	}
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		fmt.Println("failed getting system interfaces")
-		return ""
-	}
-	for _, i := range ifaces {
-		if i.Name != "lo" {
-			return i.Name
-		}
-	}
-	return ""
+	return h.DefaultInterface()
 }
@@ -0,0 +1,162 @@
+package role
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/joho/godotenv"
+	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/kairos-io/provider-kairos/v2/internal/assets"
+)
+
+// Host is the machine a node configures: the files it writes its
+// configuration to, its interfaces, and the defaults of the binaries
+// installed.
+type Host interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	InterfaceIP(iface string) string
+	// DefaultInterface is the first interface which is not the loopback
+	DefaultInterface() string
+	// K0sConfig is the default config of the k0s binary installed
+	K0sConfig() ([]byte, error)
+}
+
+type osHost struct{}
+
+// OSHost is the machine the provider runs on.
+var OSHost Host = osHost{}
+
+func (osHost) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osHost) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osHost) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osHost) InterfaceIP(iface string) string {
+	return utils.GetInterfaceIP(iface)
+}
+
+func (osHost) DefaultInterface() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Println("failed getting system interfaces")
+		return ""
+	}
+	for _, i := range ifaces {
+		if i.Name != "lo" {
+			return i.Name
+		}
+	}
+	return ""
+}
+
+func (osHost) K0sConfig() ([]byte, error) {
+	bin := utils.K0sBin()
+	if bin == "" {
+		return nil, errors.New("no k0s binary found")
+	}
+	return exec.Command(bin, "config", "create").Output()
+}
+
+// MemoryHost keeps the files written in memory, for dry runs. Every
+// interface has the same address, and the interface names and binary
+// defaults don't depend on the machine the dry run happens on.
+type MemoryHost struct {
+	sync.Mutex
+	ip    string
+	files map[string][]byte
+}
+
+// NewMemoryHost returns an empty host whose interfaces have the given address.
+func NewMemoryHost(ip string) *MemoryHost {
+	return &MemoryHost{ip: ip, files: map[string][]byte{}}
+}
+
+func (m *MemoryHost) ReadFile(name string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryHost) WriteFile(name string, data []byte, _ os.FileMode) error {
+	m.Lock()
+	defer m.Unlock()
+	m.files[name] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *MemoryHost) MkdirAll(string, os.FileMode) error {
+	return nil
+}
+
+func (m *MemoryHost) InterfaceIP(string) string {
+	return m.ip
+}
+
+func (m *MemoryHost) DefaultInterface() string {
+	return PlanInterface
+}
+
+func (m *MemoryHost) K0sConfig() ([]byte, error) {
+	data, err := fs.ReadFile(assets.GetStaticFS(), "k0s_config.yaml")
+	if err != nil {
+		return nil, err
+	}
+	return bytes.ReplaceAll(data, []byte("<ip>"), []byte(m.ip)), nil
+}
+
+// Files returns the names of the files written, sorted.
+func (m *MemoryHost) Files() []string {
+	m.Lock()
+	defer m.Unlock()
+	names := []string{}
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteEnv merges env into an env file of the host, like utils.WriteEnv does.
+func WriteEnv(h Host, file string, env map[string]string) error {
+	content, err := h.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	current, _ := godotenv.Unmarshal(string(content))
+	if current == nil {
+		current = map[string]string{}
+	}
+	for k, v := range env {
+		current[k] = v
+	}
+
+	data, err := godotenv.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := h.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return h.WriteFile(file, []byte(data+"\n"), 0644)
+}
@@ -0,0 +1,146 @@
+package role
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+)
+
+// DefaultJoinTokenTTL is how long the token minted for a worker stays valid.
+const DefaultJoinTokenTTL = time.Hour
+
+// joinToken is the record the master publishes under jointoken/<uuid>
+// for a worker to join the cluster with.
+type joinToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	// nodeUUIDPattern matches the UUIDs of the nodes, a machine ID and a hostname
+	nodeUUIDPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+	// tokenIDPattern matches the IDs of the bootstrap tokens
+	tokenIDPattern = regexp.MustCompile(`^[a-z0-9]{6}$`)
+)
+
+// tokenCommand runs the distribution binary to manage join tokens. The
+// arguments are read from the ledger, so they are never given to a shell.
+// It is replaced in tests.
+var tokenCommand = func(bin string, args ...string) (string, error) {
+	out, err := exec.Command(bin, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkNodeUUID refuses the UUIDs which can't have been generated by a node,
+// before they are handed to the distribution binary.
+func checkNodeUUID(uuid string) error {
+	if !nodeUUIDPattern.MatchString(uuid) {
+		return fmt.Errorf("invalid node UUID %q", uuid)
+	}
+	return nil
+}
+
+// revocableTokenID returns the ID of a bootstrap token to revoke it.
+func revocableTokenID(token string) (string, error) {
+	id := bootstrapTokenID(token)
+	if !tokenIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid bootstrap token ID %q", id)
+	}
+	return id, nil
+}
+
+func joinTokenTTL(k K8sNode) time.Duration {
+	if p := k.ProviderConfig().P2P; p != nil && p.JoinTokenTTL > 0 {
+		return time.Duration(p.JoinTokenTTL) * time.Second
+	}
+	return DefaultJoinTokenTTL
+}
+
+// readJoinToken returns the join token published for a node, or an empty
+// string if there is none or it expired.
+func readJoinToken(l ledger.Ledger, uuid string, now time.Time) string {
+	raw, _ := l.Get("jointoken", uuid)
+	if raw == "" {
+		return ""
+	}
+	var t joinToken
+	if err := json.Unmarshal([]byte(raw), &t); err != nil || !now.Before(t.Expires) {
+		return ""
+	}
+	return t.Token
+}
+
+// bootstrapTokenID returns the ID of a "<id>.<secret>" bootstrap token,
+// as printed by "k3s token create" with or without the CA hash prefix.
+func bootstrapTokenID(token string) string {
+	if i := strings.LastIndex(token, "::"); i >= 0 {
+		token = token[i+2:]
+	}
+	id, _, _ := strings.Cut(token, ".")
+	return id
+}
+
+// publishJoinTokens mints a token for every worker which didn't join the
+// cluster through masterIP yet, unless it still has a valid one. Workers
+// are told apart by the IP they joined through, so that they are given a
+// new token when the master moves. The token a new one replaces is revoked,
+// so that unused tokens don't pile up until they expire.
+func publishJoinTokens(c *service.RoleConfig, l ledger.Ledger, masterIP string, ttl time.Duration, now time.Time, mint func(uuid string, ttl time.Duration) (string, error), revoke func(token string) error) error {
+	nodes, err := l.AdvertizingNodes()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range nodes {
+		if r, _ := l.Get("role", u); r != RoleWorker {
+			continue
+		}
+		if joined, _ := l.Get("joined", u); joined == masterIP {
+			continue
+		}
+		// Leave a worker some time to use its token before minting a new one
+		if readJoinToken(l, u, now.Add(ttl/4)) != "" {
+			continue
+		}
+
+		previous := readJoinToken(l, u, now)
+
+		token, err := mint(u, ttl)
+		if err != nil {
+			c.Logger.Warnf("Failed minting a join token for '%s': %s", u, err.Error())
+			continue
+		}
+		dat, err := json.Marshal(joinToken{Token: token, Expires: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		c.Logger.Infof("Minted a join token for '%s'", u)
+		if err := l.Set("jointoken", u, string(dat)); err != nil {
+			return err
+		}
+		if previous != "" {
+			if err := revoke(previous); err != nil {
+				c.Logger.Warnf("Failed revoking the previous join token of '%s': %s", u, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// consumeJoinToken records that the node joined through masterIP, and
+// deletes its join token so that it can't be used again.
+func consumeJoinToken(l ledger.Ledger, uuid, masterIP string) error {
+	if err := l.Set("joined", uuid, masterIP); err != nil {
+		return err
+	}
+	return l.Delete("jointoken", uuid)
+}
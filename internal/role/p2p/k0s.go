@@ -1,14 +1,19 @@
 package role
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"errors"
-	"os"
+	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kairos-io/kairos-sdk/machine"
 	"github.com/kairos-io/kairos-sdk/utils"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	service "github.com/mudler/edgevpn/api/client/service"
 	"gopkg.in/yaml.v3"
 )
@@ -24,8 +29,12 @@ const (
 type K0sNode struct {
 	providerConfig *providerConfig.Config
 	roleConfig     *service.RoleConfig
+	ledger         ledger.Ledger
 	ip             string
+	iface          string
+	ifaceIP        string
 	role           string
+	host           Host
 }
 
 func (k *K0sNode) IsWorker() bool {
@@ -38,85 +47,185 @@ func (k *K0sNode) K8sBin() string {
 
 func (k *K0sNode) DeployKubeVIP() error {
 	pconfig := k.ProviderConfig()
-	if pconfig.KubeVIP.IsEnabled() {
-		return errors.New("KubeVIP is not yet supported with k0s")
+	if !pconfig.KubeVIP.IsEnabled() {
+		return nil
 	}
 
-	return nil
+	// Only workers run static pods in k0s, controllers get kube-vip deployed
+	// as a daemonset through the manifest deployer
+	if pconfig.KubeVIP.StaticPod {
+		return errors.New("KubeVIP static pods are not supported with k0s")
+	}
+
+	return deployKubeVIP(k.Host(), k.iface, k.ip, "/var/lib/k0s/manifests/kube-vip/", pconfig)
 }
 
-func (k *K0sNode) GenArgs() ([]string, error) {
-	var args []string
+// nodeIP returns the address the controller listens on. Behind kube-vip
+// the node IP is the VIP, which is only used as the external address.
+func (k *K0sNode) nodeIP() string {
+	if k.ProviderConfig().KubeVIP.IsEnabled() {
+		return k.ifaceIP
+	}
+
+	return k.IP()
+}
 
-	// Generate a new k0s config
-	_, err := utils.SH("k0s config create > /etc/k0s/k0s.yaml")
+// k0sSection returns the map found at the given path of a k0s config.
+func k0sSection(config map[string]interface{}, path ...string) (map[string]interface{}, error) {
+	section := config
+	for i, key := range path {
+		next, ok := section[key].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("k0s config does not have a %s", strings.Join(path[:i+1], "."))
+		}
+		section = next
+	}
+	return section, nil
+}
+
+// configure adjusts a k0s config generated by "k0s config create" for the node.
+func (k *K0sNode) configure(k0sConfig map[string]interface{}) error {
+	api, err := k0sSection(k0sConfig, "spec", "api")
 	if err != nil {
-		return args, err
+		return err
 	}
-	args = append(args, "--config /etc/k0s/k0s.yaml")
+	// by default k0s uses the first IP address of the machine as the api address, but we want to use the edgevpn IP
+	api["address"] = k.nodeIP()
 
-	data, err := os.ReadFile("/etc/k0s/k0s.yaml")
+	// Behind kube-vip, every controller and worker joins through the VIP
+	if k.ProviderConfig().KubeVIP.IsEnabled() {
+		api["externalAddress"] = k.IP()
+		sans, _ := api["sans"].([]interface{})
+		api["sans"] = append(sans, k.IP())
+	}
+
+	kubeRouter, err := k0sSection(k0sConfig, "spec", "network", "kuberouter")
 	if err != nil {
-		return nil, err
+		return err
 	}
+	// by default k0s uses the port 8080 for the metrics but this conflicts with the edgevpn API port
+	kubeRouter["metricsPort"] = 9090
 
-	var k0sConfig map[any]any
-	err = yaml.Unmarshal(data, &k0sConfig)
+	etcd, err := k0sSection(k0sConfig, "spec", "storage", "etcd")
 	if err != nil {
-		return args, err
+		return err
 	}
+	// just like the api address, we want to use the edgevpn IP for the etcd peer address
+	etcd["peerAddress"] = k.nodeIP()
 
-	// check if the k0s config has an api address
-	spec, ok := k0sConfig["spec"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have a spec")
+	return nil
+}
+
+// k0sConfigFile is the cluster config k0s controllers are started with.
+const k0sConfigFile = "/etc/k0s/k0s.yaml"
+
+// defaultConfig returns the config k0s generates for the node.
+func (k *K0sNode) defaultConfig() (map[string]interface{}, error) {
+	data, err := k.Host().K0sConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed generating the k0s config: %w", err)
 	}
-	api, ok := spec["api"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have an api")
+
+	var k0sConfig map[string]interface{}
+	if err := yaml.Unmarshal(data, &k0sConfig); err != nil {
+		return nil, err
 	}
-	// by default k0s uses the first IP address of the machine as the api address, but we want to use the edgevpn IP
-	api["address"] = k.IP()
+	return k0sConfig, nil
+}
 
-	spec["api"] = api
+// mergeK0sConfig deep merges src over dst. Mappings are merged key by key,
+// anything else in src replaces what is in dst.
+func mergeK0sConfig(dst, src map[string]interface{}, path string) error {
+	for key, value := range src {
+		p := key
+		if path != "" {
+			p = path + "." + key
+		}
+
+		current, exists := dst[key]
+		if !exists || current == nil || value == nil {
+			dst[key] = value
+			continue
+		}
 
-	network, ok := spec["network"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have a network")
+		currentMap, currentIsMap := current.(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+		switch {
+		case currentIsMap && valueIsMap:
+			if err := mergeK0sConfig(currentMap, valueMap, p); err != nil {
+				return err
+			}
+		case currentIsMap != valueIsMap:
+			return fmt.Errorf("%s: cannot merge a %s over a %s", p, yamlKind(value), yamlKind(current))
+		default:
+			dst[key] = value
+		}
 	}
-	kubeRouter, ok := network["kuberouter"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have a kuberouter")
+	return nil
+}
+
+func yamlKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "mapping"
+	case []interface{}:
+		return "sequence"
+	default:
+		return "scalar"
 	}
+}
 
-	// by default k0s uses the port 8080 for the metrics but this conflicts with the edgevpn API port
-	kubeRouter["metricsPort"] = 9090
-	network["kuberouter"] = kubeRouter
-	spec["network"] = network
+// clusterConfig returns the config generated by k0s with the one supplied by the user merged over.
+func (k *K0sNode) clusterConfig() (map[string]interface{}, error) {
+	k0sConfig, err := k.defaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeK0sConfig(k0sConfig, k.ProviderConfig().K0s.Config, ""); err != nil {
+		return nil, fmt.Errorf("invalid k0s.config: %w", err)
+	}
+	return k0sConfig, nil
+}
 
-	storage, ok := spec["storage"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have a storage")
+func writeK0sConfig(h Host, k0sConfig map[string]interface{}) error {
+	data, err := yaml.Marshal(k0sConfig)
+	if err != nil {
+		return err
 	}
-	etcd, ok := storage["etcd"].(map[any]any)
-	if !ok {
-		return args, errors.New("k0s config does not have a etcd")
+	if err := h.MkdirAll(filepath.Dir(k0sConfigFile), 0755); err != nil {
+		return err
 	}
-	// just like the api address, we want to use the edgevpn IP for the etcd peer address
-	etcd["peerAddress"] = k.IP()
+	return h.WriteFile(k0sConfigFile, data, 0644)
+}
 
-	storage["etcd"] = etcd
-	spec["storage"] = storage
+// WriteStandaloneConfig writes the cluster config of controllers deployed
+// without p2p, when the user supplied one.
+func (k *K0sNode) WriteStandaloneConfig() error {
+	if k.IsWorker() || len(k.ProviderConfig().K0s.Config) == 0 {
+		return nil
+	}
 
-	k0sConfig["spec"] = spec
+	k0sConfig, err := k.clusterConfig()
+	if err != nil {
+		return err
+	}
+	return writeK0sConfig(k.Host(), k0sConfig)
+}
+
+func (k *K0sNode) GenArgs() ([]string, error) {
+	args := []string{fmt.Sprintf("--config %s", k0sConfigFile)}
 
-	// write the k0s config back to the file
-	data, err = yaml.Marshal(k0sConfig)
+	k0sConfig, err := k.clusterConfig()
 	if err != nil {
 		return args, err
 	}
-	err = os.WriteFile("/etc/k0s/k0s.yaml", data, 0644)
-	if err != nil {
+
+	// The overrides needed by the provider are applied last
+	if err := k.configure(k0sConfig); err != nil {
+		return args, err
+	}
+
+	if err := writeK0sConfig(k.Host(), k0sConfig); err != nil {
 		return args, err
 	}
 
@@ -125,15 +234,21 @@ func (k *K0sNode) GenArgs() ([]string, error) {
 		return args, errors.New("having a VPN but not using it for Kubernetes is not yet supported with k0s")
 	}
 
-	if pconfig.KubeVIP.IsEnabled() {
-		return args, errors.New("KubeVIP is not yet supported with k0s")
-	}
-
 	if pconfig.P2P.Auto.HA.ExternalDB != "" {
 		return args, errors.New("ExternalDB is not yet supported with k0s")
 	}
 
+	// k0s controllers don't run a kubelet by default, so nothing would run kube-vip
+	if pconfig.KubeVIP.IsEnabled() {
+		args = append(args, "--enable-worker")
+	}
+
+	// The first controller bootstraps etcd, the others join it with a controller token
 	if k.HA() && !k.ClusterInit() {
+		token, _ := k.Token()
+		if err := k.Host().WriteFile("/etc/k0s/token", []byte(strings.TrimRight(token, "\n")), 0600); err != nil {
+			return args, err
+		}
 		args = append(args, "--token-file /etc/k0s/token")
 	}
 
@@ -157,11 +272,51 @@ func (k *K0sNode) Service() (machine.Service, error) {
 }
 
 func (k *K0sNode) Token() (string, error) {
-	if k.IsWorker() {
-		return k.RoleConfig().Client.Get("workertoken", "token")
+	return k.Ledger().Get("controllertoken", "token")
+}
+
+// JoinToken creates a worker token. k0s tokens aren't described, they are
+// listed and invalidated with "k0s token" by id.
+func (k *K0sNode) JoinToken(_ string, ttl time.Duration) (string, error) {
+	return tokenCommand(k.K8sBin(), "token", "create", "--role=worker", "--expiry="+ttl.String())
+}
+
+func (k *K0sNode) RevokeJoinToken(token string) error {
+	id, err := k0sTokenID(token)
+	if err != nil {
+		return err
+	}
+	_, err = tokenCommand(k.K8sBin(), "token", "invalidate", id)
+	return err
+}
+
+// k0sTokenID returns the ID of a k0s join token, which is a gzipped
+// kubeconfig authenticating with a bootstrap token.
+func k0sTokenID(token string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
 	}
+	defer r.Close()
 
-	return k.RoleConfig().Client.Get("controllertoken", "token")
+	var kubeconfig struct {
+		Users []struct {
+			User struct {
+				Token string `yaml:"token"`
+			} `yaml:"user"`
+		} `yaml:"users"`
+	}
+	if err := yaml.NewDecoder(r).Decode(&kubeconfig); err != nil {
+		return "", err
+	}
+	if len(kubeconfig.Users) == 0 || kubeconfig.Users[0].User.Token == "" {
+		return "", errors.New("no bootstrap token in the join token")
+	}
+	return revocableTokenID(kubeconfig.Users[0].User.Token)
 }
 
 func (k *K0sNode) GenerateEnv() (env map[string]string) {
@@ -198,14 +353,20 @@ func (k *K0sNode) RoleConfig() *service.RoleConfig {
 	return k.roleConfig
 }
 
+func (k *K0sNode) SetLedger(l ledger.Ledger) {
+	k.ledger = l
+}
+
+func (k *K0sNode) Ledger() ledger.Ledger {
+	return k.ledger
+}
+
 func (k *K0sNode) HA() bool {
 	return k.role == RoleMasterHA
 }
 
 func (k *K0sNode) ClusterInit() bool {
-	// k0s does not have a cluster init role like k3s. Instead we should have a way to set in the config
-	// if the user wants a single node cluster, multi-node cluster, or HA cluster
-	return false
+	return k.role == RoleMasterClusterInit
 }
 
 func (k *K0sNode) IP() string {
@@ -221,31 +382,25 @@ func (k *K0sNode) PropagateData() error {
 
 	// we don't want to set the output if there is an error
 	if err == nil && controllerToken != "" {
-		err := c.Client.Set("controllertoken", "token", strings.TrimSuffix(controllerToken, "\n"))
+		err := k.Ledger().Set("controllertoken", "token", strings.TrimSuffix(controllerToken, "\n"))
 		if err != nil {
 			c.Logger.Error(err)
 		}
 	}
 
-	workerToken, err := utils.SH("k0s token create --role=worker") //nolint:errcheck
-	if err != nil {
-		c.Logger.Errorf("failed to create worker token: %s", err)
-	}
-	// we don't want to set the output if there is an error
-	if err == nil && workerToken != "" {
-		err := c.Client.Set("workertoken", "token", strings.TrimSuffix(workerToken, "\n"))
-		if err != nil {
-			c.Logger.Error(err)
-		}
+	// Workers join with tokens minted for each of them, drop the shared one
+	// published by previous versions
+	if err := k.Ledger().Delete("workertoken", "token"); err != nil {
+		c.Logger.Error(err)
 	}
 
-	kubeconfig, err := utils.SH("k0s config create") //nolint:errcheck
+	kubeconfig, err := utils.SH("k0s kubeconfig admin") //nolint:errcheck
 	if err != nil {
 		c.Logger.Error(err)
 		return err
 	}
 	if kubeconfig != "" {
-		err := c.Client.Set("kubeconfig", "master", base64.RawURLEncoding.EncodeToString([]byte(kubeconfig)))
+		err := k.Ledger().Set("kubeconfig", "master", base64.RawURLEncoding.EncodeToString([]byte(kubeconfig)))
 		if err != nil {
 			c.Logger.Error(err)
 		}
@@ -265,11 +420,26 @@ func (k *K0sNode) WorkerArgs() ([]string, error) {
 		args = append(args, k0sConfig.Args...)
 	}
 
+	if pool, ok := workerPool(k); ok {
+		if len(pool.NodeLabels) > 0 {
+			args = append(args, fmt.Sprintf("--labels=%s", strings.Join(nodeLabels(pool.NodeLabels), ",")))
+		}
+		if len(pool.Taints) > 0 {
+			args = append(args, fmt.Sprintf("--taints=%s", strings.Join(pool.Taints, ",")))
+		}
+		args = append(args, pool.Args...)
+	}
+
 	return args, nil
 }
 
+func (k *K0sNode) WorkerToken() string {
+	data, _ := k.Host().ReadFile("/etc/k0s/token")
+	return string(data)
+}
+
 func (k *K0sNode) SetupWorker(_, nodeToken string) error {
-	if err := os.WriteFile("/etc/k0s/token", []byte(nodeToken), 0644); err != nil {
+	if err := k.Host().WriteFile("/etc/k0s/token", []byte(nodeToken), 0644); err != nil {
 		return err
 	}
 
@@ -311,6 +481,10 @@ func (k *K0sNode) Args() []string {
 		return c.K0sWorker.Args
 	}
 
+	if len(c.K0s.Config) != 0 {
+		return append([]string{fmt.Sprintf("--config %s", k0sConfigFile)}, c.K0s.Args...)
+	}
+
 	return c.K0s.Args
 }
 
@@ -322,12 +496,28 @@ func (k *K0sNode) SetRole(role string) {
 	k.role = role
 }
 
+// SetHost sets the machine the node configures, the OS one by default.
+func (k *K0sNode) SetHost(h Host) {
+	k.host = h
+}
+
+func (k *K0sNode) Host() Host {
+	if k.host == nil {
+		return OSHost
+	}
+	return k.host
+}
+
 func (k *K0sNode) SetIP(ip string) {
 	k.ip = ip
 }
 
 func (k *K0sNode) GuessInterface() {
-	// not used in k0s
+	iface := guessInterface(k.ProviderConfig(), k.Host())
+	ifaceIP := k.Host().InterfaceIP(iface)
+
+	k.iface = iface
+	k.ifaceIP = ifaceIP
 }
 
 func (k *K0sNode) Distro() string {
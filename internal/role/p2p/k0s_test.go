@@ -0,0 +1,123 @@
+package role
+
+import (
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+// Trimmed down output of "k0s config create"
+const k0sDefaultConfig = `
+apiVersion: k0s.k0sproject.io/v1beta1
+kind: ClusterConfig
+metadata:
+  name: k0s
+spec:
+  api:
+    address: 192.168.1.10
+    port: 6443
+    sans:
+    - 192.168.1.10
+  network:
+    provider: kuberouter
+    kuberouter:
+      metricsPort: 8080
+  storage:
+    type: etcd
+    etcd:
+      peerAddress: 192.168.1.10
+`
+
+var _ = Describe("K0sNode", func() {
+	var k0sConfig map[string]interface{}
+
+	BeforeEach(func() {
+		k0sConfig = map[string]interface{}{}
+		Expect(yaml.Unmarshal([]byte(k0sDefaultConfig), &k0sConfig)).To(Succeed())
+	})
+
+	It("bootstraps the cluster on the clusterinit controller", func() {
+		Expect((&K0sNode{role: RoleMasterClusterInit}).ClusterInit()).To(BeTrue())
+		Expect((&K0sNode{role: RoleMasterHA}).ClusterInit()).To(BeFalse())
+	})
+
+	It("listens on the node IP", func() {
+		node := &K0sNode{providerConfig: &providerConfig.Config{}, ip: "10.1.0.2"}
+		Expect(node.configure(k0sConfig)).To(Succeed())
+
+		api, _ := k0sSection(k0sConfig, "spec", "api")
+		Expect(api).To(HaveKeyWithValue("address", "10.1.0.2"))
+		Expect(api).ToNot(HaveKey("externalAddress"))
+		etcd, _ := k0sSection(k0sConfig, "spec", "storage", "etcd")
+		Expect(etcd).To(HaveKeyWithValue("peerAddress", "10.1.0.2"))
+		kubeRouter, _ := k0sSection(k0sConfig, "spec", "network", "kuberouter")
+		Expect(kubeRouter).To(HaveKeyWithValue("metricsPort", 9090))
+	})
+
+	It("exposes the API through the VIP", func() {
+		node := &K0sNode{
+			providerConfig: &providerConfig.Config{KubeVIP: providerConfig.KubeVIP{EIP: "10.1.0.100"}},
+			ip:             "10.1.0.100",
+			ifaceIP:        "192.168.1.10",
+		}
+		Expect(node.configure(k0sConfig)).To(Succeed())
+
+		api, _ := k0sSection(k0sConfig, "spec", "api")
+		Expect(api).To(HaveKeyWithValue("address", "192.168.1.10"))
+		Expect(api).To(HaveKeyWithValue("externalAddress", "10.1.0.100"))
+		Expect(api["sans"]).To(ContainElement("10.1.0.100"))
+		etcd, _ := k0sSection(k0sConfig, "spec", "storage", "etcd")
+		Expect(etcd).To(HaveKeyWithValue("peerAddress", "192.168.1.10"))
+	})
+
+	It("reports the missing section", func() {
+		delete(k0sConfig["spec"].(map[string]interface{}), "storage")
+		node := &K0sNode{providerConfig: &providerConfig.Config{}}
+		Expect(node.configure(k0sConfig)).To(MatchError("k0s config does not have a spec.storage"))
+	})
+
+	Context("user config", func() {
+		It("is deep merged over the defaults", func() {
+			user := map[string]interface{}{}
+			Expect(yaml.Unmarshal([]byte(`
+spec:
+  api:
+    sans: [example.org]
+  network:
+    provider: calico
+`), &user)).To(Succeed())
+
+			Expect(mergeK0sConfig(k0sConfig, user, "")).To(Succeed())
+			api, _ := k0sSection(k0sConfig, "spec", "api")
+			Expect(api).To(HaveKeyWithValue("port", 6443))
+			Expect(api).To(HaveKeyWithValue("sans", []interface{}{"example.org"}))
+			network, _ := k0sSection(k0sConfig, "spec", "network")
+			Expect(network).To(HaveKeyWithValue("provider", "calico"))
+			Expect(network).To(HaveKey("kuberouter"))
+		})
+
+		It("doesn't take precedence over the provider overrides", func() {
+			user := map[string]interface{}{"spec": map[string]interface{}{"api": map[string]interface{}{"address": "1.2.3.4"}}}
+			Expect(mergeK0sConfig(k0sConfig, user, "")).To(Succeed())
+
+			node := &K0sNode{providerConfig: &providerConfig.Config{}, ip: "10.1.0.2"}
+			Expect(node.configure(k0sConfig)).To(Succeed())
+			api, _ := k0sSection(k0sConfig, "spec", "api")
+			Expect(api).To(HaveKeyWithValue("address", "10.1.0.2"))
+		})
+
+		It("names the path which can't be merged", func() {
+			user := map[string]interface{}{"spec": map[string]interface{}{"storage": map[string]interface{}{"etcd": "external"}}}
+			Expect(mergeK0sConfig(k0sConfig, user, "")).To(MatchError("spec.storage.etcd: cannot merge a scalar over a mapping"))
+
+			user = map[string]interface{}{"spec": map[string]interface{}{"api": map[string]interface{}{"sans": map[string]interface{}{"a": "b"}}}}
+			Expect(mergeK0sConfig(k0sConfig, user, "")).To(MatchError("spec.api.sans: cannot merge a mapping over a sequence"))
+		})
+	})
+
+	It("rejects kube-vip static pods", func() {
+		node := &K0sNode{providerConfig: &providerConfig.Config{KubeVIP: providerConfig.KubeVIP{EIP: "10.1.0.100", StaticPod: true}}}
+		Expect(node.DeployKubeVIP()).To(HaveOccurred())
+	})
+})
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/joho/godotenv"
 	"github.com/kairos-io/kairos-sdk/machine"
 	"github.com/kairos-io/kairos-sdk/utils"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	service "github.com/mudler/edgevpn/api/client/service"
 )
 
@@ -24,10 +27,12 @@ const (
 type K3sNode struct {
 	providerConfig *providerConfig.Config
 	roleConfig     *service.RoleConfig
+	ledger         ledger.Ledger
 	ip             string
 	iface          string
 	ifaceIP        string
 	role           string
+	host           Host
 }
 
 func (k *K3sNode) IsWorker() bool {
@@ -44,7 +49,12 @@ func (k *K3sNode) DeployKubeVIP() error {
 		return nil
 	}
 
-	return deployKubeVIP(k.iface, k.ip, pconfig)
+	manifestDirectory := "/var/lib/rancher/k3s/server/manifests/"
+	if pconfig.K3sAgent.IsEnabled() {
+		manifestDirectory = "/var/lib/rancher/k3s/agent/pod-manifests/"
+	}
+
+	return deployKubeVIP(k.Host(), k.iface, k.ip, manifestDirectory, pconfig)
 }
 
 func (k *K3sNode) GenArgs() ([]string, error) {
@@ -68,7 +78,7 @@ func (k *K3sNode) GenArgs() ([]string, error) {
 	}
 
 	if k.HA() && !k.ClusterInit() {
-		clusterInitIP, _ := k.roleConfig.Client.Get("master", "ip")
+		clusterInitIP, _ := k.Ledger().Get("master", "ip")
 		args = append(args, fmt.Sprintf("--server=https://%s:6443", clusterInitIP))
 	}
 	// The --cluster-init flag changes the embedded SQLite DB to etcd. We don't
@@ -104,7 +114,25 @@ func (k *K3sNode) Service() (machine.Service, error) {
 }
 
 func (k *K3sNode) Token() (string, error) {
-	return k.RoleConfig().Client.Get("nodetoken", "token")
+	return k.Ledger().Get("nodetoken", "token")
+}
+
+// JoinToken creates a bootstrap token, described after the node so it can be
+// found and deleted with "k3s token" if the node is compromised.
+func (k *K3sNode) JoinToken(uuid string, ttl time.Duration) (string, error) {
+	if err := checkNodeUUID(uuid); err != nil {
+		return "", err
+	}
+	return tokenCommand(k.K8sBin(), "token", "create", "--ttl", ttl.String(), "--description", "kairos node "+uuid)
+}
+
+func (k *K3sNode) RevokeJoinToken(token string) error {
+	id, err := revocableTokenID(token)
+	if err != nil {
+		return err
+	}
+	_, err = tokenCommand(k.K8sBin(), "token", "delete", id)
+	return err
 }
 
 func (k *K3sNode) GenerateEnv() (env map[string]string) {
@@ -141,6 +169,14 @@ func (k *K3sNode) RoleConfig() *service.RoleConfig {
 	return k.roleConfig
 }
 
+func (k *K3sNode) SetLedger(l ledger.Ledger) {
+	k.ledger = l
+}
+
+func (k *K3sNode) Ledger() ledger.Ledger {
+	return k.ledger
+}
+
 func (k *K3sNode) HA() bool {
 	return k.role == "master/ha"
 }
@@ -164,7 +200,7 @@ func (k *K3sNode) PropagateData() error {
 	nodeToken := string(tokenB)
 	nodeToken = strings.TrimRight(nodeToken, "\n")
 	if nodeToken != "" {
-		err := c.Client.Set("nodetoken", "token", nodeToken)
+		err := k.Ledger().Set("nodetoken", "token", nodeToken)
 		if err != nil {
 			c.Logger.Error(err)
 		}
@@ -177,7 +213,7 @@ func (k *K3sNode) PropagateData() error {
 	}
 	kubeconfig := string(kubeB)
 	if kubeconfig != "" {
-		err := c.Client.Set("kubeconfig", "master", base64.RawURLEncoding.EncodeToString(kubeB))
+		err := k.Ledger().Set("kubeconfig", "master", base64.RawURLEncoding.EncodeToString(kubeB))
 		if err != nil {
 			c.Logger.Error(err)
 		}
@@ -198,7 +234,7 @@ func (k *K3sNode) WorkerArgs() ([]string, error) {
 	}
 
 	if pconfig.P2P.UseVPNWithKubernetes() {
-		ip := utils.GetInterfaceIP("edgevpn0")
+		ip := k.Host().InterfaceIP("edgevpn0")
 		if ip == "" {
 			return nil, errors.New("node doesn't have an ip yet")
 		}
@@ -206,8 +242,8 @@ func (k *K3sNode) WorkerArgs() ([]string, error) {
 			fmt.Sprintf("--node-ip %s", ip),
 			"--flannel-iface=edgevpn0")
 	} else {
-		iface := guessInterface(pconfig)
-		ip := utils.GetInterfaceIP(iface)
+		iface := guessInterface(pconfig, k.Host())
+		ip := k.Host().InterfaceIP(iface)
 		args = append(args,
 			fmt.Sprintf("--node-ip %s", ip))
 	}
@@ -218,11 +254,28 @@ func (k *K3sNode) WorkerArgs() ([]string, error) {
 		args = append(args, k3sConfig.Args...)
 	}
 
+	if pool, ok := workerPool(k); ok {
+		for _, l := range nodeLabels(pool.NodeLabels) {
+			args = append(args, fmt.Sprintf("--node-label %s", l))
+		}
+		for _, t := range pool.Taints {
+			args = append(args, fmt.Sprintf("--node-taint %s", t))
+		}
+		args = append(args, pool.Args...)
+	}
+
 	return args, nil
 }
 
+func (k *K3sNode) WorkerToken() string {
+	data, _ := k.Host().ReadFile(machine.K3sEnvUnit("k3s-agent"))
+	env, _ := godotenv.Unmarshal(string(data))
+	return env["K3S_TOKEN"]
+}
+
 func (k *K3sNode) SetupWorker(masterIP, nodeToken string) error {
 	pconfig := k.ProviderConfig()
+	host := k.Host()
 
 	nodeToken = strings.TrimRight(nodeToken, "\n")
 
@@ -245,9 +298,7 @@ func (k *K3sNode) SetupWorker(masterIP, nodeToken string) error {
 		}
 	}
 
-	if err := utils.WriteEnv(machine.K3sEnvUnit("k3s-agent"),
-		env,
-	); err != nil {
+	if err := WriteEnv(host, machine.K3sEnvUnit("k3s-agent"), env); err != nil {
 		return err
 	}
 
@@ -308,13 +359,25 @@ func (k *K3sNode) SetRole(role string) {
 	k.role = role
 }
 
+// SetHost sets the machine the node configures, the OS one by default.
+func (k *K3sNode) SetHost(h Host) {
+	k.host = h
+}
+
+func (k *K3sNode) Host() Host {
+	if k.host == nil {
+		return OSHost
+	}
+	return k.host
+}
+
 func (k *K3sNode) SetIP(ip string) {
 	k.ip = ip
 }
 
 func (k *K3sNode) GuessInterface() {
-	iface := guessInterface(k.ProviderConfig())
-	ifaceIP := utils.GetInterfaceIP(iface)
+	iface := guessInterface(k.ProviderConfig(), k.Host())
+	ifaceIP := k.Host().InterfaceIP(iface)
 
 	k.iface = iface
 	k.ifaceIP = ifaceIP
@@ -2,10 +2,12 @@ package role
 
 import (
 	"errors"
+	"time"
 
 	"github.com/kairos-io/kairos-sdk/machine"
 	"github.com/kairos-io/kairos-sdk/utils"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	service "github.com/mudler/edgevpn/api/client/service"
 )
 
@@ -13,6 +15,7 @@ import (
 type BinaryDetector interface {
 	K3sBin() string
 	K0sBin() string
+	RKE2Bin() string
 }
 
 // DefaultBinaryDetector uses the utils package to detect binaries.
@@ -26,6 +29,10 @@ func (d *DefaultBinaryDetector) K0sBin() string {
 	return utils.K0sBin()
 }
 
+func (d *DefaultBinaryDetector) RKE2Bin() string {
+	return RKE2Bin()
+}
+
 type K8sNode interface {
 	PropagateData() error
 	IP() string
@@ -34,14 +41,22 @@ type K8sNode interface {
 	ProviderConfig() *providerConfig.Config
 	SetRoleConfig(c *service.RoleConfig)
 	RoleConfig() *service.RoleConfig
+	SetLedger(l ledger.Ledger)
+	Ledger() ledger.Ledger
 	GenerateEnv() map[string]string
 	Service() (machine.Service, error)
 	EnvUnit() string
 	GenArgs() ([]string, error)
 	DeployKubeVIP() error
 	Token() (string, error)
+	// JoinToken mints a token for the given node to join the cluster as a worker
+	JoinToken(uuid string, ttl time.Duration) (string, error)
+	// RevokeJoinToken invalidates a token minted by JoinToken
+	RevokeJoinToken(token string) error
 	K8sBin() string
 	SetupWorker(masterIP, nodeToken string) error
+	// WorkerToken returns the join token a deployed worker was set up with
+	WorkerToken() string
 	Role() string
 	WorkerArgs() ([]string, error)
 	ServiceName() string
@@ -50,6 +65,8 @@ type K8sNode interface {
 	EnvFile() string
 	SetRole(role string)
 	SetIP(ip string)
+	SetHost(h Host)
+	Host() Host
 	GuessInterface()
 	Distro() string
 }
@@ -58,8 +75,9 @@ type K8sNode interface {
 func NewK8sNodeWithDetector(c *providerConfig.Config, detector BinaryDetector) (K8sNode, error) {
 	k3sBinAvailable := detector.K3sBin() != ""
 	k0sBinAvailable := detector.K0sBin() != ""
+	rke2BinAvailable := detector.RKE2Bin() != ""
 
-	if !k3sBinAvailable && !k0sBinAvailable {
+	if !k3sBinAvailable && !k0sBinAvailable && !rke2BinAvailable {
 		return nil, errors.New("no k8s binary is available")
 	}
 
@@ -75,9 +93,15 @@ func NewK8sNodeWithDetector(c *providerConfig.Config, detector BinaryDetector) (
 	if c.K0sWorker.IsEnabled() {
 		return &K0sNode{providerConfig: c, role: RoleWorker}, nil
 	}
+	if c.RKE2.IsEnabled() {
+		return &RKE2Node{providerConfig: c, role: RoleMaster}, nil
+	}
+	if c.RKE2Agent.IsEnabled() {
+		return &RKE2Node{providerConfig: c, role: RoleWorker}, nil
+	}
 
 	if !c.IsP2PConfigured() {
-		return nil, errors.New("no k8s configuration found. To enable k8s, either: 1) explicitly enable k3s, k3s-agent, k0s, or k0s-worker or 2) configure p2p with a network token")
+		return nil, errors.New("no k8s configuration found. To enable k8s, either: 1) explicitly enable k3s, k3s-agent, k0s, k0s-worker, rke2 or rke2-agent or 2) configure p2p with a network token")
 	}
 
 	if c.P2P.Role != "" {
@@ -92,6 +116,10 @@ func NewK8sNodeWithDetector(c *providerConfig.Config, detector BinaryDetector) (
 		if k0sBinAvailable {
 			return &K0sNode{providerConfig: c, role: c.P2P.Role}, nil
 		}
+
+		if rke2BinAvailable {
+			return &RKE2Node{providerConfig: c, role: c.P2P.Role}, nil
+		}
 	}
 
 	if c.P2P.IsAutoEnabled() {
@@ -101,6 +129,9 @@ func NewK8sNodeWithDetector(c *providerConfig.Config, detector BinaryDetector) (
 		if k0sBinAvailable {
 			return &K0sNode{providerConfig: c}, nil // No role set, will be assigned automatically
 		}
+		if rke2BinAvailable {
+			return &RKE2Node{providerConfig: c}, nil // No role set, will be assigned automatically
+		}
 	}
 
 	return nil, errors.New("no k8s configuration found but p2p is configured")
@@ -8,8 +8,9 @@ import (
 
 // MockBinaryDetector for testing
 type MockBinaryDetector struct {
-	k3sBin string
-	k0sBin string
+	k3sBin  string
+	k0sBin  string
+	rke2Bin string
 }
 
 func (m *MockBinaryDetector) K3sBin() string {
@@ -20,6 +21,10 @@ func (m *MockBinaryDetector) K0sBin() string {
 	return m.k0sBin
 }
 
+func (m *MockBinaryDetector) RKE2Bin() string {
+	return m.rke2Bin
+}
+
 var _ = Describe("NewK8sNode", func() {
 	Context("explicit k8s configuration", func() {
 		It("should return error when k3s is explicitly disabled", func() {
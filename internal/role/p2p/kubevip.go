@@ -3,9 +3,9 @@ package role
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
-	"os"
 	"reflect"
 	"strings"
 
@@ -33,30 +33,33 @@ func generateKubeVIP(command string, iface, ip string, kConfig *providerConfig.C
 	// ip is the VIP address
 	var err error
 
+	// Start from a copy, so the manifests generated before don't leak into this one
+	config := initConfig
+
 	// Set the kube-vip config based on the provider config and what we loaded from config files
-	applyKConfigToInitConfig(kConfig.KubeVIP, &initConfig)
+	applyKConfigToInitConfig(kConfig.KubeVIP, &config)
 
 	// Now set the values coming from env vars
-	if err := kubevip.ParseEnvironment(&initConfig); err != nil {
+	if err := kubevip.ParseEnvironment(&config); err != nil {
 		return "", fmt.Errorf("parsing environment: %w", err)
 	}
 
 	// Now the manual ones that are hardcoded by us
-	initConfig.Interface = iface
-	initConfig.Address = ip
-	initConfig.EnableControlPlane = true
-	initConfig.EnableARP = true
-	initConfig.EnableLeaderElection = true
-	initConfig.LoadBalancers = append(initConfig.LoadBalancers, initLoadBalancer)
+	config.Interface = iface
+	config.Address = ip
+	config.EnableControlPlane = true
+	config.EnableARP = true
+	config.EnableLeaderElection = true
+	config.LoadBalancers = append(config.LoadBalancers, initLoadBalancer)
 
 	// The control plane has a requirement for a VIP being specified.
-	if initConfig.EnableControlPlane && (initConfig.VIP == "" && initConfig.Address == "" && !initConfig.DDNS) {
+	if config.EnableControlPlane && (config.VIP == "" && config.Address == "" && !config.DDNS) {
 		return "", fmt.Errorf("no address is specified for kube-vip to expose services on")
 	}
 
 	// Ensure there is an address to generate the CIDR from.
-	if initConfig.VIPSubnet == "" && initConfig.Address != "" {
-		initConfig.VIPSubnet, err = GenerateCidrRange(initConfig.Address)
+	if config.VIPSubnet == "" && config.Address != "" {
+		config.VIPSubnet, err = GenerateCidrRange(config.Address)
 		if err != nil {
 			return "", fmt.Errorf("config parse: %w", err)
 		}
@@ -74,27 +77,27 @@ func generateKubeVIP(command string, iface, ip string, kConfig *providerConfig.C
 	}
 
 	// Some fixes for the default values if they are empty.
-	if initConfig.LeaseDuration == 0 {
-		initConfig.LeaseDuration = 5
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = 5
 	}
-	if initConfig.RenewDeadline == 0 {
-		initConfig.RenewDeadline = 3
+	if config.RenewDeadline == 0 {
+		config.RenewDeadline = 3
 	}
-	if initConfig.RetryPeriod == 0 {
-		initConfig.RetryPeriod = 1
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = 1
 	}
-	if initConfig.PrometheusHTTPServer == "" {
-		initConfig.PrometheusHTTPServer = ":2112"
+	if config.PrometheusHTTPServer == "" {
+		config.PrometheusHTTPServer = ":2112"
 	}
-	if initConfig.Port == 0 {
-		initConfig.Port = 6443
+	if config.Port == 0 {
+		config.Port = 6443
 	}
 
 	switch strings.ToLower(command) {
 	case "daemonset":
-		return kubevip.GenerateDaemonsetManifestFromConfig(&initConfig, kubeVipImage, kubeVipVersion, true, true)
+		return kubevip.GenerateDaemonsetManifestFromConfig(&config, kubeVipImage, kubeVipVersion, true, true)
 	case "pod":
-		return kubevip.GeneratePodManifestFromConfig(&initConfig, kubeVipImage, kubeVipVersion, true)
+		return kubevip.GeneratePodManifestFromConfig(&config, kubeVipImage, kubeVipVersion, true)
 	}
 	return "", fmt.Errorf("unknown manifest type %s", command)
 }
@@ -146,30 +149,20 @@ func applyKConfigToInitConfig(kConfig providerConfig.KubeVIP, initConfig *kubevi
 	}
 }
 
-func downloadFromURL(url, where string) error {
-	output, err := os.Create(where)
-	if err != nil {
-		return err
-	}
-	defer output.Close()
-
+func downloadFromURL(url string) ([]byte, error) {
 	response, err := http.Get(url)
 	if err != nil {
-		return err
-
+		return nil, err
 	}
 	defer response.Body.Close()
 
-	_, err = io.Copy(output, response.Body)
-	return err
+	return io.ReadAll(response.Body)
 }
 
-func deployKubeVIP(iface, ip string, pconfig *providerConfig.Config) error {
-	manifestDirectory := "/var/lib/rancher/k3s/server/manifests/"
-	if pconfig.K3sAgent.IsEnabled() {
-		manifestDirectory = "/var/lib/rancher/k3s/agent/pod-manifests/"
-	}
-	if err := os.MkdirAll(manifestDirectory, 0650); err != nil {
+// deployKubeVIP writes the kube-vip manifests to the directory the distribution
+// picks them up from.
+func deployKubeVIP(h Host, iface, ip, manifestDirectory string, pconfig *providerConfig.Config) error {
+	if err := h.MkdirAll(manifestDirectory, 0650); err != nil {
 		return fmt.Errorf("could not create manifest dir")
 	}
 
@@ -181,27 +174,21 @@ func deployKubeVIP(iface, ip string, pconfig *providerConfig.Config) error {
 		command = "pod"
 	}
 
+	var rbac []byte
+	var err error
 	if pconfig.KubeVIP.ManifestURL != "" {
-		err := downloadFromURL(pconfig.KubeVIP.ManifestURL, targetCRDFile)
-		if err != nil {
-			return err
-		}
+		rbac, err = downloadFromURL(pconfig.KubeVIP.ManifestURL)
 	} else {
-		f, err := assets.GetStaticFS().Open("kube_vip_rbac.yaml")
+		rbac, err = fs.ReadFile(assets.GetStaticFS(), "kube_vip_rbac.yaml")
 		if err != nil {
 			return fmt.Errorf("could not find kube_vip in assets")
 		}
-		defer f.Close()
-
-		destination, err := os.Create(targetCRDFile)
-		if err != nil {
-			return err
-		}
-		defer destination.Close()
-		_, err = io.Copy(destination, f)
-		if err != nil {
-			return err
-		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.WriteFile(targetCRDFile, rbac, 0644); err != nil {
+		return err
 	}
 
 	content, err := generateKubeVIP(command, iface, ip, pconfig)
@@ -209,13 +196,8 @@ func deployKubeVIP(iface, ip string, pconfig *providerConfig.Config) error {
 		return fmt.Errorf("could not generate kubevip %s", err.Error())
 	}
 
-	f, err := os.Create(targetFile)
-	if err != nil {
-		return fmt.Errorf("could not open %s: %w", f.Name(), err)
-	}
-	defer f.Close()
-	if _, err := f.WriteString(content); err != nil {
-		return fmt.Errorf("could not write to %s: %w", f.Name(), err)
+	if err := h.WriteFile(targetFile, []byte(content), 0644); err != nil {
+		return fmt.Errorf("could not write to %s: %w", targetFile, err)
 	}
 
 	return nil
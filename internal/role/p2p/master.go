@@ -8,6 +8,7 @@ import (
 
 	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
 	"github.com/kairos-io/kairos-sdk/utils"
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
 	"github.com/kairos-io/provider-kairos/v2/internal/role"
 
@@ -16,6 +17,7 @@ import (
 
 func propagateMasterData(role string, k K8sNode) error {
 	c := k.RoleConfig()
+	l := k.Ledger()
 	defer func() {
 		// Avoid polluting the API.
 		// The ledger already retries in the background to update the blockchain, but it has
@@ -26,7 +28,7 @@ func propagateMasterData(role string, k K8sNode) error {
 	}()
 
 	// If we are configured as master, always signal our role
-	if err := c.Client.Set("role", c.UUID, role); err != nil {
+	if err := l.Set("role", c.UUID, role); err != nil {
 		c.Logger.Error(err)
 		return err
 	}
@@ -35,15 +37,32 @@ func propagateMasterData(role string, k K8sNode) error {
 		return nil
 	}
 
+	// Only one master publishes the control plane data. It can change hands
+	// when the leader fails over to another master.
+	owner, _ := l.Get("master", "uuid")
+	if owner != "" && owner != c.UUID {
+		c.Logger.Infof("Master data is published by '%s', skipping", owner)
+		return nil
+	}
+	if owner == "" {
+		if err := l.Set("master", "uuid", c.UUID); err != nil {
+			c.Logger.Error(err)
+		}
+	}
+
 	err := k.PropagateData()
 	if err != nil {
 		c.Logger.Error(err)
 	}
 
-	err = c.Client.Set("master", "ip", k.IP())
+	err = l.Set("master", "ip", k.IP())
 	if err != nil {
 		c.Logger.Error(err)
 	}
+
+	if err := publishJoinTokens(c, l, k.IP(), joinTokenTTL(k), time.Now(), k.JoinToken, k.RevokeJoinToken); err != nil {
+		c.Logger.Error(err)
+	}
 	return nil
 }
 
@@ -65,7 +84,7 @@ func waitForMasterHAInfo(m K8sNode) bool {
 		c.Logger.Info("the nodetoken is not there yet..")
 		return true
 	}
-	clusterInitIP, _ := c.Client.Get("master", "ip")
+	clusterInitIP, _ := m.Ledger().Get("master", "ip")
 	if clusterInitIP == "" {
 		c.Logger.Info("the clusterInitIP is not there yet..")
 		return true
@@ -75,15 +94,25 @@ func waitForMasterHAInfo(m K8sNode) bool {
 }
 
 func Master(cc *sdkConfig.Config, pconfig *providerConfig.Config, roleName string) role.Role { //nolint:revive
+	ledgerFor, err := role.ConfiguredLedger(pconfig)
+	if err != nil {
+		return func(*service.RoleConfig) error { return err }
+	}
+	return MasterWithLedger(cc, pconfig, roleName, ledgerFor)
+}
+
+// MasterWithLedger returns the Master role coordinating through a custom ledger.
+func MasterWithLedger(cc *sdkConfig.Config, pconfig *providerConfig.Config, roleName string, ledgerFor role.LedgerProvider) role.Role { //nolint:revive
 	return func(c *service.RoleConfig) error {
 		c.Logger.Info(fmt.Sprintf("Starting Master(%s)", roleName))
+		l := ledgerFor(c)
 
 		ip := guessIP(pconfig)
 		// If we don't have an IP, we sit and wait
 		if ip == "" {
 			return errors.New("node doesn't have an ip yet")
 		}
-		if err := c.Client.Set("ip", c.UUID, ip); err != nil {
+		if err := l.Set("ip", c.UUID, ip); err != nil {
 			c.Logger.Error(err)
 		}
 
@@ -93,7 +122,7 @@ func Master(cc *sdkConfig.Config, pconfig *providerConfig.Config, roleName strin
 			c.Logger.Info(fmt.Sprintf("Setting role from configuration: %s", pconfig.P2P.Role))
 			// propagate role if we were forced by configuration
 			// This unblocks eventual auto instances to try to assign roles
-			if err := c.Client.Set("role", c.UUID, pconfig.P2P.Role); err != nil {
+			if err := l.Set("role", c.UUID, pconfig.P2P.Role); err != nil {
 				c.Logger.Error(err)
 			}
 		}
@@ -106,88 +135,124 @@ func Master(cc *sdkConfig.Config, pconfig *providerConfig.Config, roleName strin
 
 		node.SetRole(roleName)
 		node.SetRoleConfig(c)
+		node.SetLedger(l)
 		node.SetIP(ip)
 		node.GuessInterface()
 
 		c.Logger.Info("Verifying sentinel file")
 		if role.SentinelExist() {
+			if err := upgradeNode(node, false); err != nil {
+				c.Logger.Error(err)
+			}
+			if err := reconcileDeployedMaster(node); err != nil {
+				c.Logger.Errorf("Failed reconciling the config: %s", err.Error())
+			}
 			c.Logger.Info("Node already configured, propagating master data and backing off")
 			return propagateMasterData(roleName, node)
 		}
 
-		c.Logger.Info("Checking HA")
-		if node.HA() && !node.ClusterInit() && waitForMasterHAInfo(node) {
-			return nil
-		}
-
-		c.Logger.Info("Generating env")
-		env := node.GenerateEnv()
-
-		// Configure k8s service to start on edgevpn0
-		c.Logger.Info(fmt.Sprintf("Configuring %s", node.Distro()))
-
-		c.Logger.Info("Running bootstrap before stage")
-		utils.SH(fmt.Sprintf("kairos-agent run-stage provider-kairos.bootstrap.before.%s", roleName)) //nolint:errcheck
-
 		svc, err := node.Service()
 		if err != nil {
 			return fmt.Errorf("failed to get %s service: %w", node.Distro(), err)
 		}
 
-		c.Logger.Info("Writing service Env %s")
-		envUnit := node.EnvUnit()
-		if err := utils.WriteEnv(envUnit,
-			env,
-		); err != nil {
-			return fmt.Errorf("failed to write the %s service: %w", node.Distro(), err)
+		err = role.RunSteps(role.BootstrapStateFile, roleName, []role.Step{
+			{Name: "wait-cluster-init", Run: func() error {
+				if node.HA() && !node.ClusterInit() && waitForMasterHAInfo(node) {
+					return role.StepPending("the cluster init master didn't publish its data yet")
+				}
+				return nil
+			}},
+			{Name: "before-stage", Run: func() error {
+				c.Logger.Info("Running bootstrap before stage")
+				utils.SH(fmt.Sprintf("kairos-agent run-stage provider-kairos.bootstrap.before.%s", roleName)) //nolint:errcheck
+				return nil
+			}},
+			{Name: "env", Run: func() error {
+				c.Logger.Info("Writing service env")
+				if err := WriteEnv(node.Host(), node.EnvUnit(), node.GenerateEnv()); err != nil {
+					return fmt.Errorf("failed to write the %s service: %w", node.Distro(), err)
+				}
+				return nil
+			}},
+			{Name: "kube-vip", Run: func() error {
+				if !node.ProviderConfig().KubeVIP.IsEnabled() {
+					return nil
+				}
+				c.Logger.Info("Configuring KubeVIP")
+				if err := node.DeployKubeVIP(); err != nil {
+					return fmt.Errorf("failed KubeVIP setup: %w", err)
+				}
+				return nil
+			}},
+			{Name: "service-override", Run: func() error {
+				c.Logger.Info("Generating args")
+				args, err := node.GenArgs()
+				if err != nil {
+					return fmt.Errorf("failed to generate %s args: %w", node.Distro(), err)
+				}
+
+				k8sBin := node.K8sBin()
+				if k8sBin == "" {
+					return fmt.Errorf("no %s binary found (?)", node.Distro())
+				}
+
+				c.Logger.Info("Writing service override")
+				if err := svc.OverrideCmd(fmt.Sprintf("%s %s %s", k8sBin, node.Role(), strings.Join(args, " "))); err != nil {
+					return fmt.Errorf("failed to override %s command: %w", node.Distro(), err)
+				}
+				return nil
+			}},
+			{Name: "verify-airgap", Run: func() error {
+				if err := installer.VerifyAirgap(); err != nil {
+					return fmt.Errorf("failed verifying the airgap images: %w", err)
+				}
+				return nil
+			}},
+			{Name: "start", Run: func() error {
+				c.Logger.Info("Starting service")
+				if err := svc.Start(); err != nil {
+					return fmt.Errorf("failed to start %s service: %w", node.Distro(), err)
+				}
+				return nil
+			}},
+			{Name: "enable", Run: func() error {
+				c.Logger.Info("Enabling service")
+				if err := svc.Enable(); err != nil {
+					return fmt.Errorf("failed to enable %s service: %w", node.Distro(), err)
+				}
+				return nil
+			}},
+			{Name: "propagate", Run: func() error {
+				c.Logger.Info("Propagating master data")
+				if err := propagateMasterData(roleName, node); err != nil {
+					return fmt.Errorf("failed to propagate master data: %w", err)
+				}
+				return nil
+			}},
+			{Name: "after-stage", Run: func() error {
+				c.Logger.Info("Running after bootstrap stage")
+				utils.SH(fmt.Sprintf("kairos-agent run-stage provider-kairos.bootstrap.after.%s", roleName)) //nolint:errcheck
+				return nil
+			}},
+			{Name: "record-config", Run: func() error {
+				desired, err := desiredMasterState(node)
+				if err != nil {
+					return err
+				}
+				return recordConfig(node.Host(), desired)
+			}},
+			{Name: "sentinel", Run: func() error {
+				c.Logger.Info("Creating sentinel")
+				if err := role.CreateSentinel(); err != nil {
+					return fmt.Errorf("failed to create sentinel: %w", err)
+				}
+				return nil
+			}},
+		})
+		if perr := role.ReportProgress(l, c.UUID); perr != nil {
+			c.Logger.Warnf("Failed publishing the bootstrap progress: %s", perr.Error())
 		}
-
-		c.Logger.Info("Generating args")
-		args, err := node.GenArgs()
-		if err != nil {
-			return fmt.Errorf("failed to generate %s args: %w", node.Distro(), err)
-		}
-
-		if node.ProviderConfig().KubeVIP.IsEnabled() {
-			c.Logger.Info("Configuring KubeVIP")
-			if err := node.DeployKubeVIP(); err != nil {
-				return fmt.Errorf("failed KubeVIP setup: %w", err)
-			}
-		}
-
-		k8sBin := node.K8sBin()
-		if k8sBin == "" {
-			return fmt.Errorf("no %s binary found (?)", node.Distro())
-		}
-
-		c.Logger.Info("Writing service override")
-		if err := svc.OverrideCmd(fmt.Sprintf("%s %s %s", k8sBin, node.Role(), strings.Join(args, " "))); err != nil {
-			return fmt.Errorf("failed to override %s command: %w", node.Distro(), err)
-		}
-
-		c.Logger.Info("Starting service")
-		if err := svc.Start(); err != nil {
-			return fmt.Errorf("failed to start %s service: %w", node.Distro(), err)
-		}
-
-		c.Logger.Info("Enabling service")
-		if err := svc.Enable(); err != nil {
-			return fmt.Errorf("failed to enable %s service: %w", node.Distro(), err)
-		}
-
-		c.Logger.Info("Propagating master data")
-		if err := propagateMasterData(roleName, node); err != nil {
-			return fmt.Errorf("failed to propagate master data: %w", err)
-		}
-
-		c.Logger.Info("Running after bootstrap stage")
-		utils.SH(fmt.Sprintf("kairos-agent run-stage provider-kairos.bootstrap.after.%s", roleName)) //nolint:errcheck
-
-		c.Logger.Info("Creating sentinel")
-		if err := role.CreateSentinel(); err != nil {
-			return fmt.Errorf("failed to create sentinel: %w", err)
-		}
-
-		return nil
+		return err
 	}
 }
@@ -0,0 +1,207 @@
+package role
+
+import (
+	"fmt"
+	"strings"
+
+	logging "github.com/ipfs/go-log"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+)
+
+// Placeholders stand for the data other nodes publish at runtime.
+const (
+	PlanMasterIP        = "<master-ip>"
+	PlanNodeToken       = "<node-token>"
+	PlanControllerToken = "<controller-token>"
+	PlanJoinToken       = "<join-token>"
+	PlanInterface       = "<interface>"
+)
+
+// PlanOptions describe the node a plan is rendered for.
+type PlanOptions struct {
+	UUID     string
+	Hostname string
+	// IP is the address of every interface of the node
+	IP       string
+	Detector BinaryDetector
+	// Host is written to, a new one is used if nil
+	Host *MemoryHost
+}
+
+// NodePlan is what a node would write and run to bootstrap a role.
+type NodePlan struct {
+	Role    string `json:"role" yaml:"role"`
+	Distro  string `json:"distro,omitempty" yaml:"distro,omitempty"`
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Files are the files written, by path
+	Files map[string]string `json:"files" yaml:"files"`
+	// Error is why the bootstrap would stop
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// IsStandalone returns true if the config deploys Kubernetes once, without
+// coordinating with other nodes.
+func IsStandalone(c *providerConfig.Config) bool {
+	tokenNotDefined := c.P2P == nil || c.P2P.NetworkToken == ""
+	skipAuto := c.P2P != nil && !c.P2P.Auto.IsEnabled()
+	return (tokenNotDefined && c.IsKubernetesConfigured()) || skipAuto
+}
+
+// PlanRoles returns the roles the node could get with the config, and why.
+func PlanRoles(c *providerConfig.Config, opts PlanOptions) ([]string, string) {
+	masters := []string{RoleMaster}
+	if c.P2P.Auto.HA.IsEnabled() {
+		masters = []string{RoleMasterClusterInit, RoleMasterHA}
+	}
+
+	if c.P2P.Role != "" {
+		return []string{c.P2P.Role}, "set by p2p.role"
+	}
+
+	pinsMasters := false
+	for i, p := range c.P2P.Auto.Placement {
+		pinsMasters = pinsMasters || p.Role == RoleMaster
+		if !p.Matches(opts.UUID, opts.Hostname, c.P2P.Labels) {
+			continue
+		}
+		reason := fmt.Sprintf("pinned by p2p.auto.placement[%d]", i)
+		if p.Role == RoleMaster {
+			return masters, reason
+		}
+		return []string{p.Role}, reason
+	}
+
+	if c.P2P.ControlPlaneEligible != nil && !*c.P2P.ControlPlaneEligible {
+		return []string{RoleWorker}, "the node opted out of the control plane"
+	}
+	if pinsMasters {
+		return []string{RoleWorker}, "the control plane is pinned to other nodes"
+	}
+	return append(masters, RoleWorker), "assigned by the leader"
+}
+
+func planBin(d BinaryDetector, distro string) string {
+	switch distro {
+	case K3sDistroName:
+		return d.K3sBin()
+	case K0sDistroName:
+		return d.K0sBin()
+	case RKE2DistroName:
+		return d.RKE2Bin()
+	}
+	return ""
+}
+
+func (p NodePlan) render(node K8sNode, h *MemoryHost, bin, role string, args []string, err error) NodePlan {
+	p.Distro, p.Service = node.Distro(), node.ServiceName()
+	if bin != "" && role != "" {
+		p.Command = strings.TrimSpace(fmt.Sprintf("%s %s %s", bin, role, strings.Join(args, " ")))
+	}
+	p.Files = map[string]string{}
+	for _, name := range h.Files() {
+		data, _ := h.ReadFile(name)
+		p.Files[name] = string(data)
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	return p
+}
+
+// PlanStandalone renders what a node deploying Kubernetes without p2p would
+// write and run, against an in-memory host.
+func PlanStandalone(c *providerConfig.Config, opts PlanOptions) (NodePlan, error) {
+	node, err := NewK8sNodeWithDetector(c, opts.Detector)
+	if err != nil {
+		return NodePlan{}, err
+	}
+	h := opts.Host
+	if h == nil {
+		h = NewMemoryHost(opts.IP)
+	}
+	node.SetHost(h)
+
+	plan := NodePlan{Role: "standalone " + node.Role()}
+	bin := planBin(opts.Detector, node.Distro())
+	if err := WriteEnv(h, node.EnvFile(), node.Env()); err != nil {
+		return plan.render(node, h, bin, node.Role(), node.Args(), err), nil
+	}
+	if n, ok := node.(interface{ WriteStandaloneConfig() error }); ok {
+		if err := n.WriteStandaloneConfig(); err != nil {
+			return plan.render(node, h, bin, node.Role(), node.Args(), err), nil
+		}
+	}
+	return plan.render(node, h, bin, node.Role(), node.Args(), nil), nil
+}
+
+// Plan renders what the node would write and run to bootstrap the given
+// role, against an in-memory host and ledger. The data published by other
+// nodes is replaced by placeholders. Nothing is written to the machine and
+// no service is touched.
+func Plan(c *providerConfig.Config, roleName string, opts PlanOptions) (NodePlan, error) {
+	node, err := NewK8sNodeWithDetector(c, opts.Detector)
+	if err != nil {
+		return NodePlan{}, err
+	}
+	h := opts.Host
+	if h == nil {
+		h = NewMemoryHost(opts.IP)
+	}
+
+	l := ledger.NewMemoryNetwork(opts.UUID).Node(opts.UUID)
+	for _, kv := range [][3]string{
+		{"master", "ip", PlanMasterIP},
+		{"nodetoken", "token", PlanNodeToken},
+		{"controllertoken", "token", PlanControllerToken},
+	} {
+		if err := l.Set(kv[0], kv[1], kv[2]); err != nil {
+			return NodePlan{}, err
+		}
+	}
+	if roleName == RoleWorker {
+		for _, p := range c.P2P.Auto.WorkerPools {
+			if p.Matches(opts.UUID, opts.Hostname, c.P2P.Labels) {
+				if err := l.Set("pool", opts.UUID, p.Name); err != nil {
+					return NodePlan{}, err
+				}
+				break
+			}
+		}
+	}
+
+	ip := opts.IP
+	if c.KubeVIP.EIP != "" {
+		ip = c.KubeVIP.EIP
+	}
+	node.SetRole(roleName)
+	node.SetRoleConfig(&service.RoleConfig{UUID: opts.UUID, Logger: logging.Logger("plan")})
+	node.SetLedger(l)
+	node.SetHost(h)
+	node.SetIP(ip)
+	node.GuessInterface()
+
+	plan := NodePlan{Role: roleName}
+	bin := planBin(opts.Detector, node.Distro())
+
+	if roleName == RoleWorker {
+		if err := node.SetupWorker(PlanMasterIP, PlanJoinToken); err != nil {
+			return plan.render(node, h, "", "", nil, err), nil
+		}
+		args, err := node.WorkerArgs()
+		return plan.render(node, h, bin, node.Role(), args, err), nil
+	}
+
+	if err := WriteEnv(h, node.EnvUnit(), node.GenerateEnv()); err != nil {
+		return plan.render(node, h, "", "", nil, err), nil
+	}
+	if c.KubeVIP.IsEnabled() {
+		if err := node.DeployKubeVIP(); err != nil {
+			return plan.render(node, h, "", "", nil, fmt.Errorf("failed KubeVIP setup: %w", err)), nil
+		}
+	}
+	args, err := node.GenArgs()
+	return plan.render(node, h, bin, node.Role(), args, err), nil
+}
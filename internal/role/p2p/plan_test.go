@@ -0,0 +1,145 @@
+package role
+
+import (
+	logging "github.com/ipfs/go-log"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type planDetector struct{}
+
+func (planDetector) K3sBin() string  { return "/usr/bin/k3s" }
+func (planDetector) K0sBin() string  { return "" }
+func (planDetector) RKE2Bin() string { return "" }
+
+type k0sPlanDetector struct{}
+
+func (k0sPlanDetector) K3sBin() string  { return "" }
+func (k0sPlanDetector) K0sBin() string  { return "/usr/bin/k0s" }
+func (k0sPlanDetector) RKE2Bin() string { return "" }
+
+var _ = Describe("Plan", func() {
+	var (
+		opts    PlanOptions
+		enabled = true
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("plan", "fatal") //nolint:errcheck
+		opts = PlanOptions{UUID: "node", Hostname: "edge-1", IP: "10.1.0.2", Detector: planDetector{}}
+	})
+
+	It("merges env files like the agent does", func() {
+		h := NewMemoryHost("10.1.0.2")
+		Expect(h.WriteFile("/etc/sysconfig/k3s", []byte("A=1\nB=2\n"), 0644)).To(Succeed())
+		Expect(WriteEnv(h, "/etc/sysconfig/k3s", map[string]string{"B": "3", "C": "x y"})).To(Succeed())
+
+		data, err := h.ReadFile("/etc/sysconfig/k3s")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("A=1\nB=3\nC=\"x y\"\n"))
+		Expect(h.Files()).To(Equal([]string{"/etc/sysconfig/k3s"}))
+	})
+
+	It("renders a worker joining through placeholders", func() {
+		c := &providerConfig.Config{P2P: &providerConfig.P2P{
+			NetworkToken: "token",
+			Labels:       map[string]string{"tier": "edge"},
+			Auto: providerConfig.Auto{WorkerPools: []providerConfig.WorkerPool{{
+				Name:         "edge",
+				NodeSelector: providerConfig.NodeSelector{Hostname: "edge-*"},
+				NodeLabels:   map[string]string{"site": "a"},
+			}}},
+		}}
+
+		plan, err := Plan(c, RoleWorker, opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Error).To(BeEmpty())
+		Expect(plan.Service).To(Equal(K3sWorkerServiceName))
+		Expect(plan.Command).To(HavePrefix("/usr/bin/k3s agent --with-node-id --node-ip 10.1.0.2"))
+		Expect(plan.Command).To(HaveSuffix("--node-label site=a"))
+
+		files := []string{}
+		for name, content := range plan.Files {
+			files = append(files, name)
+			Expect(content).To(ContainSubstring(`K3S_URL="https://<master-ip>:6443"`))
+			Expect(content).To(ContainSubstring(`K3S_TOKEN="<join-token>"`))
+		}
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("renders the kube-vip manifests of masters", func() {
+		c := &providerConfig.Config{
+			P2P:     &providerConfig.P2P{NetworkToken: "token"},
+			KubeVIP: providerConfig.KubeVIP{EIP: "10.1.0.100", Interface: "eth0"},
+		}
+
+		plan, err := Plan(c, RoleMaster, opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Error).To(BeEmpty())
+		Expect(plan.Command).To(ContainSubstring("--tls-san=10.1.0.100"))
+		Expect(plan.Files).To(HaveKey("/var/lib/rancher/k3s/server/manifests/kubevip.yaml"))
+		Expect(plan.Files).To(HaveKey("/var/lib/rancher/k3s/server/manifests/kubevipmanifest.yaml"))
+	})
+
+	It("renders k0s controllers without running k0s", func() {
+		opts.Detector = k0sPlanDetector{}
+		c := &providerConfig.Config{
+			P2P:     &providerConfig.P2P{NetworkToken: "token"},
+			KubeVIP: providerConfig.KubeVIP{EIP: "10.1.0.100"},
+		}
+
+		plan, err := Plan(c, RoleMaster, opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Error).To(BeEmpty())
+		Expect(plan.Command).To(HavePrefix("/usr/bin/k0s controller --config /etc/k0s/k0s.yaml"))
+		Expect(plan.Files).To(HaveKeyWithValue("/etc/k0s/k0s.yaml", ContainSubstring("address: 10.1.0.2")))
+		Expect(plan.Files).To(HaveKeyWithValue("/etc/k0s/k0s.yaml", ContainSubstring("externalAddress: 10.1.0.100")))
+		// The interface is not the one of the machine rendering the plan
+		Expect(plan.Files).To(HaveKeyWithValue("/var/lib/k0s/manifests/kube-vip/kubevip.yaml", ContainSubstring(PlanInterface)))
+	})
+
+	It("renders standalone nodes", func() {
+		c := &providerConfig.Config{K3sAgent: providerConfig.K3s{
+			Enabled: &enabled,
+			Args:    []string{"--node-label=tier=edge"},
+			Env:     map[string]string{"K3S_URL": "https://10.0.0.1:6443"},
+		}}
+		Expect(IsStandalone(c)).To(BeTrue())
+
+		plan, err := PlanStandalone(c, opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Command).To(Equal("/usr/bin/k3s agent --node-label=tier=edge"))
+		Expect(plan.Files).To(HaveLen(1))
+	})
+
+	Context("roles", func() {
+		It("lists every role the leader could assign", func() {
+			masters := 2
+			c := &providerConfig.Config{P2P: &providerConfig.P2P{Auto: providerConfig.Auto{HA: providerConfig.HA{MasterNodes: &masters}}}}
+			roles, _ := PlanRoles(c, opts)
+			Expect(roles).To(Equal([]string{RoleMasterClusterInit, RoleMasterHA, RoleWorker}))
+		})
+
+		It("follows the placement rules", func() {
+			c := &providerConfig.Config{P2P: &providerConfig.P2P{Auto: providerConfig.Auto{Placement: []providerConfig.Placement{
+				{Role: RoleMaster, NodeSelector: providerConfig.NodeSelector{Hostname: "cp-*"}},
+			}}}}
+			roles, reason := PlanRoles(c, opts)
+			Expect(roles).To(Equal([]string{RoleWorker}))
+			Expect(reason).To(Equal("the control plane is pinned to other nodes"))
+
+			opts.Hostname = "cp-1"
+			roles, reason = PlanRoles(c, opts)
+			Expect(roles).To(Equal([]string{RoleMaster}))
+			Expect(reason).To(Equal("pinned by p2p.auto.placement[0]"))
+		})
+
+		It("takes the static role", func() {
+			c := &providerConfig.Config{P2P: &providerConfig.P2P{Role: RoleWorker}}
+			roles, reason := PlanRoles(c, opts)
+			Expect(roles).To(Equal([]string{RoleWorker}))
+			Expect(reason).To(Equal("set by p2p.role"))
+		})
+	})
+})
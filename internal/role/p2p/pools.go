@@ -0,0 +1,36 @@
+package role
+
+import (
+	"fmt"
+	"sort"
+
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+)
+
+// workerPool returns the worker pool the leader assigned the node to, if
+// it is configured.
+func workerPool(k K8sNode) (providerConfig.WorkerPool, bool) {
+	l, c, pconfig := k.Ledger(), k.RoleConfig(), k.ProviderConfig()
+	if l == nil || c == nil || pconfig.P2P == nil {
+		return providerConfig.WorkerPool{}, false
+	}
+	name, _ := l.Get("pool", c.UUID)
+	if name == "" {
+		return providerConfig.WorkerPool{}, false
+	}
+	pool, ok := pconfig.P2P.Auto.WorkerPool(name)
+	if !ok && c.Logger != nil {
+		c.Logger.Warnf("Worker pool '%s' is not configured, ignoring it", name)
+	}
+	return pool, ok
+}
+
+// nodeLabels returns the labels as key=value pairs, sorted by key.
+func nodeLabels(labels map[string]string) []string {
+	pairs := []string{}
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
@@ -0,0 +1,87 @@
+package role
+
+import (
+	logging "github.com/ipfs/go-log"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Worker pools", func() {
+	var (
+		l       ledger.Ledger
+		c       *service.RoleConfig
+		pconfig *providerConfig.Config
+		noVPN   = false
+	)
+
+	BeforeEach(func() {
+		l = ledger.NewMemoryNetwork("node").Node("node")
+		c = &service.RoleConfig{UUID: "node", Logger: logging.Logger("p2p-test")}
+		pconfig = &providerConfig.Config{P2P: &providerConfig.P2P{
+			VPN: providerConfig.VPN{Create: &noVPN},
+			Auto: providerConfig.Auto{WorkerPools: []providerConfig.WorkerPool{{
+				Name:       "storage",
+				Args:       []string{"--kubelet-arg=max-pods=50"},
+				NodeLabels: map[string]string{"tier": "storage", "disk": "ssd"},
+				Taints:     []string{"storage=true:NoSchedule"},
+			}}},
+		}}
+		Expect(l.Set("pool", "node", "storage")).To(Succeed())
+	})
+
+	It("renders the pool flags of k3s agents", func() {
+		node := &K3sNode{providerConfig: pconfig, role: RoleWorker}
+		node.SetLedger(l)
+		node.SetRoleConfig(c)
+
+		args, err := node.WorkerArgs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(ContainElements(
+			"--node-label disk=ssd",
+			"--node-label tier=storage",
+			"--node-taint storage=true:NoSchedule",
+			"--kubelet-arg=max-pods=50",
+		))
+	})
+
+	It("renders the pool flags of k0s workers", func() {
+		node := &K0sNode{providerConfig: pconfig, role: RoleWorker}
+		node.SetLedger(l)
+		node.SetRoleConfig(c)
+
+		args, err := node.WorkerArgs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(Equal([]string{
+			"--token-file /etc/k0s/token",
+			"--labels=disk=ssd,tier=storage",
+			"--taints=storage=true:NoSchedule",
+			"--kubelet-arg=max-pods=50",
+		}))
+	})
+
+	It("adds the pool labels and taints to the rke2 agent config", func() {
+		pconfig.RKE2Agent = providerConfig.RKE2{Config: map[string]interface{}{"node-label": []string{"site=a"}}}
+		node := &RKE2Node{providerConfig: pconfig, role: RoleWorker}
+		node.SetLedger(l)
+		node.SetRoleConfig(c)
+
+		config, err := node.AgentConfig("10.1.0.1", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(HaveKeyWithValue("node-label", []interface{}{"site=a", "disk=ssd", "tier=storage"}))
+		Expect(config).To(HaveKeyWithValue("node-taint", []interface{}{"storage=true:NoSchedule"}))
+	})
+
+	It("ignores pools which are not configured", func() {
+		Expect(l.Set("pool", "node", "gpu")).To(Succeed())
+		node := &K0sNode{providerConfig: pconfig, role: RoleWorker}
+		node.SetLedger(l)
+		node.SetRoleConfig(c)
+
+		args, err := node.WorkerArgs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(args).To(Equal([]string{"--token-file /etc/k0s/token"}))
+	})
+})
@@ -0,0 +1,240 @@
+package role
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kairos-io/kairos-sdk/machine"
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+)
+
+// appliedConfigFile records the hash of the configuration a deployed node
+// last applied, to detect the drift of the config.
+const appliedConfigFile = "/usr/local/.kairos/applied"
+
+type renderedFile struct {
+	data []byte
+	perm os.FileMode
+}
+
+// renderHost keeps the files written in memory, and reads none of the ones
+// of the host it wraps: the configuration is rendered from scratch, so that
+// the settings removed from the config are dropped from the files.
+type renderHost struct {
+	Host
+	files map[string]renderedFile
+}
+
+func newRenderHost(h Host) *renderHost {
+	return &renderHost{Host: h, files: map[string]renderedFile{}}
+}
+
+func (r *renderHost) ReadFile(name string) ([]byte, error) {
+	if f, ok := r.files[name]; ok {
+		return append([]byte{}, f.data...), nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (r *renderHost) WriteFile(name string, data []byte, perm os.FileMode) error {
+	r.files[name] = renderedFile{data: append([]byte{}, data...), perm: perm}
+	return nil
+}
+
+func (r *renderHost) MkdirAll(string, os.FileMode) error {
+	return nil
+}
+
+// nodeState is the configuration of a deployed node: the files it writes
+// and the command line of its service.
+type nodeState struct {
+	files   map[string]renderedFile
+	command string
+}
+
+// hash sums the files by name, and the command line.
+func (s nodeState) hash() string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(s.files[name].data))
+		h.Write(s.files[name].data)
+	}
+	h.Write([]byte(s.command))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// desiredMasterState renders the configuration of a master with the current
+// config, without writing to the host of the node. Masters joining a HA
+// cluster render the token and the IP of the cluster init master from the
+// ledger, which might not be synced yet right after boot: rendering them
+// empty would be taken for a drift.
+func desiredMasterState(node K8sNode) (nodeState, error) {
+	if node.HA() && !node.ClusterInit() && waitForMasterHAInfo(node) {
+		return nodeState{}, errors.New("the cluster init master data is not in the ledger yet")
+	}
+
+	host := node.Host()
+	render := newRenderHost(host)
+	node.SetHost(render)
+	defer node.SetHost(host)
+
+	if err := WriteEnv(render, node.EnvUnit(), node.GenerateEnv()); err != nil {
+		return nodeState{}, fmt.Errorf("failed to write the %s service: %w", node.Distro(), err)
+	}
+	if node.ProviderConfig().KubeVIP.IsEnabled() {
+		if err := node.DeployKubeVIP(); err != nil {
+			return nodeState{}, fmt.Errorf("failed KubeVIP setup: %w", err)
+		}
+	}
+	args, err := node.GenArgs()
+	if err != nil {
+		return nodeState{}, fmt.Errorf("failed to generate %s args: %w", node.Distro(), err)
+	}
+
+	return renderedState(node, render, args)
+}
+
+// desiredWorkerState renders the configuration of a worker joined through
+// masterIP with the given token, without writing to the host of the node.
+func desiredWorkerState(node K8sNode, masterIP, nodeToken string) (nodeState, error) {
+	host := node.Host()
+	render := newRenderHost(host)
+	node.SetHost(render)
+	defer node.SetHost(host)
+
+	if err := node.SetupWorker(masterIP, nodeToken); err != nil {
+		return nodeState{}, fmt.Errorf("failed to set up the %s worker: %w", node.Distro(), err)
+	}
+	args, err := node.WorkerArgs()
+	if err != nil {
+		return nodeState{}, fmt.Errorf("failed to generate %s args: %w", node.Distro(), err)
+	}
+	return renderedState(node, render, args)
+}
+
+// renderedState returns the state of a node with the files rendered and
+// the args of its service.
+func renderedState(node K8sNode, render *renderHost, args []string) (nodeState, error) {
+	// Upgraded nodes run the binary installed by the upgrade
+	bin := role.UpgradedBin(node.Distro())
+	if bin == "" {
+		bin = node.K8sBin()
+	}
+	if bin == "" {
+		return nodeState{}, fmt.Errorf("no %s binary found (?)", node.Distro())
+	}
+	return nodeState{
+		files:   render.files,
+		command: fmt.Sprintf("%s %s %s", bin, node.Role(), strings.Join(args, " ")),
+	}, nil
+}
+
+// reconcileMaster applies the changes of the config to a deployed master.
+// It returns whether anything changed.
+func reconcileMaster(node K8sNode, svc machine.Service) (bool, error) {
+	desired, err := desiredMasterState(node)
+	if err != nil {
+		return false, err
+	}
+	return reconcile(node, svc, desired)
+}
+
+// reconcileWorker applies the changes of the config to a deployed worker.
+// The config is rendered with the master the worker joined through and the
+// token it was set up with, as join tokens are consumed when used. It
+// returns whether anything changed.
+func reconcileWorker(node K8sNode, svc machine.Service) (bool, error) {
+	dat, err := node.Host().ReadFile(workerMasterFile)
+	masterIP := strings.TrimSpace(string(dat))
+	if err != nil || masterIP == "" {
+		return false, errors.New("the master the worker joined through is not recorded yet")
+	}
+	desired, err := desiredWorkerState(node, masterIP, node.WorkerToken())
+	if err != nil {
+		return false, err
+	}
+	return reconcile(node, svc, desired)
+}
+
+// reconcile brings a deployed node to the desired state: the files which
+// differ from the ones on disk are written again, the service command line
+// is overridden and the service restarted. Nothing is done while the hash
+// of the desired state is the one recorded. Nodes deployed before the
+// config was recorded are restarted once, as the command line they run is
+// not known.
+func reconcile(node K8sNode, svc machine.Service, desired nodeState) (bool, error) {
+	c := node.RoleConfig()
+	host := node.Host()
+	hash := desired.hash()
+
+	changed := []string{}
+	for name, f := range desired.files {
+		if current, err := host.ReadFile(name); err != nil || !bytes.Equal(current, f.data) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	if applied, err := host.ReadFile(appliedConfigFile); err == nil && len(changed) == 0 && string(applied) == hash {
+		return false, nil
+	}
+
+	for _, name := range changed {
+		c.Logger.Infof("Config drifted, writing %s", name)
+		if err := host.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return false, err
+		}
+		if err := host.WriteFile(name, desired.files[name].data, desired.files[name].perm); err != nil {
+			return false, err
+		}
+	}
+	if err := svc.OverrideCmd(desired.command); err != nil {
+		return false, fmt.Errorf("failed to override %s command: %w", node.Distro(), err)
+	}
+	c.Logger.Infof("Config drifted, restarting %s", node.ServiceName())
+	if err := svc.Restart(); err != nil {
+		return false, fmt.Errorf("failed to restart %s service: %w", node.Distro(), err)
+	}
+	return true, recordConfig(host, desired)
+}
+
+// recordConfig records the configuration a node runs with, so that the
+// next reconciliation compares the config against it.
+func recordConfig(host Host, desired nodeState) error {
+	if err := host.MkdirAll(filepath.Dir(appliedConfigFile), 0755); err != nil {
+		return err
+	}
+	return host.WriteFile(appliedConfigFile, []byte(desired.hash()), 0600)
+}
+
+func reconcileDeployedMaster(node K8sNode) error {
+	svc, err := node.Service()
+	if err != nil {
+		return fmt.Errorf("failed to get %s service: %w", node.Distro(), err)
+	}
+	_, err = reconcileMaster(node, svc)
+	return err
+}
+
+func reconcileDeployedWorker(node K8sNode) error {
+	svc, err := node.Service()
+	if err != nil {
+		return fmt.Errorf("failed to get %s service: %w", node.Distro(), err)
+	}
+	_, err = reconcileWorker(node, svc)
+	return err
+}
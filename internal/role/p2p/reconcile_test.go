@@ -0,0 +1,241 @@
+package role
+
+import (
+	logging "github.com/ipfs/go-log"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type recordingService struct {
+	calls []string
+}
+
+func (s *recordingService) WriteUnit() error { s.calls = append(s.calls, "write-unit"); return nil }
+func (s *recordingService) Start() error     { s.calls = append(s.calls, "start"); return nil }
+func (s *recordingService) Enable() error    { s.calls = append(s.calls, "enable"); return nil }
+func (s *recordingService) Restart() error   { s.calls = append(s.calls, "restart"); return nil }
+func (s *recordingService) OverrideCmd(cmd string) error {
+	s.calls = append(s.calls, "override "+cmd)
+	return nil
+}
+
+// k3sBinNode is a k3s node with the binary of the image installed.
+type k3sBinNode struct {
+	K8sNode
+}
+
+func (k3sBinNode) K8sBin() string { return "/usr/bin/k3s" }
+
+var _ = Describe("Config reconciliation", func() {
+	var (
+		h       *MemoryHost
+		svc     *recordingService
+		pconfig *providerConfig.Config
+		node    K8sNode
+		enabled = true
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("reconcile", "fatal") //nolint:errcheck
+		h = NewMemoryHost("10.1.0.2")
+		svc = &recordingService{}
+		pconfig = &providerConfig.Config{
+			P2P: &providerConfig.P2P{NetworkToken: "token"},
+			K3s: providerConfig.K3s{Enabled: &enabled, Env: map[string]string{"A": "1"}},
+		}
+
+		k3s := &K3sNode{providerConfig: pconfig}
+		k3s.SetRole(RoleMaster)
+		k3s.SetRoleConfig(&service.RoleConfig{UUID: "node", Logger: logging.Logger("reconcile")})
+		k3s.SetLedger(ledger.NewMemoryNetwork("node").Node("node"))
+		k3s.SetIP("10.1.0.2")
+		k3s.SetHost(h)
+		node = k3sBinNode{k3s}
+	})
+
+	It("applies the config once", func() {
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(svc.calls).To(HaveLen(2))
+		Expect(svc.calls[0]).To(HavePrefix("override /usr/bin/k3s server"))
+		Expect(svc.calls[1]).To(Equal("restart"))
+		Expect(h.Files()).To(ConsistOf("/etc/sysconfig/k3s", appliedConfigFile))
+
+		svc.calls = nil
+		changed, err = reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(svc.calls).To(BeEmpty())
+	})
+
+	It("rewrites the drifted files and restarts", func() {
+		_, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+
+		svc.calls = nil
+		pconfig.K3s.Env["A"] = "2"
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(svc.calls).To(HaveLen(2))
+		Expect(svc.calls[1]).To(Equal("restart"))
+
+		data, err := h.ReadFile("/etc/sysconfig/k3s")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("A=2\n"))
+	})
+
+	It("overrides the command when the args change", func() {
+		_, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+
+		svc.calls = nil
+		pconfig.K3s.Args = []string{"--disable=traefik"}
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(svc.calls).To(HaveLen(2))
+		Expect(svc.calls[0]).To(HaveSuffix("--disable=traefik"))
+		Expect(svc.calls[1]).To(Equal("restart"))
+	})
+
+	It("writes the kube-vip manifests when it gets enabled", func() {
+		_, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+
+		pconfig.KubeVIP = providerConfig.KubeVIP{EIP: "10.1.0.100", Interface: "eth0"}
+		_, err = reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.Files()).To(ContainElements(
+			"/var/lib/rancher/k3s/server/manifests/kubevip.yaml",
+			"/var/lib/rancher/k3s/server/manifests/kubevipmanifest.yaml",
+		))
+	})
+
+	It("restarts the nodes deployed before the config was recorded", func() {
+		Expect(WriteEnv(h, "/etc/sysconfig/k3s", map[string]string{"A": "1"})).To(Succeed())
+
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(svc.calls).To(HaveLen(2))
+		Expect(svc.calls[0]).To(HavePrefix("override "))
+		Expect(svc.calls[1]).To(Equal("restart"))
+		Expect(h.Files()).To(ContainElement(appliedConfigFile))
+	})
+
+	It("doesn't restart the nodes which recorded their config when deployed", func() {
+		Expect(WriteEnv(h, "/etc/sysconfig/k3s", map[string]string{"A": "1"})).To(Succeed())
+		desired, err := desiredMasterState(node)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recordConfig(h, desired)).To(Succeed())
+
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(svc.calls).To(BeEmpty())
+	})
+
+	It("drops the env removed from the config", func() {
+		pconfig.K3s.Env["B"] = "2"
+		_, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+
+		delete(pconfig.K3s.Env, "B")
+		changed, err := reconcileMaster(node, svc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+
+		data, err := h.ReadFile("/etc/sysconfig/k3s")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("A=1\n"))
+	})
+
+	Context("on a worker", func() {
+		BeforeEach(func() {
+			pconfig.K3sAgent = providerConfig.K3s{Enabled: &enabled, Env: map[string]string{"A": "1"}}
+			k3s := &K3sNode{providerConfig: pconfig}
+			k3s.SetRole(RoleWorker)
+			k3s.SetRoleConfig(&service.RoleConfig{UUID: "node", Logger: logging.Logger("reconcile")})
+			k3s.SetLedger(ledger.NewMemoryNetwork("node").Node("node"))
+			k3s.SetIP("10.1.0.2")
+			k3s.SetHost(h)
+			node = k3sBinNode{k3s}
+		})
+
+		It("waits for the master it joined through to be recorded", func() {
+			changed, err := reconcileWorker(node, svc)
+			Expect(err).To(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(svc.calls).To(BeEmpty())
+		})
+
+		It("applies the config with the token it was set up with", func() {
+			Expect(h.WriteFile(workerMasterFile, []byte("10.1.0.1"), 0600)).To(Succeed())
+			Expect(node.SetupWorker("10.1.0.1", "secret")).To(Succeed())
+			desired, err := desiredWorkerState(node, "10.1.0.1", node.WorkerToken())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recordConfig(h, desired)).To(Succeed())
+
+			changed, err := reconcileWorker(node, svc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+
+			pconfig.K3sAgent.Env["A"] = "2"
+			pconfig.K3sAgent.Args = []string{"--node-label=a=b"}
+			changed, err = reconcileWorker(node, svc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			Expect(svc.calls).To(HaveLen(2))
+			Expect(svc.calls[0]).To(HavePrefix("override /usr/bin/k3s agent"))
+			Expect(svc.calls[0]).To(HaveSuffix("--node-label=a=b"))
+			Expect(svc.calls[1]).To(Equal("restart"))
+
+			data, err := h.ReadFile("/etc/sysconfig/k3s-agent")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("A=2"))
+			Expect(string(data)).To(ContainSubstring(`K3S_TOKEN="secret"`))
+			Expect(string(data)).To(ContainSubstring(`K3S_URL="https://10.1.0.1:6443"`))
+		})
+	})
+
+	Context("joining a HA cluster", func() {
+		var l ledger.Ledger
+
+		BeforeEach(func() {
+			l = ledger.NewMemoryNetwork("node").Node("node")
+			k3s := &K3sNode{providerConfig: pconfig}
+			k3s.SetRole(RoleMasterHA)
+			k3s.SetRoleConfig(&service.RoleConfig{UUID: "node", Logger: logging.Logger("reconcile")})
+			k3s.SetLedger(l)
+			k3s.SetIP("10.1.0.2")
+			k3s.SetHost(h)
+			node = k3sBinNode{k3s}
+		})
+
+		It("doesn't apply the config before the cluster init master data is known", func() {
+			Expect(WriteEnv(h, "/etc/sysconfig/k3s", map[string]string{"A": "1", "K3S_TOKEN": "secret"})).To(Succeed())
+
+			changed, err := reconcileMaster(node, svc)
+			Expect(err).To(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(svc.calls).To(BeEmpty())
+			Expect(h.Files()).To(ConsistOf("/etc/sysconfig/k3s"))
+
+			Expect(l.Set("nodetoken", "token", "secret")).To(Succeed())
+			Expect(l.Set("master", "ip", "10.1.0.1")).To(Succeed())
+			changed, err = reconcileMaster(node, svc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			Expect(svc.calls[0]).To(ContainSubstring("--server=https://10.1.0.1:6443"))
+
+			data, err := h.ReadFile("/etc/sysconfig/k3s")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`K3S_TOKEN="secret"`))
+		})
+	})
+})
@@ -0,0 +1,428 @@
+package role
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/kairos-sdk/machine"
+	"github.com/kairos-io/kairos-sdk/machine/openrc"
+	"github.com/kairos-io/kairos-sdk/machine/systemd"
+	"github.com/kairos-io/kairos-sdk/utils"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RKE2DistroName        = "rke2"
+	RKE2MasterName        = "server"
+	RKE2WorkerName        = "agent"
+	RKE2MasterServiceName = "rke2-server"
+	RKE2WorkerServiceName = "rke2-agent"
+
+	// RKE2SupervisorPort is the port servers accept joining nodes on
+	RKE2SupervisorPort = 9345
+)
+
+// rke2ConfigFile is where RKE2 reads its configuration from. RKE2 has far
+// more options than flags are convenient for, so nodes are configured
+// through it rather than through args.
+var rke2ConfigFile = "/etc/rancher/rke2/config.yaml"
+
+// RKE2Bin returns the path of the rke2 binary, or an empty string if it is not installed.
+func RKE2Bin() string {
+	return role.RKE2Bin()
+}
+
+type RKE2Node struct {
+	providerConfig *providerConfig.Config
+	roleConfig     *service.RoleConfig
+	ledger         ledger.Ledger
+	ip             string
+	iface          string
+	ifaceIP        string
+	role           string
+	host           Host
+}
+
+func (k *RKE2Node) IsWorker() bool {
+	return k.role == RoleWorker
+}
+
+func (k *RKE2Node) K8sBin() string {
+	return RKE2Bin()
+}
+
+func (k *RKE2Node) DeployKubeVIP() error {
+	pconfig := k.ProviderConfig()
+	if !pconfig.KubeVIP.IsEnabled() {
+		return nil
+	}
+
+	manifestDirectory := "/var/lib/rancher/rke2/server/manifests/"
+	if pconfig.RKE2Agent.IsEnabled() {
+		manifestDirectory = "/var/lib/rancher/rke2/agent/pod-manifests/"
+	}
+
+	return deployKubeVIP(k.Host(), k.iface, k.ip, manifestDirectory, pconfig)
+}
+
+// ServerConfig returns the content of the RKE2 config file for a server node.
+func (k *RKE2Node) ServerConfig() map[string]interface{} {
+	pconfig := k.ProviderConfig()
+	config := map[string]interface{}{}
+
+	if pconfig.P2P != nil && pconfig.P2P.UseVPNWithKubernetes() {
+		config["node-ip"] = k.Host().InterfaceIP("edgevpn0")
+	}
+
+	if pconfig.KubeVIP.IsEnabled() {
+		config["tls-san"] = []string{k.ip}
+		config["node-ip"] = k.ifaceIP
+	}
+
+	if pconfig.P2P != nil && pconfig.P2P.Auto.HA.ExternalDB != "" {
+		config["datastore-endpoint"] = pconfig.P2P.Auto.HA.ExternalDB
+	}
+
+	// RKE2 has no cluster-init: the first server always starts the embedded
+	// etcd, and the others join it through the supervisor port.
+	if k.HA() && !k.ClusterInit() {
+		clusterInitIP, _ := k.Ledger().Get("master", "ip")
+		nodeToken, _ := k.Token()
+		config["server"] = fmt.Sprintf("https://%s:%d", clusterInitIP, RKE2SupervisorPort)
+		config["token"] = nodeToken
+	}
+
+	return mergeRKE2Config(config, pconfig.RKE2.Config)
+}
+
+// AgentConfig returns the content of the RKE2 config file for an agent joining the given server.
+func (k *RKE2Node) AgentConfig(masterIP, nodeToken string) (map[string]interface{}, error) {
+	pconfig := k.ProviderConfig()
+	config := map[string]interface{}{
+		"server": fmt.Sprintf("https://%s:%d", masterIP, RKE2SupervisorPort),
+		"token":  strings.TrimRight(nodeToken, "\n"),
+	}
+
+	if pconfig.P2P != nil && pconfig.P2P.UseVPNWithKubernetes() {
+		ip := k.Host().InterfaceIP("edgevpn0")
+		if ip == "" {
+			return nil, errors.New("node doesn't have an ip yet")
+		}
+		config["node-ip"] = ip
+	} else {
+		config["node-ip"] = k.Host().InterfaceIP(guessInterface(pconfig, k.Host()))
+	}
+
+	config = mergeRKE2Config(config, pconfig.RKE2Agent.Config)
+	if pool, ok := workerPool(k); ok {
+		appendRKE2List(config, "node-label", nodeLabels(pool.NodeLabels))
+		appendRKE2List(config, "node-taint", pool.Taints)
+	}
+	return config, nil
+}
+
+// appendRKE2List adds values to a list of the config, keeping the ones
+// the user supplied.
+func appendRKE2List(config map[string]interface{}, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	list := []interface{}{}
+	switch current := config[key].(type) {
+	case []interface{}:
+		list = append(list, current...)
+	case []string:
+		for _, v := range current {
+			list = append(list, v)
+		}
+	case string:
+		list = append(list, current)
+	}
+	for _, v := range values {
+		list = append(list, v)
+	}
+	config[key] = list
+}
+
+func mergeRKE2Config(config, user map[string]interface{}) map[string]interface{} {
+	for k, v := range user {
+		config[k] = v
+	}
+	return config
+}
+
+func writeRKE2Config(h Host, config map[string]interface{}) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := h.MkdirAll(filepath.Dir(rke2ConfigFile), 0755); err != nil {
+		return err
+	}
+	return h.WriteFile(rke2ConfigFile, data, 0600)
+}
+
+// GenArgs writes the server config file and returns the user supplied args.
+func (k *RKE2Node) GenArgs() ([]string, error) {
+	if err := writeRKE2Config(k.Host(), k.ServerConfig()); err != nil {
+		return nil, fmt.Errorf("failed writing %s: %w", rke2ConfigFile, err)
+	}
+
+	return k.ProviderConfig().RKE2.Args, nil
+}
+
+// WriteStandaloneConfig writes the user supplied config file of nodes
+// deployed without p2p.
+func (k *RKE2Node) WriteStandaloneConfig() error {
+	c := k.ProviderConfig()
+	config := c.RKE2.Config
+	if k.IsWorker() {
+		config = c.RKE2Agent.Config
+	}
+	if len(config) == 0 {
+		return nil
+	}
+
+	return writeRKE2Config(k.Host(), config)
+}
+
+func (k *RKE2Node) EnvUnit() string {
+	return machine.K3sEnvUnit(RKE2MasterServiceName)
+}
+
+func (k *RKE2Node) Service() (machine.Service, error) {
+	if utils.IsOpenRCBased() {
+		return openrc.NewService(openrc.WithName(k.ServiceName()))
+	}
+
+	return systemd.NewService(systemd.WithName(k.ServiceName()))
+}
+
+func (k *RKE2Node) Token() (string, error) {
+	return k.Ledger().Get("nodetoken", "token")
+}
+
+// JoinToken creates a bootstrap token, described after the node so it can be
+// found and deleted with "rke2 token" if the node is compromised.
+func (k *RKE2Node) JoinToken(uuid string, ttl time.Duration) (string, error) {
+	if err := checkNodeUUID(uuid); err != nil {
+		return "", err
+	}
+	return tokenCommand(k.K8sBin(), "token", "create", "--ttl", ttl.String(), "--description", "kairos node "+uuid)
+}
+
+func (k *RKE2Node) RevokeJoinToken(token string) error {
+	id, err := revocableTokenID(token)
+	if err != nil {
+		return err
+	}
+	_, err = tokenCommand(k.K8sBin(), "token", "delete", id)
+	return err
+}
+
+func (k *RKE2Node) GenerateEnv() (env map[string]string) {
+	env = make(map[string]string)
+
+	pConfig := k.ProviderConfig()
+
+	if pConfig.RKE2.ReplaceEnv {
+		env = pConfig.RKE2.Env
+	} else {
+		// Override opts with user-supplied
+		for k, v := range pConfig.RKE2.Env {
+			env[k] = v
+		}
+	}
+
+	return env
+}
+
+func (k *RKE2Node) ProviderConfig() *providerConfig.Config {
+	return k.providerConfig
+}
+
+func (k *RKE2Node) SetRoleConfig(c *service.RoleConfig) {
+	k.roleConfig = c
+}
+
+func (k *RKE2Node) RoleConfig() *service.RoleConfig {
+	return k.roleConfig
+}
+
+func (k *RKE2Node) SetLedger(l ledger.Ledger) {
+	k.ledger = l
+}
+
+func (k *RKE2Node) Ledger() ledger.Ledger {
+	return k.ledger
+}
+
+func (k *RKE2Node) HA() bool {
+	return k.role == RoleMasterHA
+}
+
+func (k *RKE2Node) ClusterInit() bool {
+	return k.role == RoleMasterClusterInit
+}
+
+func (k *RKE2Node) IP() string {
+	return k.ip
+}
+
+func (k *RKE2Node) PropagateData() error {
+	c := k.RoleConfig()
+	tokenB, err := os.ReadFile("/var/lib/rancher/rke2/server/node-token")
+	if err != nil {
+		c.Logger.Error(err)
+		return err
+	}
+
+	nodeToken := strings.TrimRight(string(tokenB), "\n")
+	if nodeToken != "" {
+		err := k.Ledger().Set("nodetoken", "token", nodeToken)
+		if err != nil {
+			c.Logger.Error(err)
+		}
+	}
+
+	kubeB, err := os.ReadFile("/etc/rancher/rke2/rke2.yaml")
+	if err != nil {
+		c.Logger.Error(err)
+		return err
+	}
+	if len(kubeB) != 0 {
+		err := k.Ledger().Set("kubeconfig", "master", base64.RawURLEncoding.EncodeToString(kubeB))
+		if err != nil {
+			c.Logger.Error(err)
+		}
+	}
+
+	return nil
+}
+
+func (k *RKE2Node) WorkerArgs() ([]string, error) {
+	args := k.ProviderConfig().RKE2Agent.Args
+	// Pool labels and taints go to the agent config file
+	if pool, ok := workerPool(k); ok {
+		args = append(append([]string{}, args...), pool.Args...)
+	}
+	return args, nil
+}
+
+func (k *RKE2Node) WorkerToken() string {
+	data, _ := k.Host().ReadFile(rke2ConfigFile)
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return ""
+	}
+	token, _ := config["token"].(string)
+	return token
+}
+
+func (k *RKE2Node) SetupWorker(masterIP, nodeToken string) error {
+	pconfig := k.ProviderConfig()
+	host := k.Host()
+
+	config, err := k.AgentConfig(masterIP, nodeToken)
+	if err != nil {
+		return err
+	}
+	if err := writeRKE2Config(host, config); err != nil {
+		return err
+	}
+
+	env := map[string]string{}
+	if pconfig.RKE2Agent.ReplaceEnv {
+		env = pconfig.RKE2Agent.Env
+	} else {
+		for k, v := range pconfig.RKE2Agent.Env {
+			env[k] = v
+		}
+	}
+
+	return WriteEnv(host, machine.K3sEnvUnit(RKE2WorkerServiceName), env)
+}
+
+func (k *RKE2Node) Role() string {
+	if k.IsWorker() {
+		return RKE2WorkerName
+	}
+
+	return RKE2MasterName
+}
+
+func (k *RKE2Node) ServiceName() string {
+	if k.IsWorker() {
+		return RKE2WorkerServiceName
+	}
+
+	return RKE2MasterServiceName
+}
+
+func (k *RKE2Node) Env() map[string]string {
+	c := k.ProviderConfig()
+	if k.IsWorker() {
+		return c.RKE2Agent.Env
+	}
+
+	return c.RKE2.Env
+}
+
+func (k *RKE2Node) Args() []string {
+	c := k.ProviderConfig()
+
+	if !c.RKE2Agent.IsEnabled() && !c.RKE2.IsEnabled() {
+		return []string{}
+	}
+
+	if k.IsWorker() {
+		return c.RKE2Agent.Args
+	}
+
+	return c.RKE2.Args
+}
+
+func (k *RKE2Node) EnvFile() string {
+	return machine.K3sEnvUnit(k.ServiceName())
+}
+
+func (k *RKE2Node) SetRole(role string) {
+	k.role = role
+}
+
+// SetHost sets the machine the node configures, the OS one by default.
+func (k *RKE2Node) SetHost(h Host) {
+	k.host = h
+}
+
+func (k *RKE2Node) Host() Host {
+	if k.host == nil {
+		return OSHost
+	}
+	return k.host
+}
+
+func (k *RKE2Node) SetIP(ip string) {
+	k.ip = ip
+}
+
+func (k *RKE2Node) GuessInterface() {
+	iface := guessInterface(k.ProviderConfig(), k.Host())
+	ifaceIP := k.Host().InterfaceIP(iface)
+
+	k.iface = iface
+	k.ifaceIP = ifaceIP
+}
+
+func (k *RKE2Node) Distro() string {
+	return RKE2DistroName
+}
@@ -0,0 +1,107 @@
+package role
+
+import (
+	"os"
+	"path/filepath"
+
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v3"
+)
+
+var _ = Describe("RKE2Node", func() {
+	var (
+		l       ledger.Ledger
+		noVPN   = false
+		enabled = true
+	)
+
+	BeforeEach(func() {
+		l = ledger.NewMemoryNetwork("node").Node("node")
+		Expect(l.Set("master", "ip", "10.1.0.1")).To(Succeed())
+		Expect(l.Set("nodetoken", "token", "secret")).To(Succeed())
+	})
+
+	Context("server config", func() {
+		It("joins master/ha nodes to the clusterinit one through the supervisor port", func() {
+			node := &RKE2Node{providerConfig: &providerConfig.Config{P2P: &providerConfig.P2P{VPN: providerConfig.VPN{Create: &noVPN}}}, role: RoleMasterHA}
+			node.SetLedger(l)
+
+			config := node.ServerConfig()
+			Expect(config).To(HaveKeyWithValue("server", "https://10.1.0.1:9345"))
+			Expect(config).To(HaveKeyWithValue("token", "secret"))
+		})
+
+		It("doesn't join anything on the clusterinit node", func() {
+			node := &RKE2Node{providerConfig: &providerConfig.Config{P2P: &providerConfig.P2P{VPN: providerConfig.VPN{Create: &noVPN}}}, role: RoleMasterClusterInit}
+			node.SetLedger(l)
+
+			config := node.ServerConfig()
+			Expect(config).ToNot(HaveKey("server"))
+			Expect(config).ToNot(HaveKey("token"))
+		})
+
+		It("lets the user config take precedence", func() {
+			node := &RKE2Node{providerConfig: &providerConfig.Config{
+				P2P: &providerConfig.P2P{
+					VPN:  providerConfig.VPN{Create: &noVPN},
+					Auto: providerConfig.Auto{HA: providerConfig.HA{ExternalDB: "postgres://db"}},
+				},
+				RKE2: providerConfig.RKE2{Config: map[string]interface{}{"datastore-endpoint": "mysql://db", "cni": "cilium"}},
+			}, role: RoleMaster}
+			node.SetLedger(l)
+
+			config := node.ServerConfig()
+			Expect(config).To(HaveKeyWithValue("datastore-endpoint", "mysql://db"))
+			Expect(config).To(HaveKeyWithValue("cni", "cilium"))
+		})
+	})
+
+	It("generates the agent config", func() {
+		node := &RKE2Node{providerConfig: &providerConfig.Config{
+			P2P:       &providerConfig.P2P{VPN: providerConfig.VPN{Create: &noVPN}},
+			RKE2Agent: providerConfig.RKE2{Config: map[string]interface{}{"node-label": []string{"pool=edge"}}},
+		}, role: RoleWorker}
+
+		config, err := node.AgentConfig("10.1.0.1", "secret\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(HaveKeyWithValue("server", "https://10.1.0.1:9345"))
+		Expect(config).To(HaveKeyWithValue("token", "secret"))
+		Expect(config).To(HaveKeyWithValue("node-label", []string{"pool=edge"}))
+	})
+
+	It("writes the user config of standalone nodes", func() {
+		rke2ConfigFile = filepath.Join(GinkgoT().TempDir(), "rke2", "config.yaml")
+		DeferCleanup(func() { rke2ConfigFile = "/etc/rancher/rke2/config.yaml" })
+
+		node := &RKE2Node{providerConfig: &providerConfig.Config{
+			RKE2: providerConfig.RKE2{Enabled: &enabled, Config: map[string]interface{}{"cni": "calico"}},
+		}, role: RoleMaster}
+		Expect(node.WriteStandaloneConfig()).To(Succeed())
+
+		data, err := os.ReadFile(rke2ConfigFile)
+		Expect(err).ToNot(HaveOccurred())
+		written := map[string]interface{}{}
+		Expect(yaml.Unmarshal(data, &written)).To(Succeed())
+		Expect(written).To(Equal(map[string]interface{}{"cni": "calico"}))
+	})
+
+	Context("detection", func() {
+		It("is picked when rke2 is enabled", func() {
+			mock := &MockBinaryDetector{rke2Bin: "/usr/bin/rke2"}
+			node, err := NewK8sNodeWithDetector(&providerConfig.Config{RKE2Agent: providerConfig.RKE2{Enabled: &enabled}}, mock)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Distro()).To(Equal(RKE2DistroName))
+			Expect(node.ServiceName()).To(Equal(RKE2WorkerServiceName))
+		})
+
+		It("is picked for p2p when it's the only distribution installed", func() {
+			mock := &MockBinaryDetector{rke2Bin: "/usr/bin/rke2"}
+			node, err := NewK8sNodeWithDetector(&providerConfig.Config{P2P: &providerConfig.P2P{NetworkToken: "token"}}, mock)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Distro()).To(Equal(RKE2DistroName))
+		})
+	})
+})
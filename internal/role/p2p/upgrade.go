@@ -0,0 +1,99 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+)
+
+// installUpgrade installs a version of a distribution, verified against the
+// pinned checksums. It is replaced in tests.
+var installUpgrade = func(distro, version, source, checksums string) (string, error) {
+	o := installer.Options{Source: source, BinDir: role.UpgradedBinDir, Checksums: []byte(checksums)}
+	if _, err := installer.Install(distro, version, o); err != nil {
+		return "", err
+	}
+	return role.UpgradedBin(distro), nil
+}
+
+// upgradeNode upgrades a deployed node to the version rolled out by the
+// leader, when it is its turn. The binary is installed next to the one of
+// the image, the service is pointed to it and restarted. Plans are only
+// trusted when sealed with the cluster secret.
+func upgradeNode(node K8sNode, worker bool) error {
+	c := node.RoleConfig()
+	l := node.Ledger()
+
+	if !ledger.IsSealing(l) {
+		return nil
+	}
+	plan, ok := role.GetUpgradePlan(l)
+	if !ok || plan.Phase != role.UpgradeRunning || plan.Node != c.UUID {
+		return nil
+	}
+	if u, ok := role.GetNodeUpgrade(l, c.UUID); ok && u.Version == plan.Version &&
+		(u.Phase == role.NodeUpgradeDone || u.Phase == role.NodeUpgradeFailed) {
+		return nil
+	}
+
+	status := role.NodeUpgrade{Version: plan.Version, Phase: role.NodeUpgradeInstalling}
+	if err := role.PublishNodeUpgrade(l, c.UUID, status); err != nil {
+		return err
+	}
+	err := func() error {
+		if node.Distro() == RKE2DistroName {
+			return fmt.Errorf("upgrading %s is not supported", node.Distro())
+		}
+		if plan.Checksums == "" {
+			return errors.New("the plan doesn't pin the checksums of the release")
+		}
+
+		c.Logger.Infof("Upgrading %s to %s", node.Distro(), plan.Version)
+		bin, err := installUpgrade(node.Distro(), plan.Version, plan.Source, plan.Checksums)
+		if err != nil {
+			return fmt.Errorf("installing %s %s: %w", node.Distro(), plan.Version, err)
+		}
+
+		var args []string
+		if worker {
+			args, err = node.WorkerArgs()
+		} else {
+			args, err = node.GenArgs()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to generate %s args: %w", node.Distro(), err)
+		}
+
+		svc, err := node.Service()
+		if err != nil {
+			return fmt.Errorf("failed to get %s service: %w", node.Distro(), err)
+		}
+		if err := svc.OverrideCmd(fmt.Sprintf("%s %s %s", bin, node.Role(), strings.Join(args, " "))); err != nil {
+			return fmt.Errorf("failed to override %s command: %w", node.Distro(), err)
+		}
+
+		status.Phase = role.NodeUpgradeRestarting
+		if err := role.PublishNodeUpgrade(l, c.UUID, status); err != nil {
+			c.Logger.Warnf("Failed publishing the upgrade progress: %s", err.Error())
+		}
+		if err := svc.Restart(); err != nil {
+			return fmt.Errorf("failed to restart %s service: %w", node.Distro(), err)
+		}
+		role.ResetLocalDistro()
+		return nil
+	}()
+
+	status.Phase = role.NodeUpgradeDone
+	if err != nil {
+		c.Logger.Errorf("Upgrade to %s failed: %s", plan.Version, err.Error())
+		status.Phase, status.Error = role.NodeUpgradeFailed, err.Error()
+	}
+	if perr := role.PublishNodeUpgrade(l, c.UUID, status); perr != nil {
+		return perr
+	}
+	return err
+}
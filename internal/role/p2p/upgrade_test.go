@@ -0,0 +1,106 @@
+package role
+
+import (
+	"errors"
+
+	logging "github.com/ipfs/go-log"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Node upgrades", func() {
+	var (
+		raw      ledger.Ledger
+		l        ledger.Ledger
+		c        *service.RoleConfig
+		pconfig  *providerConfig.Config
+		installs []string
+		install  func(distro, version, source, checksums string) (string, error)
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("p2p-test", "fatal") //nolint:errcheck
+		raw = ledger.NewMemoryNetwork("node").Node("node")
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = ledger.NewSealed(raw, keys)
+		c = &service.RoleConfig{UUID: "node", Logger: logging.Logger("p2p-test")}
+		pconfig = &providerConfig.Config{P2P: &providerConfig.P2P{}}
+		installs = []string{}
+
+		install = installUpgrade
+		installUpgrade = func(distro, version, source, checksums string) (string, error) {
+			installs = append(installs, distro+" "+version+" "+source+" "+checksums)
+			return "", errors.New("checksum mismatch")
+		}
+		DeferCleanup(func() { installUpgrade = install })
+
+		dat := `{"version":"v1.30.2+k3s1","source":"https://mirror","checksums":"sums","phase":"running","node":"node"}`
+		Expect(l.Set("upgrade", "plan", dat)).To(Succeed())
+	})
+
+	newNode := func(node K8sNode) K8sNode {
+		node.SetRole(RoleWorker)
+		node.SetRoleConfig(c)
+		node.SetLedger(l)
+		return node
+	}
+
+	It("waits for its turn", func() {
+		Expect(l.Set("upgrade", "plan", `{"version":"v1.30.2+k3s1","phase":"running","node":"other"}`)).To(Succeed())
+		Expect(upgradeNode(newNode(&K3sNode{providerConfig: pconfig}), true)).To(Succeed())
+		Expect(installs).To(BeEmpty())
+		_, ok := role.GetNodeUpgrade(l, "node")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("reports failed installs to the leader, once", func() {
+		node := newNode(&K3sNode{providerConfig: pconfig})
+		Expect(upgradeNode(node, true)).To(MatchError(ContainSubstring("checksum mismatch")))
+		Expect(installs).To(Equal([]string{"k3s v1.30.2+k3s1 https://mirror sums"}))
+
+		u, ok := role.GetNodeUpgrade(l, "node")
+		Expect(ok).To(BeTrue())
+		Expect(u.Version).To(Equal("v1.30.2+k3s1"))
+		Expect(u.Phase).To(Equal(role.NodeUpgradeFailed))
+		Expect(u.Error).To(Equal("installing k3s v1.30.2+k3s1: checksum mismatch"))
+
+		Expect(upgradeNode(node, true)).To(Succeed())
+		Expect(installs).To(HaveLen(1))
+	})
+
+	It("ignores the plans which are not sealed with the cluster secret", func() {
+		Expect(raw.Set("upgrade", "plan", `{"version":"v1.30.2+k3s1","source":"https://attacker","checksums":"sums","phase":"running","node":"node"}`)).To(Succeed())
+		Expect(upgradeNode(newNode(&K3sNode{providerConfig: pconfig}), true)).To(Succeed())
+		Expect(installs).To(BeEmpty())
+
+		// Nor trusts any plan without the cluster secret
+		node := newNode(&K3sNode{providerConfig: pconfig})
+		node.SetLedger(raw)
+		Expect(upgradeNode(node, true)).To(Succeed())
+		Expect(installs).To(BeEmpty())
+	})
+
+	It("refuses plans without the checksums of the release", func() {
+		Expect(l.Set("upgrade", "plan", `{"version":"v1.30.2+k3s1","source":"https://mirror","phase":"running","node":"node"}`)).To(Succeed())
+		Expect(upgradeNode(newNode(&K3sNode{providerConfig: pconfig}), true)).To(HaveOccurred())
+		Expect(installs).To(BeEmpty())
+
+		u, _ := role.GetNodeUpgrade(l, "node")
+		Expect(u.Phase).To(Equal(role.NodeUpgradeFailed))
+		Expect(u.Error).To(Equal("the plan doesn't pin the checksums of the release"))
+	})
+
+	It("refuses to upgrade rke2", func() {
+		Expect(upgradeNode(newNode(&RKE2Node{providerConfig: pconfig}), true)).To(HaveOccurred())
+		Expect(installs).To(BeEmpty())
+
+		u, _ := role.GetNodeUpgrade(l, "node")
+		Expect(u.Phase).To(Equal(role.NodeUpgradeFailed))
+		Expect(u.Error).To(Equal("upgrading rke2 is not supported"))
+	})
+})
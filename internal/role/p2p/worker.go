@@ -2,38 +2,64 @@ package role
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
 	"github.com/kairos-io/kairos-sdk/utils"
 
+	"github.com/kairos-io/provider-kairos/v2/internal/installer"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
 	"github.com/kairos-io/provider-kairos/v2/internal/role"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	service "github.com/mudler/edgevpn/api/client/service"
 )
 
+// workerMasterFile records the master IP a worker joined through.
+const workerMasterFile = "/usr/local/.kairos/master"
+
 func Worker(cc *sdkConfig.Config, pconfig *providerConfig.Config) role.Role { //nolint:revive
+	ledgerFor, err := role.ConfiguredLedger(pconfig)
+	if err != nil {
+		return func(*service.RoleConfig) error { return err }
+	}
+	return WorkerWithLedger(cc, pconfig, ledgerFor)
+}
+
+// WorkerWithLedger returns the Worker role coordinating through a custom ledger.
+func WorkerWithLedger(cc *sdkConfig.Config, pconfig *providerConfig.Config, ledgerFor role.LedgerProvider) role.Role { //nolint:revive
 	return func(c *service.RoleConfig) error {
 		c.Logger.Info("Starting Worker")
+		l := ledgerFor(c)
 
 		if pconfig.P2P.Role != "" {
 			// propagate role if we were forced by configuration
 			// This unblocks eventual auto instances to try to assign roles
-			if err := c.Client.Set("role", c.UUID, pconfig.P2P.Role); err != nil {
+			if err := l.Set("role", c.UUID, pconfig.P2P.Role); err != nil {
 				return err
 			}
 		}
 
 		if role.SentinelExist() {
-			c.Logger.Info("Node already configured, backing off")
+			if err := upgradeWorker(c, l, pconfig); err != nil {
+				c.Logger.Error(err)
+			}
+			c.Logger.Info("Node already configured, checking the master")
+			if err := followMaster(c, l, pconfig); err != nil {
+				return err
+			}
+			node, err := workerNode(c, l, pconfig)
+			if err != nil {
+				return fmt.Errorf("stopping Worker: %s", err.Error())
+			}
+			if err := reconcileDeployedWorker(node); err != nil {
+				c.Logger.Errorf("Failed reconciling the config: %s", err.Error())
+			}
 			return nil
 		}
 
-		masterIP, _ := c.Client.Get("master", "ip")
-		if masterIP == "" {
-			c.Logger.Info("MasterIP not there still..")
-			return nil
-		}
+		masterIP, _ := l.Get("master", "ip")
 
 		node, err := NewK8sNode(pconfig)
 		if err != nil {
@@ -42,58 +68,182 @@ func Worker(cc *sdkConfig.Config, pconfig *providerConfig.Config) role.Role { //
 
 		ip := guessIP(pconfig)
 		if ip != "" {
-			if err := c.Client.Set("ip", c.UUID, ip); err != nil {
+			if err := l.Set("ip", c.UUID, ip); err != nil {
 				c.Logger.Error(err)
 			}
 		}
 
 		node.SetRole(RoleWorker)
 		node.SetRoleConfig(c)
+		node.SetLedger(l)
 		node.SetIP(ip)
 
-		nodeToken, _ := node.Token()
-		if nodeToken == "" {
-			c.Logger.Info("node token not there still..")
-			return nil
-		}
-
-		utils.SH("kairos-agent run-stage provider-kairos.bootstrap.before.worker") //nolint:errcheck
-
-		err = node.SetupWorker(masterIP, nodeToken)
+		svc, err := node.Service()
 		if err != nil {
 			return err
 		}
 
-		k8sBin := node.K8sBin()
-		if k8sBin == "" {
-			return fmt.Errorf("no %s binary found (?)", node.Distro())
+		err = role.RunSteps(role.BootstrapStateFile, RoleWorker, []role.Step{
+			{Name: "wait-master", Run: func() error {
+				if masterIP == "" {
+					c.Logger.Info("MasterIP not there still..")
+					return role.StepPending("the master IP was not published yet")
+				}
+				return nil
+			}},
+			{Name: "wait-join-token", Run: func() error {
+				if readJoinToken(l, c.UUID, time.Now()) == "" {
+					c.Logger.Info("join token not there still..")
+					return role.StepPending("no join token was minted for the node yet")
+				}
+				return nil
+			}},
+			{Name: "before-stage", Run: func() error {
+				utils.SH("kairos-agent run-stage provider-kairos.bootstrap.before.worker") //nolint:errcheck
+				return nil
+			}},
+			{Name: "setup", Run: func() error {
+				if masterIP == "" {
+					return role.StepPending("the master IP was not published yet")
+				}
+				nodeToken := readJoinToken(l, c.UUID, time.Now())
+				if nodeToken == "" {
+					c.Logger.Info("join token not there still..")
+					return role.StepPending("the join token expired before it was used")
+				}
+				if err := node.SetupWorker(masterIP, nodeToken); err != nil {
+					return err
+				}
+				if err := os.WriteFile(workerMasterFile, []byte(masterIP), 0600); err != nil {
+					c.Logger.Warnf("Failed recording the master IP: %s", err.Error())
+				}
+				return nil
+			}},
+			{Name: "service-override", Run: func() error {
+				k8sBin := node.K8sBin()
+				if k8sBin == "" {
+					return fmt.Errorf("no %s binary found (?)", node.Distro())
+				}
+
+				args, err := node.WorkerArgs()
+				if err != nil {
+					return err
+				}
+
+				c.Logger.Info(fmt.Sprintf("Configuring %s worker", node.Distro()))
+				return svc.OverrideCmd(fmt.Sprintf("%s %s %s", k8sBin, node.Role(), strings.Join(args, " ")))
+			}},
+			{Name: "verify-airgap", Run: func() error {
+				if err := installer.VerifyAirgap(); err != nil {
+					return fmt.Errorf("failed verifying the airgap images: %w", err)
+				}
+				return nil
+			}},
+			{Name: "start", Run: svc.Start},
+			{Name: "enable", Run: svc.Enable},
+			{Name: "record-config", Run: func() error {
+				desired, err := desiredWorkerState(node, masterIP, node.WorkerToken())
+				if err != nil {
+					return err
+				}
+				return recordConfig(node.Host(), desired)
+			}},
+			{Name: "consume-join-token", Run: func() error {
+				return consumeJoinToken(l, c.UUID, masterIP)
+			}},
+			{Name: "after-stage", Run: func() error {
+				utils.SH("kairos-agent run-stage provider-kairos.bootstrap.after.worker") //nolint:errcheck
+				return nil
+			}},
+			{Name: "sentinel", Run: role.CreateSentinel},
+		})
+		if perr := role.ReportProgress(l, c.UUID); perr != nil {
+			c.Logger.Warnf("Failed publishing the bootstrap progress: %s", perr.Error())
 		}
+		return err
+	}
+}
 
-		args, err := node.WorkerArgs()
-		if err != nil {
-			return err
-		}
+// upgradeWorker upgrades a deployed worker when it is its turn.
+func upgradeWorker(c *service.RoleConfig, l ledger.Ledger, pconfig *providerConfig.Config) error {
+	if plan, ok := role.GetUpgradePlan(l); !ok || plan.Node != c.UUID {
+		return nil
+	}
+	node, err := workerNode(c, l, pconfig)
+	if err != nil {
+		return err
+	}
+	return upgradeNode(node, true)
+}
 
-		svc, err := node.Service()
-		if err != nil {
-			return err
-		}
+// workerNode returns the node of a deployed worker.
+func workerNode(c *service.RoleConfig, l ledger.Ledger, pconfig *providerConfig.Config) (K8sNode, error) {
+	node, err := NewK8sNode(pconfig)
+	if err != nil {
+		return nil, err
+	}
+	node.SetRole(RoleWorker)
+	node.SetRoleConfig(c)
+	node.SetLedger(l)
+	node.SetIP(guessIP(pconfig))
+	return node, nil
+}
 
-		c.Logger.Info(fmt.Sprintf("Configuring %s worker", node.Distro()))
-		if err := svc.OverrideCmd(fmt.Sprintf("%s %s %s", k8sBin, node.Role(), strings.Join(args, " "))); err != nil {
-			return err
-		}
+// followMaster reconfigures a deployed worker when the control plane data
+// is published by another master, e.g. after a failover, and restarts it.
+// The config applied is recorded, so that it is not reconciled again.
+func followMaster(c *service.RoleConfig, l ledger.Ledger, pconfig *providerConfig.Config) error {
+	masterIP, _ := l.Get("master", "ip")
+	if masterIP == "" {
+		return nil
+	}
 
-		if err := svc.Start(); err != nil {
+	dat, err := os.ReadFile(workerMasterFile)
+	current := strings.TrimSpace(string(dat))
+	if err != nil || current == "" {
+		// Deployed before the master was recorded, assume it didn't change since
+		if err := os.WriteFile(workerMasterFile, []byte(masterIP), 0600); err != nil {
 			return err
 		}
-
-		if err := svc.Enable(); err != nil {
-			return err
+		current = masterIP
+	}
+	if current == masterIP {
+		// Workers deployed before join tokens were minted never recorded it
+		if joined, _ := l.Get("joined", c.UUID); joined != masterIP {
+			return l.Set("joined", c.UUID, masterIP)
 		}
+		return nil
+	}
 
-		utils.SH("kairos-agent run-stage provider-kairos.bootstrap.after.worker") //nolint:errcheck
+	node, err := workerNode(c, l, pconfig)
+	if err != nil {
+		return fmt.Errorf("stopping Worker: %s", err.Error())
+	}
 
-		return role.CreateSentinel()
+	nodeToken := readJoinToken(l, c.UUID, time.Now())
+	if nodeToken == "" {
+		c.Logger.Info("join token not there still..")
+		return nil
+	}
+
+	c.Logger.Infof("Master moved from %s to %s, reconfiguring %s worker", current, masterIP, node.Distro())
+	desired, err := desiredWorkerState(node, masterIP, nodeToken)
+	if err != nil {
+		return err
+	}
+	svc, err := node.Service()
+	if err != nil {
+		return err
+	}
+	if _, err := reconcile(node, svc, desired); err != nil {
+		return err
+	}
+	// Recorded first, the next reconciliations render the config with it
+	if err := os.WriteFile(workerMasterFile, []byte(masterIP), 0600); err != nil {
+		return err
+	}
+	if err := consumeJoinToken(l, c.UUID, masterIP); err != nil {
+		c.Logger.Warnf("Failed consuming the join token: %s", err.Error())
 	}
+	return nil
 }
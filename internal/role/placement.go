@@ -0,0 +1,146 @@
+package role
+
+import (
+	"sort"
+	"time"
+
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	"github.com/samber/lo"
+)
+
+const gib = 1 << 30
+
+// controlPlaneScore ranks a node for control plane roles, higher is better.
+//
+// Each CPU is worth 4 points and each GiB of memory 2 points. 64-bit
+// architectures get 8 extra points, as some control plane components are
+// not built for 32-bit ones. Nodes that didn't advertise facts yet score 0.
+func controlPlaneScore(f Facts) int {
+	score := f.CPUs*4 + int(f.Memory/gib)*2
+	switch f.Arch {
+	case "amd64", "arm64", "ppc64le", "s390x", "riscv64":
+		score += 8
+	}
+	return score
+}
+
+// DefaultFactsTimeout is how long the leader holds scheduling for nodes
+// which didn't advertise their facts yet.
+const DefaultFactsTimeout = 2 * time.Minute
+
+// factsGate holds scheduling until every node to schedule advertised its
+// facts, so that the placement doesn't rank nodes it knows nothing about.
+// Nodes which never advertise facts are scheduled once the timeout passes.
+type factsGate struct {
+	timeout time.Duration
+	since   time.Time
+}
+
+// wait returns the nodes scheduling has to wait the facts of.
+func (g *factsGate) wait(l ledger.Ledger, nodes []string, now time.Time) []string {
+	missing := lo.Filter(nodes, func(u string, _ int) bool {
+		_, ok := GetFacts(l, u)
+		return !ok
+	})
+	if len(missing) == 0 {
+		g.since = time.Time{}
+		return nil
+	}
+	if g.since.IsZero() {
+		g.since = now
+	}
+	if now.Sub(g.since) >= g.timeout {
+		return nil
+	}
+	return missing
+}
+
+// rankControlPlane returns the nodes eligible for the control plane, best
+// candidates first. Ties are broken by UUID so that every leader comes to
+// the same decision.
+func rankControlPlane(l ledger.Ledger, nodes []string) []string {
+	scores := map[string]int{}
+	candidates := []string{}
+	for _, n := range nodes {
+		f, _ := GetFacts(l, n)
+		if !f.IsControlPlaneEligible() {
+			continue
+		}
+		scores[n] = controlPlaneScore(f)
+		candidates = append(candidates, n)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+	return candidates
+}
+
+// placedRole returns the role the placement rules pin a node to, if any.
+func placedRole(l ledger.Ledger, rules []providerConfig.Placement, uuid string) string {
+	f, _ := GetFacts(l, uuid)
+	for _, r := range rules {
+		if r.Matches(uuid, f.Hostname, f.Labels) {
+			return r.Role
+		}
+	}
+	return ""
+}
+
+// placementNeedsFacts returns true if any placement rule selects nodes by
+// hostname or labels, which are only known from the facts of the nodes.
+func placementNeedsFacts(rules []providerConfig.Placement) bool {
+	return lo.ContainsBy(rules, func(r providerConfig.Placement) bool { return r.Hostname != "" || len(r.Labels) > 0 })
+}
+
+// pinsMasters returns true if any placement rule pins nodes to the control plane.
+func pinsMasters(rules []providerConfig.Placement) bool {
+	return lo.ContainsBy(rules, func(r providerConfig.Placement) bool { return r.Role == "master" })
+}
+
+// placeControlPlane returns the candidates for control plane roles, best
+// first. Nodes pinned to workers are left out, and so are the nodes not
+// pinned to masters when some rule pins masters.
+func placeControlPlane(l ledger.Ledger, rules []providerConfig.Placement, nodes []string) []string {
+	restricted := pinsMasters(rules)
+	candidates := lo.Filter(nodes, func(u string, _ int) bool {
+		switch placedRole(l, rules, u) {
+		case "master":
+			return true
+		case "worker":
+			return false
+		default:
+			return !restricted
+		}
+	})
+	return rankControlPlane(l, candidates)
+}
+
+// workerPool returns the name of the first worker pool selecting a node, if any.
+func workerPool(l ledger.Ledger, pools []providerConfig.WorkerPool, uuid string) string {
+	f, _ := GetFacts(l, uuid)
+	for _, p := range pools {
+		if p.Matches(uuid, f.Hostname, f.Labels) {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// assignWorkerPool records the worker pool of a node, before it gets its
+// worker role and renders its args from it.
+func assignWorkerPool(l ledger.Ledger, pools []providerConfig.WorkerPool, uuid string) (string, error) {
+	pool := workerPool(l, pools, uuid)
+	if pool == "" {
+		return "", nil
+	}
+	if current, _ := l.Get("pool", uuid); current == pool {
+		return pool, nil
+	}
+	return pool, l.Set("pool", uuid, pool)
+}
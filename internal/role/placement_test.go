@@ -0,0 +1,215 @@
+package role
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
+	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role placement", func() {
+	var (
+		network *ledger.MemoryNetwork
+		l       ledger.Ledger
+		no      = false
+	)
+
+	BeforeEach(func() {
+		network = ledger.NewMemoryNetwork("pi", "x86", "big", "small")
+		l = network.Node("pi")
+		Expect(PublishFacts(l, "pi", Facts{CPUs: 4, Memory: 4 * gib, Arch: "arm"})).To(Succeed())
+		Expect(PublishFacts(l, "x86", Facts{CPUs: 8, Memory: 16 * gib, Arch: "amd64"})).To(Succeed())
+		Expect(PublishFacts(l, "big", Facts{CPUs: 64, Memory: 256 * gib, Arch: "amd64", ControlPlaneEligible: &no})).To(Succeed())
+		Expect(PublishFacts(l, "small", Facts{CPUs: 2, Memory: 2 * gib, Arch: "arm64"})).To(Succeed())
+	})
+
+	It("ranks eligible nodes by score", func() {
+		Expect(rankControlPlane(l, []string{"pi", "x86", "big", "small"})).To(Equal([]string{"x86", "pi", "small"}))
+	})
+
+	It("breaks ties by UUID", func() {
+		Expect(PublishFacts(l, "a", Facts{CPUs: 2})).To(Succeed())
+		Expect(PublishFacts(l, "b", Facts{CPUs: 2})).To(Succeed())
+		Expect(rankControlPlane(l, []string{"b", "a"})).To(Equal([]string{"a", "b"}))
+		Expect(rankControlPlane(l, []string{"a", "b"})).To(Equal([]string{"a", "b"}))
+	})
+
+	It("keeps nodes without facts as candidates", func() {
+		Expect(rankControlPlane(l, []string{"unknown", "pi"})).To(Equal([]string{"pi", "unknown"}))
+	})
+
+	It("holds scheduling until the nodes advertised their facts", func() {
+		gate := &factsGate{timeout: time.Minute}
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		Expect(gate.wait(l, []string{"pi", "late"}, now)).To(Equal([]string{"late"}))
+		Expect(gate.wait(l, []string{"pi", "late"}, now.Add(30*time.Second))).To(Equal([]string{"late"}))
+
+		Expect(PublishFacts(l, "late", Facts{CPUs: 2})).To(Succeed())
+		Expect(gate.wait(l, []string{"pi", "late"}, now.Add(40*time.Second))).To(BeEmpty())
+	})
+
+	It("stops holding scheduling for nodes without facts after the timeout", func() {
+		gate := &factsGate{timeout: time.Minute}
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		Expect(gate.wait(l, []string{"unknown"}, now)).To(HaveLen(1))
+		Expect(gate.wait(l, []string{"unknown"}, now.Add(time.Minute))).To(BeEmpty())
+	})
+
+	It("schedules the master on the best ranked node", func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		c := &service.RoleConfig{UUID: "pi", Logger: logging.Logger("role-test")}
+		pconfig := &providerConfig.Config{P2P: &providerConfig.P2P{}}
+
+		Expect(scheduleRoles([]string{"pi", "x86", "big", "small"}, c, l, &sdkConfig.Config{}, pconfig, &outageTimer{})).To(Succeed())
+		Expect(l.Get("role", "x86")).To(Equal("master"))
+		Expect(l.Get("role", "big")).To(Equal("worker"))
+		Expect(l.Get("role", "pi")).To(Equal("worker"))
+	})
+
+	It("reads back the published facts", func() {
+		f, ok := GetFacts(l, "pi")
+		Expect(ok).To(BeTrue())
+		Expect(f.Arch).To(Equal("arm"))
+
+		_, ok = GetFacts(l, "missing")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("collects the local facts with the configured labels", func() {
+		pconfig := &providerConfig.Config{P2P: &providerConfig.P2P{
+			Labels:               map[string]string{"zone": "a"},
+			ControlPlaneEligible: &no,
+		}}
+		f := LocalFacts(pconfig)
+		Expect(f.CPUs).To(BeNumerically(">", 0))
+		Expect(f.Labels).To(HaveKeyWithValue("zone", "a"))
+		Expect(f.IsControlPlaneEligible()).To(BeFalse())
+	})
+
+	It("reads the total memory from meminfo", func() {
+		meminfo := filepath.Join(GinkgoT().TempDir(), "meminfo")
+		Expect(os.WriteFile(meminfo, []byte("MemTotal:        2048 kB\nMemFree:         1024 kB\n"), 0600)).To(Succeed())
+		Expect(memTotal(meminfo)).To(Equal(uint64(2048 * 1024)))
+		Expect(memTotal(filepath.Join(GinkgoT().TempDir(), "missing"))).To(BeZero())
+	})
+})
+
+var _ = Describe("Placement rules", func() {
+	var (
+		l ledger.Ledger
+		c *service.RoleConfig
+	)
+
+	schedule := func(auto providerConfig.Auto, nodes ...string) {
+		pconfig := &providerConfig.Config{P2P: &providerConfig.P2P{Auto: auto}}
+		Expect(scheduleRoles(nodes, c, l, &sdkConfig.Config{}, pconfig, &outageTimer{})).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		c = &service.RoleConfig{UUID: "cp-1", Logger: logging.Logger("role-test")}
+		l = ledger.NewMemoryNetwork("cp-1", "cp-2", "edge-1", "edge-2").Node("cp-1")
+		Expect(PublishFacts(l, "cp-1", Facts{CPUs: 2, Hostname: "cp-1.lab"})).To(Succeed())
+		Expect(PublishFacts(l, "cp-2", Facts{CPUs: 2, Hostname: "cp-2.lab"})).To(Succeed())
+		Expect(PublishFacts(l, "edge-1", Facts{CPUs: 16, Hostname: "edge-1.lab", Labels: map[string]string{"tier": "edge"}})).To(Succeed())
+		Expect(PublishFacts(l, "edge-2", Facts{CPUs: 32, Hostname: "edge-2.lab", Labels: map[string]string{"tier": "edge"}})).To(Succeed())
+	})
+
+	It("matches nodes on all the selectors of a rule", func() {
+		rule := providerConfig.Placement{Role: "worker", NodeSelector: providerConfig.NodeSelector{Hostname: "edge-*", Labels: map[string]string{"tier": "edge"}}}
+		Expect(rule.Matches("edge-1", "edge-1.lab", map[string]string{"tier": "edge", "zone": "a"})).To(BeTrue())
+		Expect(rule.Matches("edge-1", "edge-1.lab", nil)).To(BeFalse())
+		Expect(rule.Matches("cp-1", "cp-1.lab", map[string]string{"tier": "edge"})).To(BeFalse())
+		Expect(providerConfig.Placement{Role: "worker"}.Matches("edge-1", "edge-1.lab", nil)).To(BeFalse())
+	})
+
+	It("schedules the master on a pinned node over better ranked ones", func() {
+		schedule(providerConfig.Auto{Placement: []providerConfig.Placement{
+			{Role: "master", NodeSelector: providerConfig.NodeSelector{Hostname: "cp-*"}},
+		}}, "cp-1", "cp-2", "edge-1", "edge-2")
+
+		Expect(l.Get("role", "cp-1")).To(Equal("master"))
+		Expect(l.Get("role", "edge-1")).To(Equal("worker"))
+		Expect(l.Get("role", "edge-2")).To(Equal("worker"))
+		// There is a single master without HA, the other pinned node waits
+		Expect(l.Get("role", "cp-2")).To(BeEmpty())
+	})
+
+	It("doesn't place nodes before their facts are known", func() {
+		auto := providerConfig.Auto{Placement: []providerConfig.Placement{
+			{Role: "master", NodeSelector: providerConfig.NodeSelector{Hostname: "cp-*"}},
+		}}
+		schedule(auto, "cp-1", "cp-3", "edge-1")
+		Expect(l.Get("role", "cp-1")).To(Equal("master"))
+		Expect(l.Get("role", "cp-3")).To(BeEmpty())
+		Expect(l.Get("role", "edge-1")).To(Equal("worker"))
+
+		// A node pinned to the control plane is not made a worker
+		Expect(PublishFacts(l, "cp-3", Facts{CPUs: 2, Hostname: "cp-3.lab"})).To(Succeed())
+		schedule(auto, "cp-1", "cp-3", "edge-1")
+		Expect(l.Get("role", "cp-3")).To(BeEmpty())
+	})
+
+	It("keeps nodes pinned to workers out of the control plane", func() {
+		schedule(providerConfig.Auto{Placement: []providerConfig.Placement{
+			{Role: "worker", NodeSelector: providerConfig.NodeSelector{Labels: map[string]string{"tier": "edge"}}},
+		}}, "cp-1", "cp-2", "edge-1", "edge-2")
+
+		Expect(l.Get("role", "cp-1")).To(Equal("master"))
+		Expect(l.Get("role", "cp-2")).To(Equal("worker"))
+	})
+
+	It("waits for a pinned master to join", func() {
+		schedule(providerConfig.Auto{Placement: []providerConfig.Placement{
+			{Role: "master", NodeSelector: providerConfig.NodeSelector{UUIDs: []string{"cp-2"}}},
+		}}, "cp-1", "edge-1", "edge-2")
+
+		Expect(l.Get("role", "cp-1")).To(BeEmpty())
+		Expect(l.Get("role", "edge-2")).To(BeEmpty())
+	})
+
+	It("places the HA control plane on pinned nodes", func() {
+		masters := 1
+		auto := providerConfig.Auto{
+			HA: providerConfig.HA{MasterNodes: &masters},
+			Placement: []providerConfig.Placement{
+				{Role: "master", NodeSelector: providerConfig.NodeSelector{UUIDs: []string{"cp-1", "cp-2"}}},
+			},
+		}
+		schedule(auto, "cp-1", "cp-2", "edge-1", "edge-2")
+		schedule(auto, "cp-1", "cp-2", "edge-1", "edge-2")
+
+		Expect(l.Get("role", "cp-1")).To(Equal("master/clusterinit"))
+		Expect(l.Get("role", "cp-2")).To(Equal("master/ha"))
+	})
+})
+
+var _ = Describe("Worker pools", func() {
+	It("assigns workers to the first pool selecting them", func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		c := &service.RoleConfig{UUID: "cp", Logger: logging.Logger("role-test")}
+		l := ledger.NewMemoryNetwork("cp", "disk", "compute").Node("cp")
+		Expect(PublishFacts(l, "cp", Facts{CPUs: 8})).To(Succeed())
+		Expect(PublishFacts(l, "disk", Facts{CPUs: 2, Labels: map[string]string{"disks": "4"}})).To(Succeed())
+		Expect(PublishFacts(l, "compute", Facts{CPUs: 2, Hostname: "compute-1"})).To(Succeed())
+
+		pconfig := &providerConfig.Config{P2P: &providerConfig.P2P{Auto: providerConfig.Auto{WorkerPools: []providerConfig.WorkerPool{
+			{Name: "storage", NodeSelector: providerConfig.NodeSelector{Labels: map[string]string{"disks": "4"}}},
+			{Name: "compute", NodeSelector: providerConfig.NodeSelector{Hostname: "compute-*"}},
+		}}}}
+		Expect(scheduleRoles([]string{"cp", "disk", "compute"}, c, l, &sdkConfig.Config{}, pconfig, &outageTimer{})).To(Succeed())
+
+		Expect(l.Get("role", "cp")).To(Equal("master"))
+		Expect(l.Get("pool", "cp")).To(BeEmpty())
+		Expect(l.Get("role", "disk")).To(Equal("worker"))
+		Expect(l.Get("pool", "disk")).To(Equal("storage"))
+		Expect(l.Get("pool", "compute")).To(Equal("compute"))
+	})
+})
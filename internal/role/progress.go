@@ -0,0 +1,80 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+)
+
+// Progress is the bootstrap progress a node publishes under status/<uuid>.
+type Progress struct {
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+	// Phase is the bootstrap step the node is at, or StepsDone
+	Phase string `json:"phase" yaml:"phase"`
+	// Waiting tells why the node is waiting in the current phase
+	Waiting   string    `json:"waiting,omitempty" yaml:"waiting,omitempty"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Distro    string    `json:"distro,omitempty" yaml:"distro,omitempty"`
+	Version   string    `json:"version,omitempty" yaml:"version,omitempty"`
+	StartedAt time.Time `json:"started_at" yaml:"started_at"`
+	UpdatedAt time.Time `json:"updated_at" yaml:"updated_at"`
+}
+
+// LocalProgress returns the progress of the node, from its bootstrap state file.
+func LocalProgress() (Progress, error) {
+	state, err := ReadBootstrapState(BootstrapStateFile)
+	if err != nil {
+		return Progress{}, err
+	}
+	p := Progress{
+		Role:      state.Role,
+		Phase:     state.Current,
+		Waiting:   state.Pending,
+		Error:     state.Error,
+		StartedAt: state.StartedAt,
+		UpdatedAt: state.UpdatedAt,
+	}
+	p.Distro, p.Version = localDistro()
+	return p, nil
+}
+
+// PublishProgress writes the node progress to the ledger, unless it is already there.
+func PublishProgress(l ledger.Ledger, uuid string, p Progress) error {
+	dat, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if current, _ := l.Get("status", uuid); current == string(dat) {
+		return nil
+	}
+	return l.Set("status", uuid, string(dat))
+}
+
+// ReportProgress publishes the local bootstrap progress of the node, if it started one.
+func ReportProgress(l ledger.Ledger, uuid string) error {
+	p, err := LocalProgress()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	observeBootstrap(p)
+	return PublishProgress(l, uuid, p)
+}
+
+// GetProgress returns the progress published by a node, and whether there was any.
+func GetProgress(l ledger.Ledger, uuid string) (Progress, bool) {
+	var p Progress
+	raw, _ := l.Get("status", uuid)
+	if raw == "" {
+		return p, false
+	}
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return Progress{}, false
+	}
+	return p, true
+}
@@ -0,0 +1,60 @@
+package role
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bootstrap progress", func() {
+	var (
+		l         ledger.Ledger
+		stateFile string
+	)
+
+	BeforeEach(func() {
+		l = ledger.NewMemoryNetwork("worker").Node("worker")
+		stateFile = BootstrapStateFile
+		BootstrapStateFile = filepath.Join(GinkgoT().TempDir(), "bootstrap.json")
+		DeferCleanup(func() { BootstrapStateFile = stateFile })
+	})
+
+	It("isn't published before the bootstrap starts", func() {
+		Expect(ReportProgress(l, "worker")).To(Succeed())
+		_, ok := GetProgress(l, "worker")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("publishes the step the node waits at", func() {
+		Expect(RunSteps(BootstrapStateFile, "worker", []Step{
+			{Name: "wait-master", Run: func() error { return nil }},
+			{Name: "wait-join-token", Run: func() error { return StepPending("no join token was minted for the node yet") }},
+		})).To(Succeed())
+		Expect(ReportProgress(l, "worker")).To(Succeed())
+
+		p, ok := GetProgress(l, "worker")
+		Expect(ok).To(BeTrue())
+		Expect(p.Role).To(Equal("worker"))
+		Expect(p.Phase).To(Equal("wait-join-token"))
+		Expect(p.Waiting).To(Equal("no join token was minted for the node yet"))
+		Expect(p.Error).To(BeEmpty())
+		Expect(p.StartedAt).ToNot(BeZero())
+	})
+
+	It("publishes the last error", func() {
+		Expect(RunSteps(BootstrapStateFile, "worker", []Step{
+			{Name: "start", Run: func() error { return errors.New("k3s-agent.service not found") }},
+		})).ToNot(Succeed())
+		Expect(ReportProgress(l, "worker")).To(Succeed())
+
+		p, _ := GetProgress(l, "worker")
+		Expect(p.Phase).To(Equal("start"))
+		Expect(p.Error).To(Equal("k3s-agent.service not found"))
+
+		s := GetClusterStatus(l, func() (*Kubectl, error) { return nil, errors.New("no kubeconfig") })
+		Expect(s.Nodes[0].Progress).To(HaveValue(Equal(p)))
+	})
+})
@@ -0,0 +1,138 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	"github.com/samber/lo"
+)
+
+// States of a node removal, stored under remove/<uuid>
+const (
+	removalRequested = "requested"
+	removalDone      = "done"
+)
+
+// drainTimeout bounds a drain so that it fits in a single leadership round.
+// A drain that doesn't complete is retried on the next one.
+const drainTimeout = "30s"
+
+// RequestRemoval asks the leader to remove a node from the cluster. The
+// request is sealed with the cluster secret.
+func RequestRemoval(l ledger.Ledger, uuid string) error {
+	if !ledger.IsSealing(l) {
+		return errors.New("removals require the cluster secret, the requests are sealed with it")
+	}
+	return l.Set("remove", uuid, removalRequested)
+}
+
+// RestoreNode lets a removed node be scheduled again, or cancels a pending
+// removal.
+func RestoreNode(l ledger.Ledger, uuid string) error {
+	if !ledger.IsSealing(l) {
+		return errors.New("removals require the cluster secret, the requests are sealed with it")
+	}
+	return l.Delete("remove", uuid)
+}
+
+// removeNodes processes the pending removal requests, and returns the nodes
+// which are left to schedule. Requests are looked up in the ledger rather
+// than among the nodes, as a node removed for good stops advertizing. A
+// removed node is not scheduled again until it is restored, even while it
+// keeps advertizing. Without the cluster secret, requests are ignored.
+func removeNodes(nodes []string, c *service.RoleConfig, l ledger.Ledger, kubectl func() (*Kubectl, error)) ([]string, error) {
+	if !ledger.IsSealing(l) {
+		return nodes, nil
+	}
+	requested, err := l.List("remove")
+	if err != nil {
+		return nodes, err
+	}
+
+	removed := []string{}
+	for _, u := range requested {
+		state, err := l.Get("remove", u)
+		if err != nil {
+			c.Logger.Warnf("Ignoring the removal of '%s': %s", u, err.Error())
+			continue
+		}
+		if state == removalDone {
+			removed = append(removed, u)
+			continue
+		}
+		if state != removalRequested {
+			continue
+		}
+
+		role, _ := l.Get("role", u)
+		if role == "master" || role == "master/clusterinit" {
+			c.Logger.Warnf("Refusing to remove '%s', it is running the %s role", u, role)
+			if err := l.Delete("remove", u); err != nil {
+				return nodes, err
+			}
+			continue
+		}
+		removed = append(removed, u)
+
+		// A node without a role never joined the cluster
+		if role != "" {
+			k, err := kubectl()
+			if err == nil {
+				ip, _ := l.Get("ip", u)
+				err = drain(k, ip, role == "master/ha")
+			}
+			if err != nil {
+				c.Logger.Warnf("Failed removing '%s' from the cluster, retrying: %s", u, err.Error())
+				continue
+			}
+		}
+
+		c.Logger.Infof("Node '%s' removed, pruning its ledger entries", u)
+		for _, thing := range []string{"role", "ip", "facts", "unreachable", "jointoken", "joined", "admission", "identity", "pinned", "role-signature", "ip-signature", "status", "pool", "upgrade-status"} {
+			if err := l.Delete(thing, u); err != nil {
+				return nodes, err
+			}
+		}
+		if err := l.Set("remove", u, removalDone); err != nil {
+			return nodes, err
+		}
+	}
+	return lo.Without(nodes, removed...), nil
+}
+
+// drain cordons, drains and deletes the Kubernetes node with the given IP,
+// removing it from etcd too if it is part of the control plane.
+func drain(k *Kubectl, ip string, etcd bool) error {
+	name, err := k.NodeName(ip)
+	if err != nil || name == "" {
+		return err
+	}
+	if err := cordonAndDrain(k, name); err != nil {
+		return err
+	}
+	if etcd {
+		switch k.Distro {
+		case "k3s", "rke2":
+			// The etcd controller removes the member of annotated nodes
+			_, err = k.Run("annotate", "--overwrite", "node", name, fmt.Sprintf("etcd.%s.cattle.io/remove=true", k.Distro))
+		case "k0s":
+			_, err = k.Run("patch", "etcdmember", name, "--type=merge", "-p", `{"spec":{"leave":true}}`)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = k.Run("delete", "node", name, "--ignore-not-found")
+	return err
+}
+
+// cordonAndDrain cordons the Kubernetes node and evicts its pods.
+func cordonAndDrain(k *Kubectl, name string) error {
+	if _, err := k.Run("cordon", name); err != nil {
+		return err
+	}
+	_, err := k.Run("drain", name, "--ignore-daemonsets", "--delete-emptydir-data", "--force", "--timeout="+drainTimeout)
+	return err
+}
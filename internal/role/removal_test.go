@@ -0,0 +1,154 @@
+package role
+
+import (
+	"errors"
+	"strings"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Node removal", func() {
+	var (
+		raw   ledger.Ledger
+		l     ledger.Ledger
+		c     *service.RoleConfig
+		calls []string
+		kube  func() (*Kubectl, error)
+		nodes = []string{"init", "ha", "worker"}
+	)
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		raw = ledger.NewMemoryNetwork("leader").Node("leader")
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = ledger.NewSealed(raw, keys)
+		c = &service.RoleConfig{UUID: "leader", Logger: logging.Logger("role-test")}
+		calls = []string{}
+		kube = func() (*Kubectl, error) {
+			return &Kubectl{
+				Distro: "k3s",
+				Run: func(args ...string) (string, error) {
+					calls = append(calls, strings.Join(args, " "))
+					if args[0] == "get" {
+						return "node-init\t10.1.0.1\nnode-ha\t10.1.0.2\nnode-worker\t10.1.0.3\n", nil
+					}
+					return "", nil
+				},
+			}, nil
+		}
+		Expect(l.Set("role", "init", "master/clusterinit")).To(Succeed())
+		Expect(l.Set("role", "ha", "master/ha")).To(Succeed())
+		Expect(l.Set("role", "worker", "worker")).To(Succeed())
+		Expect(l.Set("ip", "init", "10.1.0.1")).To(Succeed())
+		Expect(l.Set("ip", "ha", "10.1.0.2")).To(Succeed())
+		Expect(l.Set("ip", "worker", "10.1.0.3")).To(Succeed())
+	})
+
+	It("leaves nodes alone without a request", func() {
+		remaining, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(nodes))
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("drains, deletes and prunes a worker", func() {
+		Expect(RequestRemoval(l, "worker")).To(Succeed())
+		Expect(PublishFacts(l, "worker", Facts{CPUs: 2})).To(Succeed())
+
+		remaining, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal([]string{"init", "ha"}))
+		Expect(calls[1:]).To(Equal([]string{
+			"cordon node-worker",
+			"drain node-worker --ignore-daemonsets --delete-emptydir-data --force --timeout=30s",
+			"delete node node-worker --ignore-not-found",
+		}))
+		Expect(l.Get("role", "worker")).To(BeEmpty())
+		Expect(l.Get("ip", "worker")).To(BeEmpty())
+		Expect(l.Get("facts", "worker")).To(BeEmpty())
+		Expect(l.Get("remove", "worker")).To(Equal(removalDone))
+
+		// Removed nodes are not scheduled anymore
+		calls = []string{}
+		remaining, err = removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal([]string{"init", "ha"}))
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("schedules a removed node again once restored", func() {
+		Expect(RequestRemoval(l, "worker")).To(Succeed())
+		remaining, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal([]string{"init", "ha"}))
+
+		Expect(RestoreNode(l, "worker")).To(Succeed())
+		remaining, err = removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(nodes))
+	})
+
+	It("ignores the requests which are not sealed with the cluster secret", func() {
+		Expect(raw.Set("remove", "worker", removalRequested)).To(Succeed())
+
+		remaining, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(nodes))
+		Expect(calls).To(BeEmpty())
+		Expect(l.Get("role", "worker")).To(Equal("worker"))
+
+		// Nor removes anything without the cluster secret
+		Expect(RequestRemoval(raw, "worker")).ToNot(Succeed())
+		remaining, err = removeNodes(nodes, c, raw, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(nodes))
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("removes nodes which stopped advertizing", func() {
+		Expect(RequestRemoval(l, "worker")).To(Succeed())
+
+		remaining, err := removeNodes([]string{"init", "ha"}, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal([]string{"init", "ha"}))
+		Expect(calls).To(ContainElement("delete node node-worker --ignore-not-found"))
+		Expect(l.Get("role", "worker")).To(BeEmpty())
+		Expect(l.Get("remove", "worker")).To(Equal(removalDone))
+	})
+
+	It("removes the etcd member of a control plane node", func() {
+		Expect(RequestRemoval(l, "ha")).To(Succeed())
+
+		_, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(ContainElement("annotate --overwrite node node-ha etcd.k3s.cattle.io/remove=true"))
+		Expect(calls[len(calls)-1]).To(Equal("delete node node-ha --ignore-not-found"))
+	})
+
+	It("refuses to remove the master", func() {
+		Expect(RequestRemoval(l, "init")).To(Succeed())
+
+		remaining, err := removeNodes(nodes, c, l, kube)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal(nodes))
+		Expect(calls).To(BeEmpty())
+		Expect(l.Get("remove", "init")).To(BeEmpty())
+		Expect(l.Get("role", "init")).To(Equal("master/clusterinit"))
+	})
+
+	It("retries when the cluster can't be reached", func() {
+		Expect(RequestRemoval(l, "worker")).To(Succeed())
+		unreachable := func() (*Kubectl, error) { return nil, errors.New("no kubeconfig") }
+
+		remaining, err := removeNodes(nodes, c, l, unreachable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remaining).To(Equal([]string{"init", "ha"}))
+		Expect(l.Get("role", "worker")).To(Equal("worker"))
+		Expect(l.Get("remove", "worker")).To(Equal(removalRequested))
+	})
+})
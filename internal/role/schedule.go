@@ -2,34 +2,64 @@ package role
 
 import (
 	"fmt"
-	"math/rand"
 	"time"
 
 	sdkConfig "github.com/kairos-io/kairos-sdk/types/config"
 	providerConfig "github.com/kairos-io/provider-kairos/v2/internal/provider/config"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
 	"github.com/mudler/edgevpn/api/client/service"
 	"github.com/samber/lo"
 )
 
 // scheduleRoles assigns roles to nodes. Meant to be called only by leaders.
-func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config, pconfig *providerConfig.Config) error { //nolint:revive
-	// From the golang docs: https://pkg.go.dev/math/rand#example-package-Rand
-	// Create and seed the generator.
-	// Typically a non-fixed seed should be used, such as time.Now().UnixNano().
-	// Using a fixed seed will produce the same output on every run.
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
+// All writes go through the given ledger, which is expected to fence them
+// against the leadership lease. The outage timer is kept across rounds.
+func scheduleRoles(nodes []string, c *service.RoleConfig, l ledger.Ledger, cc *sdkConfig.Config, pconfig *providerConfig.Config, outage *outageTimer) error { //nolint:revive
 	// Assign roles to nodes
-	unassignedNodes, currentRoles := getRoles(c.Client, nodes)
+	unassignedNodes, currentRoles := getRoles(l, nodes)
 	c.Logger.Infof("I'm the leader. My UUID is: %s.\n Current assigned roles: %+v", c.UUID, currentRoles)
 
-	// Scan for dead nodes
+	// Roles are placed once and for all, so nodes are not placed before the
+	// hostname and labels the rules match on are known
+	if placementNeedsFacts(pconfig.P2P.Auto.Placement) {
+		unassignedNodes = lo.Filter(unassignedNodes, func(u string, _ int) bool {
+			if _, ok := GetFacts(l, u); !ok {
+				c.Logger.Infof("Node '%s' didn't advertise its facts yet, not placing it", u)
+				return false
+			}
+			return true
+		})
+	}
+
+	ha := pconfig.P2P.Auto.HA
+	if ha.IsEnabled() && ha.Failover {
+		// The nodes to schedule might be filtered, look for the master among all of them
+		advertizing, _ := l.AdvertizingNodes()
+		grace := DefaultFailoverGracePeriod
+		if ha.FailoverGracePeriod > 0 {
+			grace = time.Duration(ha.FailoverGracePeriod) * time.Second
+		}
+		changed, err := failover(l, c, currentRoles, advertizing, grace, outage, time.Now())
+		if err != nil || changed {
+			// Wait for the next round to see the updated roles
+			return err
+		}
+	}
+
+	// Scan for dead nodes. They are not among the nodes to schedule anymore,
+	// so look them up among the ones holding a role.
 	if pconfig.P2P.DynamicRoles {
-		advertizing, _ := c.Client.AdvertizingNodes()
-		for u, r := range currentRoles {
-			if !lo.Contains(advertizing, u) {
-				c.Logger.Infof("Role '%s' assigned to unreachable node '%s'. Unassigning.", u, r)
-				if err := c.Client.Delete("role", u); err != nil {
+		advertizing, _ := l.AdvertizingNodes()
+		assigned, _ := l.List("role")
+		for _, u := range assigned {
+			r, _ := l.Get("role", u)
+			// With failover, the control plane is taken care of by promoting masters
+			if ha.IsEnabled() && ha.Failover && (r == "master/clusterinit" || r == "master/ha") {
+				continue
+			}
+			if r != "" && !lo.Contains(advertizing, u) {
+				c.Logger.Infof("Role '%s' assigned to unreachable node '%s'. Unassigning.", r, u)
+				if err := l.Delete("role", u); err != nil {
 					c.Logger.Warnf("Error announcing deletion %+v", err)
 				}
 				// Return here to propagate announces and wait until the map is pruned
@@ -48,6 +78,8 @@ func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config,
 		masterRole = "master/clusterinit"
 	}
 	mastersHA := 0
+	placement := pconfig.P2P.Auto.Placement
+	pools := pconfig.P2P.Auto.WorkerPools
 
 	for _, r := range currentRoles {
 		switch r {
@@ -58,6 +90,14 @@ func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config,
 		}
 	}
 
+	// With failover, a master which is not advertizing anymore is replaced by
+	// promoting a master/ha node, not by initializing a new cluster
+	if ha.IsEnabled() && ha.Failover {
+		if m, _ := l.Get("master", "uuid"); m != "" {
+			existsMaster = true
+		}
+	}
+
 	c.Logger.Infof("Master already present: %t", existsMaster)
 	c.Logger.Infof("Unassigned nodes: %+v", unassignedNodes)
 
@@ -75,18 +115,21 @@ func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config,
 			}
 		}
 
-		// select one node without roles to become master
+		// select the best ranked node without roles to become master, among
+		// the ones pinned to the control plane if any
+		toSelect = placeControlPlane(l, placement, toSelect)
 		if len(toSelect) == 0 {
 			// No nodes available for selection (all filtered out)
 			c.Logger.Warnf("No nodes available for master selection after filtering")
 			return nil
-		} else if len(toSelect) == 1 {
-			selected = toSelect[0]
-		} else {
-			selected = toSelect[r.Intn(len(toSelect))]
 		}
+		selected = toSelect[0]
 
-		if err := c.Client.Set("role", selected, masterRole); err != nil {
+		if err := l.Set("role", selected, masterRole); err != nil {
+			return err
+		}
+		// The new master is the one publishing the control plane data
+		if err := l.Set("master", "uuid", selected); err != nil {
 			return err
 		}
 		c.Logger.Infof("-> Set %s to %s", masterRole, selected)
@@ -103,24 +146,42 @@ func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config,
 	}
 
 	if pconfig.P2P.Auto.HA.IsEnabled() && pconfig.P2P.Auto.HA.MasterNodes != nil && *pconfig.P2P.Auto.HA.MasterNodes != mastersHA {
-		if len(unassignedNodes) > 0 {
-			if err := c.Client.Set("role", unassignedNodes[0], masterHA); err != nil {
+		if candidates := placeControlPlane(l, placement, unassignedNodes); len(candidates) > 0 {
+			if err := l.Set("role", candidates[0], masterHA); err != nil {
 				c.Logger.Error(err)
 				return err
 			}
 			// We want to keep scheduling in a second batch
 			return nil
 		}
-		return fmt.Errorf("not enough nodes to create HA control plane")
+		if len(unassignedNodes) == 0 {
+			return fmt.Errorf("not enough nodes to create HA control plane")
+		}
+		// The nodes left opted out of the control plane, they can still be workers
+		c.Logger.Warnf("No eligible nodes left for the HA control plane, %d of %d masters scheduled", mastersHA, *pconfig.P2P.Auto.HA.MasterNodes)
 	}
 
 	// cycle all empty roles and assign worker roles
 	for _, uuid := range unassignedNodes {
-		if err := c.Client.Set("role", uuid, workerRole); err != nil {
+		// Nodes pinned to the control plane wait for a free master seat
+		if placedRole(l, placement, uuid) == "master" {
+			c.Logger.Warnf("Node '%s' is pinned to the control plane but no master role is left for it", uuid)
+			continue
+		}
+		pool, err := assignWorkerPool(l, pools, uuid)
+		if err != nil {
 			c.Logger.Error(err)
 			return err
 		}
-		c.Logger.Infof("-> Set %s to %s", workerRole, uuid)
+		if err := l.Set("role", uuid, workerRole); err != nil {
+			c.Logger.Error(err)
+			return err
+		}
+		if pool != "" {
+			c.Logger.Infof("-> Set %s to %s in pool %s", workerRole, uuid, pool)
+		} else {
+			c.Logger.Infof("-> Set %s to %s", workerRole, uuid)
+		}
 		currentRoles[uuid] = workerRole
 	}
 
@@ -132,7 +193,13 @@ func scheduleRoles(nodes []string, c *service.RoleConfig, cc *sdkConfig.Config,
 		if r == "" || r == masterRole || r == masterHA {
 			continue
 		}
-		if err := c.Client.Set("role", uuid, r); err != nil {
+		// The pool is rendered into the args once, when the worker is deployed
+		if f, _ := GetFacts(l, uuid); r == workerRole && !f.Bootstrapped {
+			if _, err := assignWorkerPool(l, pools, uuid); err != nil {
+				c.Logger.Warnf("re-publish of the pool of %s failed: %v", uuid, err)
+			}
+		}
+		if err := l.Set("role", uuid, r); err != nil {
 			c.Logger.Warnf("re-publish of role %s for %s failed: %v", r, uuid, err)
 		}
 	}
@@ -0,0 +1,110 @@
+package role
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	"github.com/samber/lo"
+)
+
+// NodeStatus is the state of a node as seen from the ledger and from Kubernetes.
+type NodeStatus struct {
+	UUID     string `json:"uuid" yaml:"uuid"`
+	Role     string `json:"role,omitempty" yaml:"role,omitempty"`
+	Pool     string `json:"pool,omitempty" yaml:"pool,omitempty"`
+	IP       string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Leader   bool   `json:"leader" yaml:"leader"`
+	// Active is true if the node passed the network healthchecks recently
+	Active       bool   `json:"active" yaml:"active"`
+	Advertizing  bool   `json:"advertizing" yaml:"advertizing"`
+	Bootstrapped bool   `json:"bootstrapped" yaml:"bootstrapped"`
+	Distro       string `json:"distro,omitempty" yaml:"distro,omitempty"`
+	Version      string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Ready is nil when the Kubernetes node status is unknown
+	Ready *bool `json:"ready,omitempty" yaml:"ready,omitempty"`
+	// Progress is nil until the node starts bootstrapping its role
+	Progress *Progress `json:"progress,omitempty" yaml:"progress,omitempty"`
+}
+
+// ClusterStatus is the health report of a network.
+type ClusterStatus struct {
+	Leader string       `json:"leader,omitempty" yaml:"leader,omitempty"`
+	Term   uint64       `json:"term,omitempty" yaml:"term,omitempty"`
+	Nodes  []NodeStatus `json:"nodes" yaml:"nodes"`
+	// KubernetesError tells why the Kubernetes node status is unknown
+	KubernetesError string `json:"kubernetes_error,omitempty" yaml:"kubernetes_error,omitempty"`
+}
+
+// GetClusterStatus collects the status of the nodes of a network. The
+// Kubernetes node status is left out if kubectl can't reach the cluster.
+func GetClusterStatus(l ledger.Ledger, kubectl func() (*Kubectl, error)) ClusterStatus {
+	advertizing, _ := l.AdvertizingNodes()
+	assigned, _ := l.List("role")
+
+	s := ClusterStatus{}
+	// The report can't time the lease like electors do, it goes by the
+	// clock of the holder
+	if current := readLease(l); current.Holder != "" && time.Now().Before(current.Expires) {
+		s.Leader, s.Term = current.Holder, current.Term
+	}
+
+	var ready map[string]bool
+	k, err := kubectl()
+	if err == nil {
+		ready, err = k.NodeReadiness()
+	}
+	if err != nil {
+		s.KubernetesError = err.Error()
+	}
+
+	// Nodes which stopped advertizing are still listed while they hold a role
+	nodes := lo.Uniq(append(append([]string{}, advertizing...), assigned...))
+	sort.Strings(nodes)
+	active := activePeers(l)
+	for _, u := range nodes {
+		n := NodeStatus{
+			UUID:        u,
+			Leader:      u == s.Leader,
+			Advertizing: lo.Contains(advertizing, u),
+		}
+		n.Role, _ = l.Get("role", u)
+		n.IP, _ = l.Get("ip", u)
+		n.Pool, _ = l.Get("pool", u)
+		if f, ok := GetFacts(l, u); ok {
+			n.Hostname, n.Distro, n.Version, n.Bootstrapped = f.Hostname, f.Distro, f.Version, f.Bootstrapped
+		}
+		n.Active = lo.ContainsBy(active, func(p ledger.Peer) bool {
+			return (n.IP != "" && p.Address == n.IP) || (n.Hostname != "" && p.Hostname == n.Hostname)
+		})
+		if p, ok := GetProgress(l, u); ok {
+			n.Progress = &p
+			// Facts are only published by the auto role
+			if n.Distro == "" {
+				n.Distro, n.Version = p.Distro, p.Version
+			}
+		}
+		if r, ok := ready[n.IP]; ok && n.IP != "" {
+			n.Ready = &r
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	return s
+}
+
+// activePeers returns the machines which passed the network healthchecks
+// recently. They are told apart by peer ID, nodes are matched to them by
+// their VPN address, or by hostname when they publish another IP, like the
+// EIP of KubeVIP.
+func activePeers(l ledger.Ledger) []ledger.Peer {
+	actives, _ := l.ActiveNodes()
+	peers, _ := l.Peers()
+	active := []ledger.Peer{}
+	for _, id := range actives {
+		if p, ok := peers[id]; ok {
+			active = append(active, p)
+		}
+	}
+	return active
+}
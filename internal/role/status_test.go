@@ -0,0 +1,115 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cluster status", func() {
+	var (
+		network *ledger.MemoryNetwork
+		l       ledger.Ledger
+		kube    func() (*Kubectl, error)
+	)
+
+	BeforeEach(func() {
+		network = ledger.NewMemoryNetwork("a", "b", "c")
+		l = network.Node("a")
+		kube = func() (*Kubectl, error) {
+			return &Kubectl{
+				Distro: "k3s",
+				Run: func(args ...string) (string, error) {
+					return "10.1.0.1\tTrue\n10.1.0.2\tFalse\n", nil
+				},
+			}, nil
+		}
+
+		e := newElector("a", 0)
+		_, _, err := e.campaign(l, []string{"a", "b", "c"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Set("role", "a", "master")).To(Succeed())
+		Expect(l.Set("ip", "a", "10.1.0.1")).To(Succeed())
+		Expect(l.Set("role", "b", "worker")).To(Succeed())
+		Expect(l.Set("ip", "b", "10.1.0.2")).To(Succeed())
+		Expect(PublishFacts(l, "a", Facts{Hostname: "node-a", Distro: "k3s", Version: "v1.30.2+k3s1", Bootstrapped: true})).To(Succeed())
+	})
+
+	It("reports the leader and the state of every node", func() {
+		s := GetClusterStatus(l, kube)
+		Expect(s.Leader).To(Equal("a"))
+		Expect(s.Term).To(Equal(uint64(1)))
+		Expect(s.KubernetesError).To(BeEmpty())
+		Expect(s.Nodes).To(HaveLen(3))
+
+		a := s.Nodes[0]
+		Expect(a.UUID).To(Equal("a"))
+		Expect(a.Leader).To(BeTrue())
+		Expect(a.Role).To(Equal("master"))
+		Expect(a.Hostname).To(Equal("node-a"))
+		Expect(a.Distro).To(Equal("k3s"))
+		Expect(a.Version).To(Equal("v1.30.2+k3s1"))
+		Expect(a.Bootstrapped).To(BeTrue())
+		Expect(a.Ready).To(HaveValue(BeTrue()))
+
+		Expect(s.Nodes[1].Ready).To(HaveValue(BeFalse()))
+		Expect(s.Nodes[1].Bootstrapped).To(BeFalse())
+		Expect(s.Nodes[2].Ready).To(BeNil())
+	})
+
+	It("lists the nodes which hold a role but stopped advertizing", func() {
+		network.Remove("b")
+		network.Expire("b")
+		s := GetClusterStatus(l, kube)
+		Expect(s.Nodes).To(HaveLen(3))
+		Expect(s.Nodes[1].UUID).To(Equal("b"))
+		Expect(s.Nodes[1].Role).To(Equal("worker"))
+		Expect(s.Nodes[1].Advertizing).To(BeFalse())
+		Expect(s.Nodes[2].Advertizing).To(BeTrue())
+	})
+
+	It("reports the nodes which passed the healthchecks as active", func() {
+		network.Remove("b")
+		s := GetClusterStatus(l, kube)
+		Expect(s.Nodes[0].Active).To(BeTrue())
+		Expect(s.Nodes[1].Active).To(BeFalse())
+		Expect(s.Nodes[1].Advertizing).To(BeTrue())
+	})
+
+	It("matches the peers by hostname when nodes publish another IP", func() {
+		// A master publishing the EIP of KubeVIP
+		Expect(l.Set("ip", "a", "192.168.1.100")).To(Succeed())
+		network.SetPeer("a", ledger.Peer{Address: "10.1.0.1", Hostname: "node-a"})
+		network.SetPeer("b", ledger.Peer{Address: "10.1.0.9", Hostname: "node-b"})
+
+		s := GetClusterStatus(l, kube)
+		Expect(s.Nodes[0].Active).To(BeTrue())
+		Expect(s.Nodes[1].Active).To(BeFalse())
+	})
+
+	It("doesn't report an expired lease as the leader", func() {
+		dat, err := json.Marshal(lease{Holder: "b", Term: 3, Expires: time.Now().Add(-time.Minute)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Set("auto", "lease", string(dat))).To(Succeed())
+
+		s := GetClusterStatus(l, kube)
+		Expect(s.Leader).To(BeEmpty())
+		Expect(s.Nodes[1].Leader).To(BeFalse())
+	})
+
+	It("reports why Kubernetes couldn't be reached", func() {
+		s := GetClusterStatus(l, func() (*Kubectl, error) { return nil, errors.New("no kubeconfig") })
+		Expect(s.KubernetesError).To(Equal("no kubeconfig"))
+		Expect(s.Nodes[0].Ready).To(BeNil())
+	})
+
+	It("parses the version of the distributions", func() {
+		Expect(parseVersion("k3s version v1.30.2+k3s1 (aa4794b3)\ngo version go1.22.4\n")).To(Equal("v1.30.2+k3s1"))
+		Expect(parseVersion("v1.30.2+k0s.0\n")).To(Equal("v1.30.2+k0s.0"))
+		Expect(parseVersion("")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,130 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// BootstrapStateFile records the progress of the bootstrap of the node role.
+var BootstrapStateFile = "/usr/local/.kairos/bootstrap.json"
+
+// ErrStepPending is returned by a step which can't run yet, e.g. because
+// the data it needs wasn't published yet. It is retried on the next round
+// and is not recorded as a failure.
+var ErrStepPending = errors.New("step pending")
+
+// StepPending returns an ErrStepPending telling why the step can't run yet.
+func StepPending(reason string) error {
+	return fmt.Errorf("%w: %s", ErrStepPending, reason)
+}
+
+// StepsDone is the current step of a bootstrap which completed.
+const StepsDone = "done"
+
+// Step is a named part of a bootstrap sequence.
+type Step struct {
+	Name string
+	Run  func() error
+}
+
+// BootstrapState is the persisted progress of a bootstrap sequence.
+type BootstrapState struct {
+	Role      string   `json:"role"`
+	Completed []string `json:"completed"`
+	// Current is the step running or waiting, or StepsDone
+	Current string `json:"current,omitempty"`
+	// Pending tells why the current step is waiting
+	Pending   string    `json:"pending,omitempty"`
+	Failed    string    `json:"failed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Done returns true if the given step completed.
+func (s BootstrapState) Done(step string) bool {
+	return lo.Contains(s.Completed, step)
+}
+
+// ReadBootstrapState returns the bootstrap progress recorded in file.
+func ReadBootstrapState(file string) (BootstrapState, error) {
+	var s BootstrapState
+	dat, err := os.ReadFile(file)
+	if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(dat, &s)
+}
+
+func (s BootstrapState) write(file string) error {
+	dat, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// Write and rename, so a crash never leaves a truncated state behind
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, dat, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// RunSteps runs the steps of the bootstrap of role in order, skipping the
+// ones which completed on a previous attempt, and records each completed
+// step to the state file. The progress of another role is discarded. A
+// failure is recorded and returned along with the name of the step.
+func RunSteps(file, role string, steps []Step) error {
+	now := time.Now()
+	state, err := ReadBootstrapState(file)
+	if err != nil || state.Role != role {
+		state = BootstrapState{Role: role, StartedAt: now}
+	}
+
+	for _, step := range steps {
+		if state.Done(step.Name) {
+			continue
+		}
+
+		err := step.Run()
+		switch {
+		case errors.Is(err, ErrStepPending):
+			// Steps wait for many rounds, only record when the reason changes
+			reason := strings.TrimPrefix(err.Error(), ErrStepPending.Error()+": ")
+			if state.Current == step.Name && state.Pending == reason {
+				return nil
+			}
+			state.Current, state.Pending, state.UpdatedAt = step.Name, reason, time.Now()
+			return state.write(file)
+		case err != nil:
+			state.Current, state.Pending, state.UpdatedAt = step.Name, "", time.Now()
+			state.Failed, state.Error = step.Name, err.Error()
+			if werr := state.write(file); werr != nil {
+				err = errors.Join(err, werr)
+			}
+			return fmt.Errorf("bootstrap step %q failed: %w", step.Name, err)
+		}
+
+		state.Completed = append(state.Completed, step.Name)
+		state.Current, state.Pending, state.UpdatedAt = "", "", time.Now()
+		state.Failed, state.Error = "", ""
+		if err := state.write(file); err != nil {
+			return fmt.Errorf("recording bootstrap step %q: %w", step.Name, err)
+		}
+	}
+
+	if state.Current != StepsDone {
+		state.Current, state.UpdatedAt = StepsDone, time.Now()
+		return state.write(file)
+	}
+	return nil
+}
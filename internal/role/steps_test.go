@@ -0,0 +1,91 @@
+package role
+
+import (
+	"errors"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bootstrap steps", func() {
+	var (
+		file  string
+		ran   []string
+		fail  map[string]error
+		steps []Step
+	)
+
+	step := func(name string) Step {
+		return Step{Name: name, Run: func() error {
+			if err := fail[name]; err != nil {
+				return err
+			}
+			ran = append(ran, name)
+			return nil
+		}}
+	}
+
+	BeforeEach(func() {
+		file = filepath.Join(GinkgoT().TempDir(), "bootstrap.json")
+		ran = []string{}
+		fail = map[string]error{}
+		steps = []Step{step("env"), step("start"), step("sentinel")}
+	})
+
+	It("runs every step and records them", func() {
+		Expect(RunSteps(file, "worker", steps)).To(Succeed())
+		Expect(ran).To(Equal([]string{"env", "start", "sentinel"}))
+
+		state, err := ReadBootstrapState(file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.Role).To(Equal("worker"))
+		Expect(state.Completed).To(Equal([]string{"env", "start", "sentinel"}))
+		Expect(state.Current).To(Equal(StepsDone))
+		Expect(state.Failed).To(BeEmpty())
+	})
+
+	It("resumes where it stopped, reporting the failed step", func() {
+		fail["start"] = errors.New("unit not found")
+		err := RunSteps(file, "worker", steps)
+		Expect(err).To(MatchError(ContainSubstring(`bootstrap step "start" failed: unit not found`)))
+
+		state, _ := ReadBootstrapState(file)
+		Expect(state.Completed).To(Equal([]string{"env"}))
+		Expect(state.Failed).To(Equal("start"))
+		Expect(state.Error).To(Equal("unit not found"))
+
+		delete(fail, "start")
+		Expect(RunSteps(file, "worker", steps)).To(Succeed())
+		Expect(ran).To(Equal([]string{"env", "start", "sentinel"}))
+
+		state, _ = ReadBootstrapState(file)
+		Expect(state.Failed).To(BeEmpty())
+		Expect(state.Error).To(BeEmpty())
+	})
+
+	It("waits on pending steps without recording a failure", func() {
+		fail["start"] = StepPending("no join token yet")
+		Expect(RunSteps(file, "worker", steps)).To(Succeed())
+		Expect(ran).To(Equal([]string{"env"}))
+
+		state, _ := ReadBootstrapState(file)
+		Expect(state.Failed).To(BeEmpty())
+		Expect(state.Current).To(Equal("start"))
+		Expect(state.Pending).To(Equal("no join token yet"))
+
+		delete(fail, "start")
+		Expect(RunSteps(file, "worker", steps)).To(Succeed())
+		state, _ = ReadBootstrapState(file)
+		Expect(state.Pending).To(BeEmpty())
+	})
+
+	It("starts over when the role changed", func() {
+		Expect(RunSteps(file, "master/ha", steps)).To(Succeed())
+		Expect(RunSteps(file, "master/clusterinit", steps)).To(Succeed())
+		Expect(ran).To(HaveLen(6))
+
+		state, _ := ReadBootstrapState(file)
+		Expect(state.Role).To(Equal("master/clusterinit"))
+	})
+})
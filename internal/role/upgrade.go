@@ -0,0 +1,371 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+)
+
+// UpgradedBinDir is where upgrades install the distribution binaries, /usr/bin
+// is part of the read only image.
+const UpgradedBinDir = "/usr/local/bin"
+
+// DefaultUpgradeNodeTimeout is how long a node has to upgrade and come back
+// Ready before the rollout fails.
+const DefaultUpgradeNodeTimeout = 30 * time.Minute
+
+// Phases of a rollout, stored under upgrade/plan
+const (
+	UpgradeRunning = "running"
+	UpgradeDone    = "done"
+	UpgradeFailed  = "failed"
+)
+
+// Phases of the upgrade of a node, stored under upgrade-status/<uuid>
+const (
+	NodeUpgradeInstalling = "installing"
+	NodeUpgradeRestarting = "restarting"
+	NodeUpgradeDone       = "done"
+	NodeUpgradeFailed     = "failed"
+)
+
+// UpgradeRequest asks the leader to roll out a version of the distribution.
+type UpgradeRequest struct {
+	Version string `json:"version" yaml:"version"`
+	// Source is a mirror of the release artifacts, as an http(s) or file:// URL
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// Checksums are the sha256sum lists of the upstream release, which the
+	// artifacts are verified against wherever they are fetched from
+	Checksums   string    `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+	RequestedAt time.Time `json:"requested_at" yaml:"requested_at"`
+}
+
+// UpgradePlan is the rollout of a version the leader publishes. Nodes
+// upgrade one at a time, the masters first.
+type UpgradePlan struct {
+	Version   string `json:"version" yaml:"version"`
+	Source    string `json:"source,omitempty" yaml:"source,omitempty"`
+	Checksums string `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+	Phase     string `json:"phase" yaml:"phase"`
+	// Node is the node whose turn it is, cordoned and drained
+	Node string `json:"node,omitempty" yaml:"node,omitempty"`
+	// NodeSince is when the node got its turn
+	NodeSince time.Time `json:"node_since,omitempty" yaml:"node_since,omitempty"`
+	// Waiting tells why the rollout isn't moving on to the next node
+	Waiting     string    `json:"waiting,omitempty" yaml:"waiting,omitempty"`
+	Error       string    `json:"error,omitempty" yaml:"error,omitempty"`
+	RequestedAt time.Time `json:"requested_at" yaml:"requested_at"`
+	UpdatedAt   time.Time `json:"updated_at" yaml:"updated_at"`
+}
+
+// NodeUpgrade is the upgrade progress a node publishes when it has its turn.
+type NodeUpgrade struct {
+	Version   string    `json:"version" yaml:"version"`
+	Phase     string    `json:"phase" yaml:"phase"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at" yaml:"updated_at"`
+}
+
+// UpgradedBin returns the path of the binary of the distribution installed
+// by an upgrade, or an empty string if the node was never upgraded.
+func UpgradedBin(distro string) string {
+	p := filepath.Join(UpgradedBinDir, distro)
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// RequestUpgrade asks the leader to roll out a version of the distribution
+// to every node of the cluster. It supersedes any previous request. The
+// request is sealed with the cluster secret, and nodes only install
+// artifacts matching the given checksums.
+func RequestUpgrade(l ledger.Ledger, version, source, checksums string) error {
+	if !ledger.IsSealing(l) {
+		return errors.New("upgrades require the cluster secret, the requests are sealed with it")
+	}
+	if checksums == "" {
+		return errors.New("upgrades require the checksums of the release")
+	}
+	return setJSON(l, "upgrade", "request", UpgradeRequest{Version: version, Source: source, Checksums: checksums, RequestedAt: time.Now()})
+}
+
+func getJSON[T any](l ledger.Ledger, thing, key string) (T, bool) {
+	var v T
+	raw, _ := l.Get(thing, key)
+	if raw == "" {
+		return v, false
+	}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+func setJSON(l ledger.Ledger, thing, key string, v any) error {
+	dat, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return l.Set(thing, key, string(dat))
+}
+
+// GetUpgradePlan returns the rollout published by the leader, and whether there is one.
+func GetUpgradePlan(l ledger.Ledger) (UpgradePlan, bool) {
+	return getJSON[UpgradePlan](l, "upgrade", "plan")
+}
+
+// GetNodeUpgrade returns the upgrade progress published by a node, and whether there was any.
+func GetNodeUpgrade(l ledger.Ledger, uuid string) (NodeUpgrade, bool) {
+	return getJSON[NodeUpgrade](l, "upgrade-status", uuid)
+}
+
+// PublishNodeUpgrade writes the upgrade progress of a node.
+func PublishNodeUpgrade(l ledger.Ledger, uuid string, u NodeUpgrade) error {
+	u.UpdatedAt = time.Now()
+	return setJSON(l, "upgrade-status", uuid, u)
+}
+
+// sameKubeVersion compares versions ignoring the build metadata, which
+// differs between the distribution and the kubelet, e.g. v1.30.2+k0s.0 and
+// v1.30.2+k0s.
+func sameKubeVersion(a, b string) bool {
+	a, _, _ = strings.Cut(a, "+")
+	b, _, _ = strings.Cut(b, "+")
+	return a != "" && a == b
+}
+
+// upgraded returns true if the node runs the version of the plan.
+func upgraded(l ledger.Ledger, uuid string, plan UpgradePlan) bool {
+	if u, ok := GetNodeUpgrade(l, uuid); ok && u.Version == plan.Version && u.Phase == NodeUpgradeDone {
+		return true
+	}
+	f, ok := GetFacts(l, uuid)
+	return ok && f.Version == plan.Version
+}
+
+// upgradeOrder returns the nodes with a role in the order they upgrade:
+// the masters first, starting from the one initializing the cluster.
+func upgradeOrder(l ledger.Ledger, nodes []string) []string {
+	rank := map[string]int{"master/clusterinit": 0, "master": 1, "master/ha": 2, "worker": 3}
+	_, roles := getRoles(l, nodes)
+	order := []string{}
+	for _, u := range nodes {
+		if _, ok := rank[roles[u]]; ok {
+			order = append(order, u)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if rank[roles[order[i]]] != rank[roles[order[j]]] {
+			return rank[roles[order[i]]] < rank[roles[order[j]]]
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
+
+// upgradeNodes moves the rollout of the requested version forward. The
+// node whose turn it is gets cordoned and drained, then upgrades itself.
+// Once it reports back and its Kubernetes node is Ready with the new
+// version, it is uncordoned and the next node gets its turn.
+func upgradeNodes(nodes []string, c *service.RoleConfig, l ledger.Ledger, kubectl func() (*Kubectl, error)) error {
+	// Without the cluster secret, anyone on the network could roll out binaries
+	if !ledger.IsSealing(l) {
+		return nil
+	}
+	req, requested := getJSON[UpgradeRequest](l, "upgrade", "request")
+	plan, planned := GetUpgradePlan(l)
+	if !requested && !planned {
+		return nil
+	}
+
+	superseded := requested && (!planned || req.RequestedAt.After(plan.RequestedAt))
+	start := func() error {
+		c.Logger.Infof("Rolling out %s", req.Version)
+		plan = UpgradePlan{Version: req.Version, Source: req.Source, Checksums: req.Checksums, Phase: UpgradeRunning, RequestedAt: req.RequestedAt}
+		if plan.Checksums == "" {
+			plan.Phase, plan.Error = UpgradeFailed, "the request doesn't pin the checksums of the release"
+			c.Logger.Errorf("Upgrade to %s refused: %s", plan.Version, plan.Error)
+		}
+		return savePlan(l, &plan)
+	}
+	switch {
+	case superseded && plan.Phase == UpgradeRunning && plan.Node != "":
+		c.Logger.Infof("Upgrade to %s requested, waiting for '%s' to finish upgrading to %s", req.Version, plan.Node, plan.Version)
+	case superseded:
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	if plan.Phase != UpgradeRunning {
+		return nil
+	}
+
+	if plan.Node != "" {
+		done, waiting, err := checkUpgradedNode(l, plan, kubectl, time.Now())
+		if err != nil {
+			plan.Phase, plan.Error = UpgradeFailed, err.Error()
+			c.Logger.Errorf("Upgrade to %s stopped: %s", plan.Version, plan.Error)
+			return savePlan(l, &plan)
+		}
+		if !done {
+			return waitPlan(l, &plan, waiting)
+		}
+		c.Logger.Infof("'%s' upgraded to %s", plan.Node, plan.Version)
+		plan.Node, plan.NodeSince, plan.Waiting = "", time.Time{}, ""
+		if superseded {
+			return start()
+		}
+	}
+
+	next := ""
+	for _, u := range upgradeOrder(l, nodes) {
+		if !upgraded(l, u, plan) {
+			next = u
+			break
+		}
+	}
+	if next == "" {
+		c.Logger.Infof("Every node runs %s, upgrade done", plan.Version)
+		plan.Phase = UpgradeDone
+		return savePlan(l, &plan)
+	}
+
+	k, err := kubectl()
+	if err == nil {
+		ip, _ := l.Get("ip", next)
+		var name string
+		if name, err = k.NodeName(ip); err == nil && name != "" {
+			err = cordonAndDrain(k, name)
+		}
+	}
+	if err != nil {
+		c.Logger.Warnf("Failed draining '%s' before upgrading it, retrying: %s", next, err.Error())
+		return waitPlan(l, &plan, fmt.Sprintf("draining %s: %s", next, err.Error()))
+	}
+
+	// A previous attempt on the node must not be mistaken for this one
+	if err := l.Delete("upgrade-status", next); err != nil {
+		return err
+	}
+	c.Logger.Infof("Upgrading '%s' to %s", next, plan.Version)
+	plan.Node, plan.NodeSince, plan.Waiting = next, time.Now(), ""
+	return savePlan(l, &plan)
+}
+
+// checkUpgradedNode returns whether the node whose turn it is upgraded
+// and is healthy, or else what the rollout is waiting for. An error is
+// returned if the node failed upgrading, or is still not done after
+// DefaultUpgradeNodeTimeout.
+func checkUpgradedNode(l ledger.Ledger, plan UpgradePlan, kubectl func() (*Kubectl, error), now time.Time) (bool, string, error) {
+	done, waiting, err := upgradedNodeState(l, plan, kubectl)
+	if err == nil && !done && !plan.NodeSince.IsZero() && now.Sub(plan.NodeSince) > DefaultUpgradeNodeTimeout {
+		return false, "", fmt.Errorf("%s didn't upgrade within %s, waiting for %s", plan.Node, DefaultUpgradeNodeTimeout, waiting)
+	}
+	return done, waiting, err
+}
+
+func upgradedNodeState(l ledger.Ledger, plan UpgradePlan, kubectl func() (*Kubectl, error)) (bool, string, error) {
+	u, ok := GetNodeUpgrade(l, plan.Node)
+	switch {
+	case !ok || u.Version != plan.Version:
+		return false, fmt.Sprintf("%s to start upgrading", plan.Node), nil
+	case u.Phase == NodeUpgradeFailed:
+		return false, "", fmt.Errorf("%s failed upgrading: %s", plan.Node, u.Error)
+	case u.Phase != NodeUpgradeDone:
+		return false, fmt.Sprintf("%s to finish %s", plan.Node, u.Phase), nil
+	}
+
+	k, err := kubectl()
+	if err != nil {
+		return false, fmt.Sprintf("the Kubernetes node of %s: %s", plan.Node, err.Error()), nil
+	}
+	ip, _ := l.Get("ip", plan.Node)
+	ready, err := k.NodeReadiness()
+	if err != nil || !ready[ip] {
+		return false, fmt.Sprintf("the Kubernetes node of %s to be Ready", plan.Node), nil
+	}
+	versions, err := k.NodeVersions()
+	if err != nil || !sameKubeVersion(versions[ip], plan.Version) {
+		return false, fmt.Sprintf("the Kubernetes node of %s to run %s", plan.Node, plan.Version), nil
+	}
+
+	name, err := k.NodeName(ip)
+	if err == nil && name != "" {
+		_, err = k.Run("uncordon", name)
+	}
+	if err != nil {
+		return false, fmt.Sprintf("uncordoning %s", plan.Node), nil
+	}
+	return true, "", nil
+}
+
+func savePlan(l ledger.Ledger, plan *UpgradePlan) error {
+	plan.UpdatedAt = time.Now()
+	return setJSON(l, "upgrade", "plan", plan)
+}
+
+// waitPlan records why the rollout waits, rollouts wait for many rounds so
+// only when the reason changes.
+func waitPlan(l ledger.Ledger, plan *UpgradePlan, waiting string) error {
+	if plan.Waiting == waiting {
+		return nil
+	}
+	plan.Waiting = waiting
+	return savePlan(l, plan)
+}
+
+// NodeUpgradeStatus is the upgrade state of a node of the cluster.
+type NodeUpgradeStatus struct {
+	UUID     string `json:"uuid" yaml:"uuid"`
+	Role     string `json:"role,omitempty" yaml:"role,omitempty"`
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	// Version is the one the node advertises in its facts
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Upgrade is nil until the node gets its turn
+	Upgrade *NodeUpgrade `json:"upgrade,omitempty" yaml:"upgrade,omitempty"`
+}
+
+// UpgradeStatus is the state of the rollout of a version to the cluster.
+type UpgradeStatus struct {
+	// Request is nil if no upgrade was ever requested
+	Request *UpgradeRequest `json:"request,omitempty" yaml:"request,omitempty"`
+	// Plan is nil until the leader picks up the request
+	Plan  *UpgradePlan        `json:"plan,omitempty" yaml:"plan,omitempty"`
+	Nodes []NodeUpgradeStatus `json:"nodes" yaml:"nodes"`
+}
+
+// GetUpgradeStatus collects the rollout state of the nodes of a network,
+// in the order they upgrade.
+func GetUpgradeStatus(l ledger.Ledger) UpgradeStatus {
+	s := UpgradeStatus{}
+	if r, ok := getJSON[UpgradeRequest](l, "upgrade", "request"); ok {
+		s.Request = &r
+	}
+	if p, ok := GetUpgradePlan(l); ok {
+		s.Plan = &p
+	}
+
+	advertizing, _ := l.AdvertizingNodes()
+	for _, u := range upgradeOrder(l, advertizing) {
+		n := NodeUpgradeStatus{UUID: u}
+		n.Role, _ = l.Get("role", u)
+		if f, ok := GetFacts(l, u); ok {
+			n.Hostname, n.Version = f.Hostname, f.Version
+		}
+		if up, ok := GetNodeUpgrade(l, u); ok {
+			n.Upgrade = &up
+		}
+		s.Nodes = append(s.Nodes, n)
+	}
+	return s
+}
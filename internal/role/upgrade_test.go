@@ -0,0 +1,230 @@
+package role
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/kairos-io/provider-kairos/v2/internal/role/ledger"
+	service "github.com/mudler/edgevpn/api/client/service"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testChecksums = "0123456789abcdef  k3s\n"
+
+var _ = Describe("Upgrades", func() {
+	var (
+		raw      ledger.Ledger
+		l        ledger.Ledger
+		c        *service.RoleConfig
+		calls    []string
+		versions map[string]string
+		ready    map[string]string
+		kube     func() (*Kubectl, error)
+		nodes    = []string{"worker", "ha", "init"}
+		ips      = map[string]string{"init": "10.1.0.1", "ha": "10.1.0.2", "worker": "10.1.0.3"}
+	)
+
+	// round runs a leadership round and returns the plan it left
+	round := func() UpgradePlan {
+		ExpectWithOffset(1, upgradeNodes(nodes, c, l, kube)).To(Succeed())
+		plan, ok := GetUpgradePlan(l)
+		ExpectWithOffset(1, ok).To(BeTrue())
+		return plan
+	}
+
+	// upgrade has the node upgrade and its Kubernetes node report the version
+	upgrade := func(uuid, version string) {
+		ExpectWithOffset(1, PublishNodeUpgrade(l, uuid, NodeUpgrade{Version: version, Phase: NodeUpgradeDone})).To(Succeed())
+		versions[ips[uuid]] = strings.Replace(version, "+k3s1", "+k3s", 1)
+	}
+
+	BeforeEach(func() {
+		logging.SetLogLevel("role-test", "fatal") //nolint:errcheck
+		raw = ledger.NewMemoryNetwork("leader").Node("leader")
+		keys, err := ledger.NewKeyring("token", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		l = ledger.NewSealed(raw, keys)
+		c = &service.RoleConfig{UUID: "leader", Logger: logging.Logger("role-test")}
+		calls = []string{}
+		versions = map[string]string{}
+		ready = map[string]string{}
+		for _, ip := range ips {
+			versions[ip], ready[ip] = "v1.29.6+k3s", "True"
+		}
+		kube = func() (*Kubectl, error) {
+			return &Kubectl{
+				Distro: "k3s",
+				Run: func(args ...string) (string, error) {
+					if args[0] != "get" {
+						calls = append(calls, strings.Join(args, " "))
+						return "", nil
+					}
+					out := ""
+					for _, u := range []string{"init", "ha", "worker"} {
+						ip := ips[u]
+						switch {
+						case strings.Contains(args[3], "kubeletVersion"):
+							out += fmt.Sprintf("%s\t%s\n", ip, versions[ip])
+						case strings.Contains(args[3], "Ready"):
+							out += fmt.Sprintf("%s\t%s\n", ip, ready[ip])
+						default:
+							out += fmt.Sprintf("node-%s\t%s\n", u, ip)
+						}
+					}
+					return out, nil
+				},
+			}, nil
+		}
+		for u, r := range map[string]string{"init": "master/clusterinit", "ha": "master/ha", "worker": "worker"} {
+			Expect(l.Set("role", u, r)).To(Succeed())
+			Expect(l.Set("ip", u, ips[u])).To(Succeed())
+		}
+	})
+
+	It("does nothing without a request", func() {
+		Expect(upgradeNodes(nodes, c, l, kube)).To(Succeed())
+		_, ok := GetUpgradePlan(l)
+		Expect(ok).To(BeFalse())
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("upgrades the masters first, one node at a time", func() {
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "", testChecksums)).To(Succeed())
+
+		plan := round()
+		Expect(plan.Phase).To(Equal(UpgradeRunning))
+		Expect(plan.Node).To(Equal("init"))
+		Expect(calls).To(Equal([]string{
+			"cordon node-init",
+			"drain node-init --ignore-daemonsets --delete-emptydir-data --force --timeout=30s",
+		}))
+
+		// The node didn't upgrade yet
+		calls = []string{}
+		plan = round()
+		Expect(plan.Node).To(Equal("init"))
+		Expect(plan.Waiting).To(Equal("init to start upgrading"))
+		Expect(calls).To(BeEmpty())
+
+		// Upgraded, but the Kubernetes node isn't Ready
+		upgrade("init", "v1.30.2+k3s1")
+		ready["10.1.0.1"] = "False"
+		plan = round()
+		Expect(plan.Node).To(Equal("init"))
+		Expect(plan.Waiting).To(Equal("the Kubernetes node of init to be Ready"))
+
+		ready["10.1.0.1"] = "True"
+		plan = round()
+		Expect(plan.Node).To(Equal("ha"))
+		Expect(plan.Waiting).To(BeEmpty())
+		Expect(calls).To(ContainElements("uncordon node-init", "cordon node-ha"))
+
+		upgrade("ha", "v1.30.2+k3s1")
+		Expect(round().Node).To(Equal("worker"))
+
+		upgrade("worker", "v1.30.2+k3s1")
+		plan = round()
+		Expect(plan.Phase).To(Equal(UpgradeDone))
+		Expect(plan.Node).To(BeEmpty())
+		Expect(calls).To(ContainElement("uncordon node-worker"))
+
+		s := GetUpgradeStatus(l)
+		Expect(s.Plan.Phase).To(Equal(UpgradeDone))
+	})
+
+	It("skips the nodes already running the version", func() {
+		Expect(PublishFacts(l, "init", Facts{Version: "v1.30.2+k3s1"})).To(Succeed())
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "", testChecksums)).To(Succeed())
+		Expect(round().Node).To(Equal("ha"))
+	})
+
+	It("stops at the first node failing to upgrade, until requested again", func() {
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "https://mirror", testChecksums)).To(Succeed())
+		plan := round()
+		Expect(plan.Source).To(Equal("https://mirror"))
+
+		Expect(PublishNodeUpgrade(l, "init", NodeUpgrade{Version: "v1.30.2+k3s1", Phase: NodeUpgradeFailed, Error: "checksum mismatch"})).To(Succeed())
+		plan = round()
+		Expect(plan.Phase).To(Equal(UpgradeFailed))
+		Expect(plan.Error).To(Equal("init failed upgrading: checksum mismatch"))
+
+		calls = []string{}
+		Expect(round().Phase).To(Equal(UpgradeFailed))
+		Expect(calls).To(BeEmpty())
+
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "", testChecksums)).To(Succeed())
+		plan = round()
+		Expect(plan.Phase).To(Equal(UpgradeRunning))
+		Expect(plan.Node).To(Equal("init"))
+		// The failed attempt is cleared for the node to try again
+		_, ok := GetNodeUpgrade(l, "init")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("lets the node upgrading finish before rolling out another version", func() {
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "", testChecksums)).To(Succeed())
+		Expect(round().Node).To(Equal("init"))
+
+		Expect(RequestUpgrade(l, "v1.30.3+k3s1", "", testChecksums)).To(Succeed())
+		plan := round()
+		Expect(plan.Version).To(Equal("v1.30.2+k3s1"))
+		Expect(plan.Node).To(Equal("init"))
+
+		upgrade("init", "v1.30.2+k3s1")
+		plan = round()
+		Expect(plan.Version).To(Equal("v1.30.3+k3s1"))
+		Expect(plan.Node).To(BeEmpty())
+
+		plan = round()
+		Expect(plan.Node).To(Equal("init"))
+	})
+
+	It("pins the checksums of the release in the plan", func() {
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "https://mirror", testChecksums)).To(Succeed())
+		Expect(round().Checksums).To(Equal(testChecksums))
+
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "https://mirror", "")).ToNot(Succeed())
+	})
+
+	It("ignores the requests which are not sealed with the cluster secret", func() {
+		Expect(raw.Set("upgrade", "request", `{"version":"v1.30.2+k3s1","source":"https://attacker","checksums":"x","requested_at":"2026-01-01T00:00:00Z"}`)).To(Succeed())
+		Expect(upgradeNodes(nodes, c, l, kube)).To(Succeed())
+		_, ok := GetUpgradePlan(l)
+		Expect(ok).To(BeFalse())
+		Expect(calls).To(BeEmpty())
+
+		// Nor rolls out anything without the cluster secret
+		Expect(RequestUpgrade(raw, "v1.30.2+k3s1", "", testChecksums)).ToNot(Succeed())
+		Expect(upgradeNodes(nodes, c, raw, kube)).To(Succeed())
+		Expect(calls).To(BeEmpty())
+	})
+
+	It("fails the rollout when a node doesn't upgrade in time", func() {
+		Expect(RequestUpgrade(l, "v1.30.2+k3s1", "", testChecksums)).To(Succeed())
+		plan := round()
+		Expect(plan.Node).To(Equal("init"))
+		Expect(plan.NodeSince).ToNot(BeZero())
+
+		done, _, err := checkUpgradedNode(l, plan, kube, plan.NodeSince.Add(DefaultUpgradeNodeTimeout/2))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+
+		_, _, err = checkUpgradedNode(l, plan, kube, plan.NodeSince.Add(DefaultUpgradeNodeTimeout+time.Minute))
+		Expect(err).To(MatchError(ContainSubstring("init didn't upgrade within 30m0s, waiting for init to start upgrading")))
+
+		plan.NodeSince = time.Now().Add(-DefaultUpgradeNodeTimeout - time.Minute)
+		Expect(savePlan(l, &plan)).To(Succeed())
+		plan = round()
+		Expect(plan.Phase).To(Equal(UpgradeFailed))
+		Expect(plan.Error).To(ContainSubstring("didn't upgrade within"))
+	})
+
+	It("compares versions without the build metadata", func() {
+		Expect(sameKubeVersion("v1.30.2+k0s.0", "v1.30.2+k0s")).To(BeTrue())
+		Expect(sameKubeVersion("v1.30.2+k3s1", "v1.30.3+k3s1")).To(BeFalse())
+		Expect(sameKubeVersion("", "")).To(BeFalse())
+	})
+})
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"github.com/kairos-io/kairos-sdk/machine/openrc"
+	"github.com/kairos-io/kairos-sdk/machine/systemd"
+	loggerpkg "github.com/kairos-io/kairos-sdk/types/logger"
+	"github.com/kairos-io/kairos-sdk/utils"
+)
+
+// K3s Services start here
+
+const K3sSystemd = `[Unit]
+Description=Lightweight Kubernetes
+Documentation=https://k3s.io
+Wants=network-online.target
+After=network-online.target
+
+[Install]
+WantedBy=multi-user.target
+
+[Service]
+Type=notify
+EnvironmentFile=-/etc/default/%N
+EnvironmentFile=-/etc/sysconfig/%N
+KillMode=process
+Delegate=yes
+LimitNOFILE=1048576
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+TimeoutStartSec=0
+Restart=always
+RestartSec=5s
+ExecStartPre=-/sbin/modprobe br_netfilter
+ExecStartPre=-/sbin/modprobe overlay
+ExecStart=/usr/bin/k3s server`
+
+const K3sAgentSystemd = `[Unit]
+Description=Lightweight Kubernetes
+Documentation=https://k3s.io
+Wants=network-online.target
+After=network-online.target
+
+[Install]
+WantedBy=multi-user.target
+
+[Service]
+Type=exec
+EnvironmentFile=-/etc/default/%N
+EnvironmentFile=-/etc/sysconfig/%N
+KillMode=process
+Delegate=yes
+LimitNOFILE=1048576
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+TimeoutStartSec=0
+Restart=always
+RestartSec=5s
+ExecStartPre=-/sbin/modprobe br_netfilter
+ExecStartPre=-/sbin/modprobe overlay
+ExecStart=/usr/bin/k3s agent`
+
+const K3sOpenrc = `#!/sbin/openrc-run
+
+depend() {
+	after network-online
+	want cgroups
+}
+
+supervisor=supervise-daemon
+name=k3s
+command="/usr/bin/k3s"
+command_args="server"
+output_log=/var/log/k3s.log
+error_log=/var/log/k3s.log
+pidfile="/var/run/k3s.pid"
+respawn_delay=5
+respawn_max=0
+
+set -o allexport
+if [ -f /etc/environment ]; then . /etc/environment; fi
+if [ -f /etc/rancher/k3s/k3s.env ]; then . /etc/rancher/k3s/k3s.env; fi
+set +o allexport`
+
+const K3sAgentOpenrc = `#!/sbin/openrc-run
+
+depend() {
+	after network-online
+	want cgroups
+}
+
+supervisor=supervise-daemon
+name=k3s-agent
+command="/usr/bin/k3s"
+command_args="agent"
+output_log=/var/log/k3s-agent.log
+error_log=/var/log/k3s-agent.log
+pidfile="/var/run/k3s-agent.pid"
+respawn_delay=5
+respawn_max=0
+
+set -o allexport
+if [ -f /etc/environment ]; then . /etc/environment; fi
+if [ -f /etc/rancher/k3s/k3s-agent.env ]; then . /etc/rancher/k3s/k3s-agent.env; fi
+set +o allexport`
+
+// K3s Services end here
+
+// K3sServices creates the k3s server and agent services for openrc or systemd based systems.
+func K3sServices(logger loggerpkg.KairosLogger) error {
+	units := map[string][2]string{
+		"k3s":       {K3sOpenrc, K3sSystemd},
+		"k3s-agent": {K3sAgentOpenrc, K3sAgentSystemd},
+	}
+
+	for name, content := range units {
+		if utils.IsOpenRCBased() {
+			svc, err := openrc.NewService(
+				openrc.WithName(name),
+				openrc.WithUnitContent(content[0]),
+			)
+			if err != nil {
+				logger.Logger.Error().Err(err).Str("init", "openrc").Msgf("Failed to create %s service", name)
+				return err
+			}
+			if err = svc.WriteUnit(); err != nil {
+				logger.Logger.Error().Err(err).Str("init", "openrc").Msgf("Failed to write %s service unit", name)
+				return err
+			}
+			continue
+		}
+
+		svc, err := systemd.NewService(
+			systemd.WithName(name),
+			systemd.WithUnitContent(content[1]),
+			systemd.WithReload(false), // we are not in a running system, so we cant reload
+		)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("init", "systemd").Msgf("Failed to create %s service", name)
+			return err
+		}
+		if err = svc.WriteUnit(); err != nil {
+			logger.Logger.Error().Err(err).Str("init", "systemd").Msgf("Failed to write %s service unit", name)
+			return err
+		}
+	}
+
+	return nil
+}